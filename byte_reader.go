@@ -0,0 +1,129 @@
+package utilities
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrTruncated is returned when a read extends past the end of the input
+var ErrTruncated = errors.New("Truncated input")
+
+// DefaultMaxReadLength is the largest single read a ByteReader allows
+// unless MaxLength is set
+const DefaultMaxReadLength = 1 << 24
+
+// ByteReader reads compiled binaries by slicing windows of the input
+// Slices returned by ReadNBytes share memory with the input and must not be modified
+type ByteReader struct {
+	Bytes    []byte
+	Position uint64
+
+	// MaxLength guards against corrupt length prefixes
+	// Reads longer than MaxLength fail without reading. Zero means DefaultMaxReadLength
+	MaxLength uint64
+}
+
+// NewByteReader returns a ByteReader positioned at the start of b
+func NewByteReader(b []byte) *ByteReader {
+	return &ByteReader{Bytes: b}
+}
+
+// ReadNBytes returns the next n bytes and advances past them
+// The position is not advanced if fewer than n bytes remain
+func (br *ByteReader) ReadNBytes(n uint64) ([]byte, error) {
+	max := br.MaxLength
+	if max == 0 {
+		max = DefaultMaxReadLength
+	}
+	if n > max {
+		return nil, fmt.Errorf("Read of %v bytes at byte %v exceeds the maximum of %v", n, br.Position, max)
+	}
+	if n > br.Remaining() {
+		return nil, ErrTruncated
+	}
+	start := br.Position
+	br.Position += n
+	return br.Bytes[start:br.Position:br.Position], nil
+}
+
+func (br *ByteReader) ReadByte() (byte, error) {
+	if br.Remaining() < 1 {
+		return 0, ErrTruncated
+	}
+	b := br.Bytes[br.Position]
+	br.Position++
+	return b, nil
+}
+
+// Uint16 reads a little endian uint16
+func (br *ByteReader) Uint16() (uint16, error) {
+	b, err := br.ReadNBytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+// Uint32 reads a little endian uint32
+func (br *ByteReader) Uint32() (uint32, error) {
+	b, err := br.ReadNBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// Uint64 reads a little endian uint64
+func (br *ByteReader) Uint64() (uint64, error) {
+	b, err := br.ReadNBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// Uvarint reads an unsigned integer encoded by binary.PutUvarint
+// The position is not advanced if the integer is truncated or overflows a uint64
+func (br *ByteReader) Uvarint() (uint64, error) {
+	if br.Remaining() == 0 {
+		return 0, ErrTruncated
+	}
+	n, size := binary.Uvarint(br.Bytes[br.Position:])
+	if size == 0 {
+		return 0, ErrTruncated
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("Varint at byte %v overflows a uint64", br.Position)
+	}
+	br.Position += uint64(size)
+	return n, nil
+}
+
+// LengthPrefixedString reads a string prefixed with its uint16 length
+// The position is not advanced if the string is truncated
+func (br *ByteReader) LengthPrefixedString() (string, error) {
+	start := br.Position
+	n, err := br.Uint16()
+	if err != nil {
+		return "", err
+	}
+	b, err := br.ReadNBytes(uint64(n))
+	if err != nil {
+		br.Position = start
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Remaining returns the number of unread bytes
+func (br *ByteReader) Remaining() uint64 {
+	if br.Position >= uint64(len(br.Bytes)) {
+		return 0
+	}
+	return uint64(len(br.Bytes)) - br.Position
+}
+
+func (br *ByteReader) Finished() bool {
+	return br.Remaining() == 0
+}
@@ -0,0 +1,99 @@
+package utilities
+
+import (
+	"testing"
+)
+
+func TestByteReader(t *testing.T) {
+	r := NewByteReader([]byte{
+		7,
+		1, 0,
+		2, 0, 0, 0,
+		3, 0, 0, 0, 0, 0, 0, 0,
+		5, 0, 'h', 'e', 'l', 'l', 'o',
+	})
+
+	if b, err := r.ReadByte(); err != nil || b != 7 {
+		t.Errorf("ReadByte: %v %v", b, err)
+	}
+	if n, err := r.Uint16(); err != nil || n != 1 {
+		t.Errorf("Uint16: %v %v", n, err)
+	}
+	if n, err := r.Uint32(); err != nil || n != 2 {
+		t.Errorf("Uint32: %v %v", n, err)
+	}
+	if n, err := r.Uint64(); err != nil || n != 3 {
+		t.Errorf("Uint64: %v %v", n, err)
+	}
+	if s, err := r.LengthPrefixedString(); err != nil || s != "hello" {
+		t.Errorf("LengthPrefixedString: %q %v", s, err)
+	}
+	if !r.Finished() || r.Position != 22 {
+		t.Errorf("Expected the reader to be finished at byte 22, received %v", r.Position)
+	}
+	if _, err := r.ReadByte(); err != ErrTruncated {
+		t.Errorf("Expected ErrTruncated, received %v", err)
+	}
+}
+
+func TestByteReaderTruncated(t *testing.T) {
+	r := NewByteReader([]byte{9, 0, 'a', 'b'})
+	if _, err := r.LengthPrefixedString(); err != ErrTruncated {
+		t.Errorf("Expected ErrTruncated, received %v", err)
+	}
+	if r.Position != 0 {
+		t.Errorf("Expected a truncated read not to advance, received position %v", r.Position)
+	}
+	if _, err := r.Uint64(); err != ErrTruncated {
+		t.Errorf("Expected ErrTruncated, received %v", err)
+	}
+	if _, err := r.ReadNBytes(1 << 62); err == nil {
+		t.Error("Expected an error for an oversized read")
+	}
+
+	r = &ByteReader{Bytes: make([]byte, 64), MaxLength: 16}
+	if _, err := r.ReadNBytes(17); err == nil || err == ErrTruncated {
+		t.Errorf("Expected the MaxLength guard, received %v", err)
+	}
+	if _, err := r.ReadNBytes(16); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestByteReaderWindows(t *testing.T) {
+	input := []byte{1, 2, 3, 4}
+	r := NewByteReader(input)
+	window, err := r.ReadNBytes(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &window[0] != &input[0] {
+		t.Error("Expected ReadNBytes to return a window of the input")
+	}
+	// Appending to a window must not overwrite the rest of the input
+	_ = append(window, 9)
+	if input[2] != 3 {
+		t.Error("Expected the window capacity to be limited to its length")
+	}
+}
+
+func TestByteReaderUvarint(t *testing.T) {
+	r := NewByteReader([]byte{0x05, 0x80, 0x02, 0x80})
+	if n, err := r.Uvarint(); err != nil || n != 5 {
+		t.Errorf("Uvarint: %v %v", n, err)
+	}
+	if n, err := r.Uvarint(); err != nil || n != 256 {
+		t.Errorf("Uvarint: %v %v", n, err)
+	}
+	if _, err := r.Uvarint(); err != ErrTruncated {
+		t.Errorf("Expected ErrTruncated, received %v", err)
+	}
+	if r.Position != 3 {
+		t.Errorf("Expected a truncated read not to advance, received position %v", r.Position)
+	}
+
+	overflow := NewByteReader([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+	if _, err := overflow.Uvarint(); err == nil || err == ErrTruncated {
+		t.Errorf("Expected an overflow error, received %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+// Command disassemble dumps every compiled entity of a published project as JSON
+// Action bundles, dialog node logic and zone triggers are decoded into readable trees
+//
+// Usage:
+//
+//	disassemble <pubID>
+//
+// The Redis connection is configured with REDIS_ADDR and REDIS_PASSWORD
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/talkative-ai/core/models"
+	"github.com/talkative-ai/core/redis"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: disassemble <pubID>")
+		os.Exit(2)
+	}
+
+	if _, err := redis.ConnectRedis(); err != nil {
+		log.Fatal("Error connecting to redis: ", err)
+	}
+
+	entries, err := models.DisassembleNamespace(redis.NewClientStore(), os.Args[1])
+	if err != nil {
+		log.Fatal("Error reading compiled entities: ", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(entries); err != nil {
+		log.Fatal("Error encoding disassembly: ", err)
+	}
+}
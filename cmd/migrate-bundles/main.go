@@ -0,0 +1,54 @@
+// Command migrate-bundles rewrites legacy headerless action bundles in Redis
+// into the versioned action bundle format
+//
+// Usage:
+//
+//	migrate-bundles -prefix c:v2:42: [-dry-run]
+//
+// The Redis connection is configured with REDIS_ADDR and REDIS_PASSWORD
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/talkative-ai/core/models"
+	"github.com/talkative-ai/core/redis"
+)
+
+func main() {
+	prefix := flag.String("prefix", "c:v2:", "only migrate keys beginning with this prefix")
+	dryRun := flag.Bool("dry-run", false, "validate and count the bundles without rewriting them")
+	flag.Parse()
+
+	if _, err := redis.ConnectRedis(); err != nil {
+		log.Fatal("Error connecting to redis: ", err)
+	}
+
+	result, err := models.MigrateActionBundles(redis.NewClientStore(), *prefix, *dryRun)
+	if err != nil {
+		log.Fatal("Error migrating action bundles: ", err)
+	}
+
+	failed := []string{}
+	for key := range result.Failed {
+		failed = append(failed, key)
+	}
+	sort.Strings(failed)
+	for _, key := range failed {
+		fmt.Printf("FAILED %v: %v\n", key, result.Failed[key])
+	}
+
+	verb := "Migrated"
+	if *dryRun {
+		verb = "Would migrate"
+	}
+	fmt.Printf("%v %v bundles, %v already current, %v failed\n", verb, result.Migrated, result.Current, len(failed))
+
+	if len(failed) > 0 {
+		os.Exit(1)
+	}
+}
@@ -5,10 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/go-redis/redis"
 	uuid "github.com/talkative-ai/go.uuid"
@@ -143,19 +141,6 @@ func (arr *StringArray2DJSON) Value() (driver.Value, error) {
 	return json.Marshal(&arr)
 }
 
-func PseudoRand(max int) int {
-	rand.Seed(time.Now().UTC().UnixNano())
-	return rand.Intn(max)
-}
-
-func ChooseString(list []string) string {
-	l := list
-	if len(l) == 1 {
-		return l[0]
-	}
-	return l[PseudoRand(len(l))]
-}
-
 type SyncMapUUID struct {
 	Value map[uuid.UUID]bool
 	Mutex sync.Mutex
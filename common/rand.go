@@ -0,0 +1,108 @@
+package common
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// Intner is a source of random ints in [0, n)
+type Intner interface {
+	Intn(n int) int
+}
+
+// pseudoRand is shared by PseudoRand and ChooseString
+// It is seeded once, and guarded because *rand.Rand is not safe for concurrent use
+var pseudoRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UTC().UnixNano()))}
+
+// PseudoRand returns a random int in [0, max) from the shared time seeded source
+// Use a SessionRand where the output must be reproducible
+func PseudoRand(max int) int {
+	pseudoRand.Lock()
+	defer pseudoRand.Unlock()
+	return pseudoRand.Intn(max)
+}
+
+type pseudoIntner struct{}
+
+func (pseudoIntner) Intn(n int) int { return PseudoRand(n) }
+
+// ChooseString returns a random element of list from the shared time seeded source
+func ChooseString(list []string) string {
+	return ChooseStringFrom(pseudoIntner{}, list)
+}
+
+// ChooseStringFrom returns a random element of list from r
+// Returns an empty string if list is empty
+func ChooseStringFrom(r Intner, list []string) string {
+	switch len(list) {
+	case 0:
+		return ""
+	case 1:
+		return list[0]
+	}
+	return list[r.Intn(len(list))]
+}
+
+// SessionRand is the source of randomness of a single session
+// It is a splitmix64 generator whose whole state is State, so a session saved
+// and resumed from JSON continues the same sequence, and a session replayed
+// from the same seed produces the same output
+// SessionRand is not safe for concurrent use. Each session has its own
+type SessionRand struct {
+	State uint64
+}
+
+// NewSessionRand returns a SessionRand with an explicit seed
+func NewSessionRand(seed int64) *SessionRand {
+	return &SessionRand{State: uint64(seed)}
+}
+
+// SessionRandFromID returns a SessionRand seeded from the session ID
+func SessionRandFromID(sessionID uuid.UUID) *SessionRand {
+	h := fnv.New64a()
+	h.Write(sessionID.Bytes())
+	return &SessionRand{State: h.Sum64()}
+}
+
+// Seed implements rand.Source
+func (r *SessionRand) Seed(seed int64) {
+	r.State = uint64(seed)
+}
+
+// Uint64 implements rand.Source64
+func (r *SessionRand) Uint64() uint64 {
+	r.State += 0x9e3779b97f4a7c15
+	z := r.State
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// Int63 implements rand.Source
+func (r *SessionRand) Int63() int64 {
+	return int64(r.Uint64() >> 1)
+}
+
+// Intn returns a random int in [0, n)
+// It panics if n <= 0
+func (r *SessionRand) Intn(n int) int {
+	return rand.New(r).Intn(n)
+}
+
+// Int63n returns a random int64 in [0, n)
+// It panics if n <= 0
+func (r *SessionRand) Int63n(n int64) int64 {
+	return rand.New(r).Int63n(n)
+}
+
+// ChooseString returns a random element of list
+func (r *SessionRand) ChooseString(list []string) string {
+	return ChooseStringFrom(r, list)
+}
@@ -50,6 +50,12 @@ func InitializeDB() error {
 	DBMap.AddTableWithName(models.PrivateProjectGrants{}, "workbench_private_project_grants")
 	DBMap.AddTableWithName(models.VersionedProject{}, "static_published_projects_versioned")
 	DBMap.AddTableWithName(models.Note{}, "workbench_notes")
+	DBMap.AddTableWithName(models.ProjectVariable{}, "workbench_project_variables")
+	DBMap.AddTableWithName(models.Item{}, "workbench_items")
+	DBMap.AddTableWithName(models.ReviewWorkflow{}, "project_review_workflows")
+	DBMap.AddTableWithName(models.ReviewAssignment{}, "project_review_assignments")
+	DBMap.AddTableWithName(models.ReviewComment{}, "project_review_comments")
+	DBMap.AddTableWithName(models.ReviewTransition{}, "project_review_transitions")
 
 	DBMap.AddTableWithName(models.User{}, "users")
 	DBMap.AddTableWithName(models.Team{}, "teams")
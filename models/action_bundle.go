@@ -0,0 +1,169 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"regexp"
+
+	"github.com/talkative-ai/core/redis"
+)
+
+// An action bundle is a header followed by a stream of compiled actions
+// Each action is [8 byte ActionID][4 byte length][compiled action]
+// The header is [magic][1 byte version][4 byte action count][4 byte CRC-32 of the action stream]
+//
+// Bundles compiled before the header existed are a bare action stream
+// and are still evaluated as such
+
+// ActionBundleMagic begins every versioned action bundle
+// The first byte is never the low byte of a legacy ActionID
+var ActionBundleMagic = []byte{0xAB, 'T', 'K', 'B'}
+
+const (
+	// ActionBundleVersion1 is the first versioned action bundle format
+	ActionBundleVersion1 uint8 = 1
+
+	// ActionBundleVersion is the format written by CompileActionBundle
+	ActionBundleVersion = ActionBundleVersion1
+
+	// ActionBundleHeaderSize is the byte length of the versioned header
+	ActionBundleHeaderSize = 13
+)
+
+// ActionBundleHeader describes a decoded action bundle
+type ActionBundleHeader struct {
+	// Legacy is true for a headerless bundle
+	Legacy bool
+	// Version is 0 for legacy bundles
+	Version uint8
+	// Count is the number of actions in the bundle, or -1 when Legacy
+	Count int
+	// CRC is the CRC-32 (IEEE) of the action stream
+	CRC uint32
+}
+
+// CompileActionBundle compiles the actions into a versioned action bundle
+func CompileActionBundle(actions ...RequestAction) []byte {
+	stream := []byte{}
+	for _, action := range actions {
+		compiled := action.Compile()
+		header := make([]byte, 12)
+		binary.LittleEndian.PutUint64(header, uint64(action.GetRAID()))
+		binary.LittleEndian.PutUint32(header[8:], uint32(len(compiled)))
+		stream = append(stream, header...)
+		stream = append(stream, compiled...)
+	}
+	return wrapActionStream(stream, len(actions))
+}
+
+func wrapActionStream(stream []byte, count int) []byte {
+	bundle := make([]byte, ActionBundleHeaderSize, ActionBundleHeaderSize+len(stream))
+	copy(bundle, ActionBundleMagic)
+	bundle[4] = ActionBundleVersion
+	binary.LittleEndian.PutUint32(bundle[5:], uint32(count))
+	binary.LittleEndian.PutUint32(bundle[9:], crc32.ChecksumIEEE(stream))
+	return append(bundle, stream...)
+}
+
+// DecodeActionBundleHeader validates the header of the bundle
+// Returns the header and the action stream which follows it
+// A bundle without the magic number is returned unchanged as a legacy bundle
+func DecodeActionBundleHeader(bundle []byte) (ActionBundleHeader, []byte, error) {
+	if !bytes.HasPrefix(bundle, ActionBundleMagic) {
+		return ActionBundleHeader{Legacy: true, Count: -1}, bundle, nil
+	}
+	if len(bundle) < ActionBundleHeaderSize {
+		return ActionBundleHeader{}, nil, fmt.Errorf("Truncated action bundle header")
+	}
+	header := ActionBundleHeader{
+		Version: bundle[4],
+		Count:   int(binary.LittleEndian.Uint32(bundle[5:])),
+		CRC:     binary.LittleEndian.Uint32(bundle[9:]),
+	}
+	if header.Version != ActionBundleVersion1 {
+		return header, nil, fmt.Errorf("Unsupported action bundle version %v", header.Version)
+	}
+	stream := bundle[ActionBundleHeaderSize:]
+	if crc := crc32.ChecksumIEEE(stream); crc != header.CRC {
+		return header, nil, fmt.Errorf("Action bundle checksum mismatch: expected %08x, computed %08x", header.CRC, crc)
+	}
+	return header, stream, nil
+}
+
+// countLegacyActions counts the actions in a headerless action stream
+func countLegacyActions(stream []byte) (int, error) {
+	count := 0
+	for offset := 0; offset < len(stream); count++ {
+		if len(stream)-offset < 12 {
+			return 0, fmt.Errorf("Truncated action at byte %v", offset)
+		}
+		length := int(binary.LittleEndian.Uint32(stream[offset+8:]))
+		offset += 12
+		if len(stream)-offset < length {
+			return 0, fmt.Errorf("Truncated action at byte %v", offset-12)
+		}
+		offset += length
+	}
+	return count, nil
+}
+
+// actionBundleKeyPattern matches the keys generated by
+// KeynavCompiledDialogNodeActionBundle and KeynavCompiledTriggerActionBundle
+var actionBundleKeyPattern = regexp.MustCompile(fmt.Sprintf(`:e:%v:[0-9]+$`, AEIDActionBundle))
+
+// ActionBundleMigration reports the result of MigrateActionBundles
+type ActionBundleMigration struct {
+	// Migrated is the number of legacy bundles rewritten
+	Migrated int
+	// Current is the number of bundles already in the current format
+	Current int
+	// Failed maps the key of each bundle which could not be migrated to the reason
+	Failed map[string]string
+}
+
+// MigrateActionBundles rewrites every legacy action bundle with a key beginning with prefix
+// into the current versioned format. Keys other than action bundles are ignored
+// With dryRun the bundles are validated and counted but not written
+func MigrateActionBundles(store redis.MigrationStore, prefix string, dryRun bool) (ActionBundleMigration, error) {
+	result := ActionBundleMigration{Failed: map[string]string{}}
+
+	keys, err := store.Keys(prefix)
+	if err != nil {
+		return result, err
+	}
+
+	for _, key := range keys {
+		if !actionBundleKeyPattern.MatchString(key) {
+			continue
+		}
+		bundle, err := store.Get(key)
+		if err != nil {
+			result.Failed[key] = err.Error()
+			continue
+		}
+		header, stream, err := DecodeActionBundleHeader(bundle)
+		if err != nil {
+			result.Failed[key] = err.Error()
+			continue
+		}
+		if !header.Legacy {
+			result.Current++
+			continue
+		}
+		count, err := countLegacyActions(stream)
+		if err != nil {
+			result.Failed[key] = err.Error()
+			continue
+		}
+		if !dryRun {
+			if err := store.Write(key, wrapActionStream(stream, count)); err != nil {
+				return result, err
+			}
+		}
+		result.Migrated++
+	}
+
+	return result, nil
+}
@@ -1,35 +1,108 @@
 package models
 
 import (
-	"bytes"
-	"encoding/binary"
+	"fmt"
 
 	utilities "github.com/talkative-ai/core"
+	"github.com/talkative-ai/core/redis"
 )
 
+// ActionBundleEval decodes and executes every action within the bundle
+// Both versioned and legacy headerless bundles are accepted
+// Failing actions are handled according to the ErrorPolicy of the AIRequest
 func ActionBundleEval(state *AIRequest, bundle []byte) error {
-	var r utilities.ByteReader
-	r.Reader = bytes.NewReader(bundle)
+	return actionBundleEval(state, "", bundle)
+}
+
+// ActionBundleEvalKey fetches the ActionBundle binary stored at key from Redis and evaluates it
+// Any RuntimeError returned will include the key
+func ActionBundleEvalKey(state *AIRequest, key string) error {
+	bundle, err := redis.Runtime.Get(key)
+	if err != nil {
+		rerr := newRuntimeError(RuntimeErrorStore, "Error fetching action bundle binary: %s", err.Error())
+		rerr.BundleKey = key
+		rerr.located = true
+		return rerr
+	}
+	return actionBundleEval(state, key, bundle)
+}
+
+func actionBundleEval(state *AIRequest, key string, bundle []byte) error {
+	malformedBundle := func(err error) error {
+		rerr := newRuntimeError(RuntimeErrorMalformed, "Error reading action bundle: %s", err.Error())
+		rerr.BundleKey = key
+		rerr.located = true
+		return rerr
+	}
+
+	header, stream, err := DecodeActionBundleHeader(bundle)
+	if err != nil {
+		return malformedBundle(err)
+	}
+	// Offsets are reported relative to the start of the bundle
+	base := uint64(len(bundle) - len(stream))
+	if !header.Legacy {
+		// Validate the framing before executing anything
+		count, err := countLegacyActions(stream)
+		if err != nil {
+			return malformedBundle(err)
+		}
+		if count != header.Count {
+			return malformedBundle(fmt.Errorf("Expected %v actions, found %v", header.Count, count))
+		}
+	}
+
+	r := utilities.NewByteReader(stream)
 
 	for !r.Finished() {
-		barr, err := r.ReadNBytes(8)
+		offset := base + r.Position
+		malformed := func(err error) error {
+			rerr := newRuntimeError(RuntimeErrorMalformed, "Error reading action bundle: %s", err.Error())
+			rerr.BundleKey = key
+			rerr.Offset = offset
+			rerr.located = true
+			return rerr
+		}
+
+		actionID, err := r.Uint64()
 		if err != nil {
-			return err
+			return malformed(err)
 		}
-		actionID := binary.LittleEndian.Uint64(barr)
-		action := GetActionFromID(ActionID(actionID))
-		barr, err = r.ReadNBytes(4)
+		actionLength, err := r.Uint32()
 		if err != nil {
-			return err
+			return malformed(err)
 		}
-
-		actionLength := binary.LittleEndian.Uint32(barr)
 		actionBytes, err := r.ReadNBytes(uint64(actionLength))
 		if err != nil {
+			return malformed(err)
+		}
+
+		action, err := GetActionFromID(ActionID(actionID))
+		if err != nil {
+			err = newRuntimeError(RuntimeErrorUnsupportedAction, "%s", err.Error())
+		} else if err = action.CreateFrom(actionBytes); err != nil {
+			err = newRuntimeError(RuntimeErrorMalformed, "%s", err.Error())
+		} else {
+			err = action.Execute(state)
+		}
+		if err == nil {
+			continue
+		}
+
+		rerr, ok := err.(*RuntimeError)
+		if !ok {
+			rerr = newRuntimeError(RuntimeErrorMalformed, "%s", err.Error())
+		} else if rerr.handled {
+			// The error occurred within a nested bundle
+			// and the policy has already been applied
+			return rerr
+		}
+		rerr.locate(ActionID(actionID), key, offset)
+
+		proceed, err := state.handleRuntimeError(rerr)
+		if !proceed {
 			return err
 		}
-		action.CreateFrom(actionBytes)
-		action.Execute(state)
 	}
 
 	return nil
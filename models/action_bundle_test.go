@@ -0,0 +1,126 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+)
+
+func TestActionBundleFormats(t *testing.T) {
+	hello := &RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "Hello"}
+	world := &RAPlaySound{SoundType: RAPlaySoundTypeText, Val: " world"}
+
+	bundles := map[string][]byte{
+		"versioned": CompileActionBundle(hello, world),
+		"legacy":    legacyBundleActions(hello, world),
+	}
+	for name, bundle := range bundles {
+		message := AIRequest{OutputSSML: ssml.NewBuilder()}
+		if err := ActionBundleEval(&message, bundle); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if output := message.OutputSSML.String(); output != "<speak>Hello world</speak>" {
+			t.Errorf("%v: unexpected output %v", name, output)
+		}
+	}
+
+	header, stream, err := DecodeActionBundleHeader(bundles["versioned"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Legacy || header.Version != ActionBundleVersion1 || header.Count != 2 {
+		t.Errorf("Unexpected header %+v", header)
+	}
+	if string(stream) != string(bundles["legacy"]) {
+		t.Error("Expected the versioned action stream to match the legacy bundle")
+	}
+}
+
+func TestActionBundleCorruption(t *testing.T) {
+	hello := &RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "Hello"}
+
+	corrupt := func(mutate func([]byte)) []byte {
+		bundle := CompileActionBundle(hello, hello)
+		mutate(bundle)
+		return bundle
+	}
+
+	tests := map[string][]byte{
+		"checksum": corrupt(func(b []byte) { b[len(b)-1] ^= 0xFF }),
+		"version":  corrupt(func(b []byte) { b[4] = 99 }),
+		"count":    wrapActionStream(legacyBundleActions(hello, hello), 3),
+		"framing":  wrapActionStream(legacyBundleActions(hello)[:14], 1),
+		"header":   CompileActionBundle(hello)[:8],
+	}
+	for name, bundle := range tests {
+		message := AIRequest{OutputSSML: ssml.NewBuilder()}
+		err := ActionBundleEval(&message, bundle)
+		if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorMalformed {
+			t.Errorf("%v: expected a RuntimeErrorMalformed, received %v", name, err)
+		}
+		if output := message.OutputSSML.String(); output != "<speak></speak>" {
+			t.Errorf("%v: expected no actions to execute, received %v", name, output)
+		}
+	}
+}
+
+func TestMigrateActionBundles(t *testing.T) {
+	store := redis.NewMemoryStore()
+	hello := &RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "Hello"}
+
+	legacyKey := KeynavCompiledDialogNodeActionBundle("1", "greet", 0)
+	triggerKey := KeynavCompiledTriggerActionBundle("1", "zone", uint64(TriggerEnterZone), 2)
+	currentKey := KeynavCompiledDialogNodeActionBundle("1", "greet", 1)
+	brokenKey := KeynavCompiledDialogNodeActionBundle("1", "greet", 2)
+	dialogKey := KeynavCompiledEntity("1", AEIDDialogNode, "greet")
+	otherKey := KeynavCompiledDialogNodeActionBundle("2", "greet", 0)
+
+	store.Set(legacyKey, legacyBundleActions(hello))
+	store.Set(triggerKey, legacyBundleActions(hello, hello))
+	store.Set(currentKey, CompileActionBundle(hello))
+	store.Set(brokenKey, legacyBundleActions(hello)[:10])
+	store.Set(dialogKey, mustCompile(t, LBlock{AlwaysExec: legacyKey}))
+	store.Set(otherKey, legacyBundleActions(hello))
+
+	prefix := "c:v2:1:"
+	result, err := MigrateActionBundles(store, prefix, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Migrated != 2 || result.Current != 1 || len(result.Failed) != 1 {
+		t.Errorf("Unexpected dry run result %+v", result)
+	}
+	if bundle, _ := store.Get(legacyKey); string(bundle) != string(legacyBundleActions(hello)) {
+		t.Error("Expected a dry run to leave the bundles unchanged")
+	}
+
+	if result, err = MigrateActionBundles(store, prefix, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.Failed[brokenKey]; result.Migrated != 2 || !ok {
+		t.Errorf("Unexpected migration result %+v", result)
+	}
+
+	for key, count := range map[string]int{legacyKey: 1, triggerKey: 2, currentKey: 1} {
+		bundle, _ := store.Get(key)
+		header, _, err := DecodeActionBundleHeader(bundle)
+		if err != nil || header.Legacy || header.Count != count {
+			t.Errorf("%v: unexpected header %+v (%v)", key, header, err)
+		}
+	}
+
+	unchanged := map[string][]byte{
+		dialogKey: mustCompile(t, LBlock{AlwaysExec: legacyKey}),
+		otherKey:  legacyBundleActions(hello),
+	}
+	for key, expected := range unchanged {
+		if bundle, _ := store.Get(key); string(bundle) != string(expected) {
+			t.Errorf("%v: expected the value to be left unchanged", key)
+		}
+	}
+
+	if result, _ = MigrateActionBundles(store, prefix, false); result.Migrated != 0 || result.Current != 3 {
+		t.Errorf("Expected the migration to be idempotent, received %+v", result)
+	}
+}
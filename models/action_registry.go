@@ -0,0 +1,195 @@
+package models
+
+import (
+	"fmt"
+	"sync"
+
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// ActionRegistration describes a RequestAction type to the runtime and to Lakshmi
+type ActionRegistration struct {
+	ID ActionID
+
+	// New creates an empty RequestAction to be populated by CreateFrom
+	New func() RequestAction
+
+	// FromActionSet returns the actions of this type within an ActionSet
+	// Used by ActionSet.Iterable. May be nil if the action is never part of an ActionSet
+	FromActionSet func(ActionSet) []RequestAction
+}
+
+var actionRegistry = struct {
+	sync.RWMutex
+	byID  map[ActionID]ActionRegistration
+	order []ActionID
+}{
+	byID: map[ActionID]ActionRegistration{},
+}
+
+// RegisterAction makes a RequestAction type available to GetActionFromID and ActionSet.Iterable
+// It is intended to be called from an init function
+// If RegisterAction is called twice with the same ActionID, or without New, it panics
+func RegisterAction(registration ActionRegistration) {
+	actionRegistry.Lock()
+	defer actionRegistry.Unlock()
+
+	if registration.New == nil {
+		panic(fmt.Sprintf("models: RegisterAction for action id %v is missing New", registration.ID))
+	}
+	if _, dup := actionRegistry.byID[registration.ID]; dup {
+		panic(fmt.Sprintf("models: RegisterAction called twice for action id %v", registration.ID))
+	}
+	actionRegistry.byID[registration.ID] = registration
+	actionRegistry.order = append(actionRegistry.order, registration.ID)
+}
+
+// registeredActions returns every ActionRegistration in the order they were registered
+func registeredActions() []ActionRegistration {
+	actionRegistry.RLock()
+	defer actionRegistry.RUnlock()
+
+	registrations := make([]ActionRegistration, len(actionRegistry.order))
+	for i, id := range actionRegistry.order {
+		registrations[i] = actionRegistry.byID[id]
+	}
+	return registrations
+}
+
+// GetActionFromID returns an empty RequestAction for the ActionID
+// Returns an error if no action has been registered with the ActionID
+func GetActionFromID(id ActionID) (RequestAction, error) {
+	actionRegistry.RLock()
+	registration, ok := actionRegistry.byID[id]
+	actionRegistry.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unsupported action id: %v", id)
+	}
+	return registration.New(), nil
+}
+
+func init() {
+	RegisterAction(ActionRegistration{
+		ID:  RAIDPlaySound,
+		New: func() RequestAction { return &RAPlaySound{} },
+		FromActionSet: func(AAS ActionSet) []RequestAction {
+			actions := []RequestAction{}
+			for _, r := range AAS.PlaySounds {
+				action := r
+				actions = append(actions, &action)
+			}
+			return actions
+		},
+	})
+
+	RegisterAction(ActionRegistration{
+		ID:  RAIDChooseSound,
+		New: func() RequestAction { return &RAChooseSound{} },
+		FromActionSet: func(AAS ActionSet) []RequestAction {
+			actions := []RequestAction{}
+			for _, r := range AAS.ChooseSounds {
+				action := r
+				actions = append(actions, &action)
+			}
+			return actions
+		},
+	})
+
+	RegisterAction(ActionRegistration{
+		ID:  RAIDSetARVariable,
+		New: func() RequestAction { return &RASetVariable{} },
+		FromActionSet: func(AAS ActionSet) []RequestAction {
+			actions := []RequestAction{}
+			for _, r := range AAS.SetGlobalVariables {
+				action := r
+				actions = append(actions, &action)
+			}
+			return actions
+		},
+	})
+
+	RegisterAction(ActionRegistration{
+		ID:  RAIDGiveItem,
+		New: func() RequestAction { return &RAGiveItem{} },
+		FromActionSet: func(AAS ActionSet) []RequestAction {
+			actions := []RequestAction{}
+			for _, r := range AAS.GiveItems {
+				action := r
+				actions = append(actions, &action)
+			}
+			return actions
+		},
+	})
+
+	RegisterAction(ActionRegistration{
+		ID:  RAIDTakeItem,
+		New: func() RequestAction { return &RATakeItem{} },
+		FromActionSet: func(AAS ActionSet) []RequestAction {
+			actions := []RequestAction{}
+			for _, r := range AAS.TakeItems {
+				action := r
+				actions = append(actions, &action)
+			}
+			return actions
+		},
+	})
+
+	RegisterAction(ActionRegistration{
+		ID: RAIDSetZone,
+		New: func() RequestAction {
+			n := RASetZone(uuid.Nil)
+			return &n
+		},
+		FromActionSet: func(AAS ActionSet) []RequestAction {
+			if uuid.UUID(AAS.SetZone) == uuid.Nil {
+				return nil
+			}
+			action := AAS.SetZone
+			return []RequestAction{&action}
+		},
+	})
+
+	RegisterAction(ActionRegistration{
+		ID: RAIDInitializeActorDialog,
+		New: func() RequestAction {
+			n := RAInitializeActorDialog(uuid.Nil)
+			return &n
+		},
+		FromActionSet: func(AAS ActionSet) []RequestAction {
+			if AAS.InitializeActorDialog == uuid.Nil {
+				return nil
+			}
+			action := RAInitializeActorDialog(AAS.InitializeActorDialog)
+			return []RequestAction{&action}
+		},
+	})
+
+	RegisterAction(ActionRegistration{
+		ID:  RAIDSchedule,
+		New: func() RequestAction { return &RASchedule{} },
+		FromActionSet: func(AAS ActionSet) []RequestAction {
+			actions := []RequestAction{}
+			for _, r := range AAS.Schedules {
+				action := r
+				actions = append(actions, &action)
+			}
+			return actions
+		},
+	})
+
+	RegisterAction(ActionRegistration{
+		ID: RAIDResetApp,
+		New: func() RequestAction {
+			n := RAResetApp(false)
+			return &n
+		},
+		FromActionSet: func(AAS ActionSet) []RequestAction {
+			if !AAS.ResetApp {
+				return nil
+			}
+			action := AAS.ResetApp
+			return []RequestAction{&action}
+		},
+	})
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"testing"
+
+	ssml "github.com/talkative-ai/go-ssml"
+)
+
+const testRAIDEcho ActionID = 1 << 40
+
+// raEcho is a RequestAction registered only for testing
+type raEcho string
+
+func (ara raEcho) GetRAID() ActionID { return testRAIDEcho }
+func (ara raEcho) Compile() []byte   { return []byte(ara) }
+func (ara *raEcho) CreateFrom(b []byte) error {
+	*ara = raEcho(b)
+	return nil
+}
+func (ara *raEcho) Execute(state *AIRequest) error {
+	state.OutputSSML = state.OutputSSML.Paragraph(string(*ara))
+	return nil
+}
+
+func init() {
+	RegisterAction(ActionRegistration{
+		ID:  testRAIDEcho,
+		New: func() RequestAction { return new(raEcho) },
+	})
+}
+
+func TestRegisterActionDuplicate(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected RegisterAction to panic on a duplicate action id")
+		}
+	}()
+	RegisterAction(ActionRegistration{
+		ID:  RAIDPlaySound,
+		New: func() RequestAction { return &RAPlaySound{} },
+	})
+}
+
+func TestGetActionFromID(t *testing.T) {
+	for _, id := range []ActionID{RAIDSetARVariable, RAIDPlaySound, RAIDSetZone, RAIDResetApp, testRAIDEcho} {
+		action, err := GetActionFromID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if action.GetRAID() != id {
+			t.Errorf("Expected action id %v, received %v", id, action.GetRAID())
+		}
+	}
+
+	if _, err := GetActionFromID(ActionID(1 << 50)); err == nil {
+		t.Error("Expected an error for an unregistered action id")
+	}
+}
+
+func TestActionBundleEvalRegisteredAction(t *testing.T) {
+	echo := raEcho("Hello")
+	bundle := legacyBundleActions(&echo)
+	// An unregistered action followed by a registered one
+	unknown := append([]byte{0, 0, 0, 0, 0, 0, 4, 0, 1, 0, 0, 0, 42}, bundle...)
+
+	state := AIRequest{
+		OutputSSML: ssml.NewBuilder(),
+		ErrorPolicy: func(_ *AIRequest, err *RuntimeError) RuntimeErrorDecision {
+			if err.Code != RuntimeErrorUnsupportedAction {
+				t.Errorf("Unexpected RuntimeError %v", err)
+			}
+			return RuntimeErrorSkip
+		},
+	}
+	if err := ActionBundleEval(&state, unknown); err != nil {
+		t.Fatal(err)
+	}
+	if state.OutputSSML.String() != "<speak>Hello</speak>" {
+		t.Errorf("Unexpected output %v", state.OutputSSML.String())
+	}
+}
+
+func TestActionSetIterableOrder(t *testing.T) {
+	AAS := ActionSet{
+		SetGlobalVariables: []RASetVariable{{Target: "gold"}},
+		PlaySounds:         []RAPlaySound{{Val: "Hello"}, {Val: "world"}},
+		ResetApp:           true,
+	}
+
+	expected := []ActionID{RAIDPlaySound, RAIDPlaySound, RAIDSetARVariable, RAIDResetApp}
+	received := []ActionID{}
+	for action := range AAS.Iterable() {
+		received = append(received, action.GetRAID())
+	}
+
+	if len(received) != len(expected) {
+		t.Fatalf("Expected %v, received %v", expected, received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("Expected %v, received %v", expected, received)
+		}
+	}
+}
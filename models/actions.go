@@ -4,14 +4,17 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/url"
-	"regexp"
+	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/talkative-ai/go.uuid"
 
-	"log"
-
+	utilities "github.com/talkative-ai/core"
+	"github.com/talkative-ai/core/common"
 	"github.com/talkative-ai/core/redis"
 	"github.com/talkative-ai/go-ssml"
 )
@@ -32,6 +35,14 @@ const (
 	RAIDSetZone
 	// RAIDResetApp ActionID for ResetApp
 	RAIDResetApp
+	// RAIDChooseSound ActionID for ChooseSound
+	RAIDChooseSound
+	// RAIDSchedule ActionID for Schedule
+	RAIDSchedule
+	// RAIDGiveItem ActionID for GiveItem
+	RAIDGiveItem
+	// RAIDTakeItem ActionID for TakeItem
+	RAIDTakeItem
 )
 
 // ActionSet is a pre-bundled set of actions
@@ -39,9 +50,13 @@ const (
 type ActionSet struct {
 	SetGlobalVariables    []RASetVariable
 	PlaySounds            []RAPlaySound
+	ChooseSounds          []RAChooseSound
 	InitializeActorDialog uuid.UUID
 	SetZone               RASetZone
 	ResetApp              RAResetApp
+	Schedules             []RASchedule
+	GiveItems             []RAGiveItem
+	TakeItems             []RATakeItem
 }
 
 func (a *ActionSet) Scan(src interface{}) error {
@@ -55,29 +70,38 @@ func (a *ActionSet) Value() (driver.Value, error) {
 // Iterable will output all of the RequestActions within the ActionSet
 // This is useful for easily bundling actions within Lakshmi
 // Without having to create ad hoc functions
+// Actions are output in the order their types were registered with RegisterAction
 func (AAS ActionSet) Iterable() <-chan RequestAction {
 	ch := make(chan RequestAction)
 	go func() {
 		defer close(ch)
-		for _, r := range AAS.PlaySounds {
-			action := r
-			ch <- &action
-		}
-
-		for _, r := range AAS.SetGlobalVariables {
-			action := r
-			ch <- &action
+		for _, registration := range registeredActions() {
+			if registration.FromActionSet == nil {
+				continue
+			}
+			for _, action := range registration.FromActionSet(AAS) {
+				ch <- action
+			}
 		}
+	}()
+	return ch
+}
 
-		if uuid.UUID(AAS.SetZone) != uuid.Nil {
-			ch <- &AAS.SetZone
+// Validate is used by Lakshmi before bundling the actions of the ActionSet
+// Returns the first invalid sound, including the variants of each choice,
+// or the first variable set to a value too large to be compiled
+func (AAS ActionSet) Validate() error {
+	for _, sound := range actionSetSounds(AAS) {
+		if err := sound.Validate(); err != nil {
+			return err
 		}
-
-		if AAS.ResetApp {
-			ch <- &AAS.ResetApp
+	}
+	for _, set := range AAS.SetGlobalVariables {
+		if err := set.Validate(); err != nil {
+			return err
 		}
-	}()
-	return ch
+	}
+	return nil
 }
 
 // AIRequest is used by Brahman
@@ -86,6 +110,14 @@ func (AAS ActionSet) Iterable() <-chan RequestAction {
 type AIRequest struct {
 	State      MutableAIRequestState
 	OutputSSML ssml.Builder
+
+	// ErrorPolicy decides how a failing action is handled
+	// If nil, DefaultRuntimeErrorPolicy is used
+	ErrorPolicy RuntimeErrorPolicy
+
+	variableUpdates variableUpdateCascade
+	// zoneChangeDepth is the number of nested RASetZone executions in progress
+	zoneChangeDepth int
 }
 
 type MutableAIRequestState struct {
@@ -100,26 +132,28 @@ type MutableAIRequestState struct {
 	Demo             bool
 	RestartRequested bool
 	PreviousResponse string
+	// Choices holds the most recent variant indexes chosen by each RAChooseSound, oldest first
+	Choices map[string][]int
+	// Rand is the source of randomness of the session, saved with the state so that
+	// a resumed session continues the same sequence. Seed it explicitly to replay a session
+	Rand *common.SessionRand
+	// Turn is the number of turns run within the session
+	Turn uint64
+	// LastInteraction is the time the last turn began
+	LastInteraction time.Time
+	// Schedules are the ActionBundles waiting for a later turn, in the order they were scheduled
+	Schedules []Schedule
+	// Inventory maps the ID of every held item to its quantity
+	Inventory map[string]uint32
 }
 
-type ARVariable struct {
-	T   string
-	Val interface{}
-}
-
-func (arv *ARVariable) Get() interface{} {
-	var v interface{}
-	switch arv.T {
-	case "int":
-		v = arv.Val.(int)
-	case "bool":
-		v = arv.Val.(bool)
-	case "array":
-		v = arv.Val.([]ARVariable)
-	default:
-		v = fmt.Sprintf("%+v", arv.Val)
+// random returns the source of randomness of the session
+// The first use within a session without a Rand seeds it from the SessionID
+func (message *AIRequest) random() *common.SessionRand {
+	if message.State.Rand == nil {
+		message.State.Rand = common.SessionRandFromID(message.State.SessionID)
 	}
-	return v
+	return message.State.Rand
 }
 
 func (a *MutableAIRequestState) Value() (driver.Value, error) {
@@ -142,7 +176,8 @@ type RequestAction interface {
 
 	// Execute will mutate the AIRequest in some way
 	// Whether it's the state itself or the OutputSSML
-	Execute(*AIRequest)
+	// A failing action returns a *RuntimeError rather than terminating the process
+	Execute(*AIRequest) error
 }
 
 // RAPlaySoundType is an enum of different RAPlaySound types
@@ -154,6 +189,16 @@ const (
 	RAPlaySoundTypeText RAPlaySoundType = iota
 	// RAPlaySoundTypeAudio URL to an audio file
 	RAPlaySoundTypeAudio
+	// RAPlaySoundTypeBreak A pause, Val is an SSMLBreak
+	RAPlaySoundTypeBreak
+	// RAPlaySoundTypeProsody Speech-synthesis with a rate, pitch or volume, Val is an SSMLProsody
+	RAPlaySoundTypeProsody
+	// RAPlaySoundTypeEmphasis Emphasized speech-synthesis, Val is an SSMLEmphasis
+	RAPlaySoundTypeEmphasis
+	// RAPlaySoundTypeSayAs Speech-synthesis of numbers, dates, characters etc., Val is an SSMLSayAs
+	RAPlaySoundTypeSayAs
+	// RAPlaySoundTypeVoice Speech-synthesis in an actor's voice, Val is an SSMLVoice
+	RAPlaySoundTypeVoice
 )
 
 // RAPlaySound RequestAction PlaySound
@@ -161,24 +206,9 @@ const (
 type RAPlaySound struct {
 	SoundType RAPlaySoundType
 	Val       interface{}
-}
 
-func GetActionFromID(id ActionID) RequestAction {
-	switch id {
-	case RAIDPlaySound:
-		return &RAPlaySound{}
-	case RAIDSetZone:
-		n := RASetZone(uuid.Nil)
-		return &n
-	case RAIDSetARVariable:
-		return &RASetVariable{}
-	case RAIDResetApp:
-		n := RAResetApp(false)
-		return &n
-	default:
-		log.Fatalln("Unsupported action id:", id)
-		return nil
-	}
+	// template is the text of the sound, parsed once by CreateFrom
+	template *SoundTemplate
 }
 
 //////////////////
@@ -190,19 +220,56 @@ func (ara RAPlaySound) GetRAID() ActionID {
 	return RAIDPlaySound
 }
 
+// UnmarshalJSON decodes the Val of the structured sound types into their SSML structs
+// Text and audio sound types are decoded as before
+func (ara *RAPlaySound) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		SoundType RAPlaySoundType
+		Val       json.RawMessage
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	ara.SoundType = raw.SoundType
+	ara.Val = nil
+	if len(raw.Val) == 0 {
+		return nil
+	}
+	var err error
+	ara.Val, err = unmarshalSSML(raw.SoundType, raw.Val)
+	return err
+}
+
+// Validate is used by Lakshmi before Compile
+// Returns an error if the text of the sound is not a valid SoundTemplate
+func (ara RAPlaySound) Validate() error {
+	text := soundText(ara)
+	if text == "" {
+		return nil
+	}
+	if _, err := ParseSoundTemplate(text); err != nil {
+		return fmt.Errorf("Invalid RAPlaySound text %q: %s", text, err.Error())
+	}
+	return nil
+}
+
 // Compile is used by Lakshmi
 // Returns the compiled []byte slice of the runtime action
 // To be stored in Redis
+// Text is preceded by the compiledTemplateMarker, and is expected to be valid, see Validate
 func (ara RAPlaySound) Compile() []byte {
 	compiled := []byte{}
 	compiled = append(compiled, byte(ara.SoundType))
 	switch ara.SoundType {
 	case RAPlaySoundTypeText:
+		compiled = append(compiled, compiledTemplateMarker)
 		compiled = append(compiled, []byte(ara.Val.(string))...)
 		break
 	case RAPlaySoundTypeAudio:
 		compiled = append(compiled, []byte(ara.Val.(*url.URL).String())...)
 		break
+	default:
+		compiled = append(compiled, compileSSML(ara.Val)...)
 	}
 
 	return compiled
@@ -211,45 +278,84 @@ func (ara RAPlaySound) Compile() []byte {
 // CreateFrom is used for evaluating the actions in Brahman and followed by Execute
 // This could be put in a single "Execute" but this is less monolothic
 func (ara *RAPlaySound) CreateFrom(bytes []byte) error {
+	if len(bytes) == 0 {
+		return fmt.Errorf("Missing RAPlaySoundType")
+	}
 	ara.SoundType = RAPlaySoundType(bytes[0])
 	bytes = bytes[1:]
+	ara.template = nil
+	var err error
 	switch ara.SoundType {
 	case RAPlaySoundTypeText:
-		ara.Val = string(bytes)
-		break
+		if len(bytes) == 0 || bytes[0] != compiledTemplateMarker {
+			ara.Val = string(bytes)
+			ara.template = legacySoundTemplate(string(bytes))
+			return nil
+		}
+		ara.Val = string(bytes[1:])
 	case RAPlaySoundTypeAudio:
-		var err error
 		ara.Val, err = url.Parse(string(bytes))
+		return err
+	default:
+		ara.Val, err = readCompiledSSML(ara.SoundType, bytes)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	ara.template, err = ParseSoundTemplate(soundText(*ara))
+	return err
+}
+
+// soundTemplate returns the template parsed by CreateFrom,
+// or parses the text of a sound which was not decoded by CreateFrom
+func (ara RAPlaySound) soundTemplate() (*SoundTemplate, error) {
+	if ara.template != nil {
+		return ara.template, nil
+	}
+	tmpl, err := ParseSoundTemplate(soundText(ara))
+	if err != nil {
+		return nil, newRuntimeError(RuntimeErrorMalformed, "Invalid RAPlaySound text: %s", err.Error())
+	}
+	return tmpl, nil
 }
 
 // Execute will mutate the AIRequest in some way
 // Whether it's the state itself or the OutputSSML
-func (ara RAPlaySound) Execute(state *AIRequest) {
+func (ara RAPlaySound) Execute(state *AIRequest) error {
 	switch ara.SoundType {
 	case RAPlaySoundTypeText:
-		r, err := regexp.Compile(`{{\w+}}`)
-		v := ara.Val.(string)
+		if _, ok := ara.Val.(string); !ok {
+			return newRuntimeError(RuntimeErrorInvalidType, "Invalid type %T on RAPlaySound Execute", ara.Val)
+		}
+		tmpl, err := ara.soundTemplate()
 		if err != nil {
-			log.Fatal("[ERROR] Inavalid type on RASetVariable Execute")
-			return
-		}
-		newv := r.ReplaceAllFunc([]byte(v), func(b []byte) []byte {
-			v := b[2 : len(b)-2]
-			// TODO: Support array indices
-			val := state.State.ARVariables[string(v)].Val
-			return []byte(fmt.Sprintf("%v", val))
-		})
-		state.OutputSSML = state.OutputSSML.Paragraph(string(newv))
+			return err
+		}
+		text, err := tmpl.Render(state.State.ARVariables)
+		if err != nil {
+			return err
+		}
+		state.OutputSSML = state.OutputSSML.Paragraph(text)
 		break
 	case RAPlaySoundTypeAudio:
-		state.OutputSSML = state.OutputSSML.Audio(ara.Val.(*url.URL))
+		u, ok := ara.Val.(*url.URL)
+		if !ok {
+			return newRuntimeError(RuntimeErrorInvalidType, "Invalid type %T on RAPlaySound Execute", ara.Val)
+		}
+		state.OutputSSML = state.OutputSSML.Audio(u)
 		break
+	default:
+		tmpl, err := ara.soundTemplate()
+		if err != nil {
+			return err
+		}
+		output, err := appendSSML(state.OutputSSML, ara.Val, tmpl, state.State.ARVariables)
+		if err != nil {
+			return err
+		}
+		state.OutputSSML = output
 	}
+	return nil
 }
 
 ////////////////
@@ -302,43 +408,135 @@ func (ara *RASetZone) UnmarshalText(text []byte) error {
 
 // Execute will mutate the AIRequest in some way
 // Whether it's the state itself or the OutputSSML
-func (ara *RASetZone) Execute(message *AIRequest) {
+//
+// Triggers are fired in the following order:
+// 1. TriggerExitZone of the previous zone, if any
+// 2. TriggerInitializeZone of the new zone, only on the first visit
+// 3. TriggerEnterZone of the new zone, on every visit
+//
+// Setting the zone to the current zone fires no triggers
+// Zone changes made by the triggers are limited to MaxZoneChangeDepth
+func (ara *RASetZone) Execute(message *AIRequest) error {
+	previous := message.State.Zone
+	if previous == ara.UUID() {
+		// The zone is unchanged. The conversation ends but no triggers are fired
+		message.State.CurrentDialog = nil
+		return nil
+	}
+
+	if message.zoneChangeDepth >= MaxZoneChangeDepth {
+		return newRuntimeError(RuntimeErrorCascadeLimit,
+			"Zone changes exceeded the maximum depth of %v", MaxZoneChangeDepth)
+	}
+	message.zoneChangeDepth++
+	defer func() { message.zoneChangeDepth-- }()
+
+	if previous != uuid.Nil {
+		if err := fireZoneTrigger(message, previous, TriggerExitZone); err != nil {
+			return err
+		}
+	}
+
 	message.State.Zone = ara.UUID()
 	message.State.CurrentDialog = nil
 
-	if message.State.ZoneInitialized[message.State.Zone] {
-		return
+	if message.State.ZoneInitialized == nil {
+		message.State.ZoneInitialized = map[uuid.UUID]bool{}
+	}
+	if !message.State.ZoneInitialized[message.State.Zone] {
+		message.State.ZoneInitialized[message.State.Zone] = true
+		if err := fireZoneTrigger(message, message.State.Zone, TriggerInitializeZone); err != nil {
+			return err
+		}
 	}
 
-	message.State.ZoneInitialized[message.State.Zone] = true
+	return fireZoneTrigger(message, message.State.Zone, TriggerEnterZone)
+}
 
-	res := redis.Instance.HGet(
-		KeynavCompiledTriggersWithinZone(message.State.PubID, ara.String()),
-		fmt.Sprintf("%v", TriggerInitializeZone)).Val()
+// fireZoneTrigger evaluates the logic block of the trigger within the zone, if any
+func fireZoneTrigger(message *AIRequest, zoneID uuid.UUID, triggerType TriggerType) error {
+	key := KeynavCompiledTriggersWithinZone(message.State.PubID, zoneID.String())
+	res, err := redis.Runtime.HGet(key, fmt.Sprintf("%v", triggerType))
+	if err != nil {
+		return newRuntimeError(RuntimeErrorStore, "Error fetching zone triggers: %s", err.Error())
+	}
 
-	// There is no initialize trigger
+	// There is no such trigger
 	if res == "" {
-		return
+		return nil
 	}
 
-	stateComms := make(chan AIRequest)
-	result := LogicLazyEval(stateComms, []byte(res))
-	for res := range result {
-		if res.Error != nil {
-			log.Fatal("Error in SetZone with logic evaluation", res.Error)
-			return
-		}
-		bundleBinary, err := redis.Instance.Get(res.Value).Bytes()
-		if err != nil {
-			log.Fatal("Error in SetZone fetching action bundle binary", err)
-			return
+	return evalLogicBlock(message, key, []byte(res))
+}
+
+//////////////////////////////
+// RAInitializeActorDialog //
+//////////////////////////////
+
+// RAInitializeActorDialog starts a conversation with an actor
+// e.g. an NPC greeting the user upon entering a zone
+type RAInitializeActorDialog uuid.UUID
+
+// GetRAID returns the ActionID of the current RequestAction
+func (ara *RAInitializeActorDialog) GetRAID() ActionID {
+	return RAIDInitializeActorDialog
+}
+
+// Compile is used by Lakshmi
+// Returns the compiled []byte slice of the runtime action
+// To be stored in Redis
+func (ara RAInitializeActorDialog) Compile() []byte {
+	return uuid.UUID(ara).Bytes()
+}
+
+// CreateFrom is used for evaluating the actions in Brahman and followed by Execute
+// This could be put in a single "Execute" but this is less monolothic
+func (ara *RAInitializeActorDialog) CreateFrom(bytes []byte) error {
+	id, err := uuid.FromBytes(bytes)
+	if err != nil {
+		return err
+	}
+	*ara = RAInitializeActorDialog(id)
+	return nil
+}
+
+func (ara *RAInitializeActorDialog) String() string {
+	return uuid.UUID(*ara).String()
+}
+
+// Execute will mutate the AIRequest in some way
+// Whether it's the state itself or the OutputSSML
+//
+// The actor's root dialog becomes the CurrentDialog and its logic block is evaluated
+// If the actor has multiple root dialogs, the greeting is preferred.
+// Otherwise the root dialog with the first entry input in sorted order is used
+func (ara *RAInitializeActorDialog) Execute(message *AIRequest) error {
+	roots, err := redis.Runtime.HGetAll(KeynavCompiledDialogRootWithinActor(message.State.PubID, ara.String()))
+	if err != nil {
+		return newRuntimeError(RuntimeErrorStore, "Error fetching actor root dialogs: %s", err.Error())
+	}
+
+	dialogID, ok := roots[string(DialogInputGreeting)]
+	if !ok {
+		inputs := []string{}
+		for input := range roots {
+			inputs = append(inputs, input)
 		}
-		err = ActionBundleEval(message, bundleBinary)
-		if err != nil {
-			log.Fatal("Error in SetZone processing action bundle binary", err)
-			return
+		if len(inputs) == 0 {
+			return newRuntimeError(RuntimeErrorNotFound, "Actor %v has no root dialog", ara.String())
 		}
+		sort.Strings(inputs)
+		dialogID = roots[inputs[0]]
 	}
+
+	key := KeynavCompiledEntity(message.State.PubID, AEIDDialogNode, dialogID)
+	compiled, err := redis.Runtime.Get(key)
+	if err != nil {
+		return newRuntimeError(RuntimeErrorNotFound, "Error fetching dialog %v: %s", dialogID, err.Error())
+	}
+
+	message.State.CurrentDialog = &dialogID
+	return evalLogicBlock(message, key, compiled)
 }
 
 ////////////////
@@ -367,24 +565,40 @@ func (ara *RAResetApp) CreateFrom(bytes []byte) error {
 
 // Execute will mutate the AIRequest in some way
 // Whether it's the state itself or the OutputSSML
-func (ara *RAResetApp) Execute(message *AIRequest) {
+func (ara *RAResetApp) Execute(message *AIRequest) error {
 	if *ara {
 		// The reset is happening from inside the app
 	} else {
 		// The reset is being triggered manually
 	}
+	// Leaving the previous zone is not narrated when restarting
+	message.State.Zone = uuid.Nil
 	message.State.ZoneActors = map[uuid.UUID][]string{}
 	message.State.ZoneInitialized = map[uuid.UUID]bool{}
-	for _, zoneID := range redis.Instance.SMembers(
-		fmt.Sprintf("%v:%v", KeynavProjectMetadataStatic(message.State.PubID), "all_zones")).Val() {
+	message.State.Inventory = map[string]uint32{}
+	if err := seedProjectVariables(message); err != nil {
+		return err
+	}
+	zoneIDs, err := redis.Runtime.SMembers(
+		fmt.Sprintf("%v:%v", KeynavProjectMetadataStatic(message.State.PubID), "all_zones"))
+	if err != nil {
+		return newRuntimeError(RuntimeErrorStore, "Error fetching zones: %s", err.Error())
+	}
+	for _, zoneID := range zoneIDs {
 		zUUID := uuid.FromStringOrNil(zoneID)
-		message.State.ZoneActors[zUUID] =
-			redis.Instance.SMembers(KeynavCompiledActorsWithinZone(message.State.PubID, zoneID)).Val()
+		message.State.ZoneActors[zUUID], err =
+			redis.Runtime.SMembers(KeynavCompiledActorsWithinZone(message.State.PubID, zoneID))
+		if err != nil {
+			return newRuntimeError(RuntimeErrorStore, "Error fetching zone actors: %s", err.Error())
+		}
 		message.State.ZoneInitialized[zUUID] = false
 	}
-	zoneID := redis.Instance.HGet(KeynavProjectMetadataStatic(message.State.PubID), "start_zone_id").Val()
+	zoneID, err := redis.Runtime.HGet(KeynavProjectMetadataStatic(message.State.PubID), "start_zone_id")
+	if err != nil {
+		return newRuntimeError(RuntimeErrorStore, "Error fetching start zone: %s", err.Error())
+	}
 	setZone := RASetZone(uuid.FromStringOrNil(zoneID))
-	setZone.Execute(message)
+	return setZone.Execute(message)
 }
 
 ////////////////////
@@ -418,8 +632,31 @@ const (
 
 	// SVOReplace is for string
 	SVOReplace
+
+	// SVOAppend is for array
+	SVOAppend
+	// SVOPop is for array
+	SVOPop
+	// SVOSetAdd is for array, appending only if the array does not contain the value
+	SVOSetAdd
+
+	// SVOClamp is for int
+	SVOClamp
+	// SVORandomInt is for int
+	SVORandomInt
+
+	// SVOUpper is for string
+	SVOUpper
+	// SVOLower is for string
+	SVOLower
 )
 
+// Parameters of RASetVariable operations, within ParametizedARVariable.Params
+// SVOInsert and SVODelete take an Index. Negative indexes count from the end
+// SVOReplace takes Search and Replace, and an optional Count. Every occurrence is replaced by default
+// SVOPop takes an optional Into, the name of a variable to store the removed element in
+// SVOClamp and SVORandomInt take an inclusive Min and Max
+
 type RASetVariable struct {
 	Target    string
 	Operation SetVariableOperation
@@ -438,131 +675,506 @@ func (ara *RASetVariable) GetRAID() ActionID {
 	return RAIDSetARVariable
 }
 
+// setVariableVersion1 is the first version of the compiled RASetVariable
+const setVariableVersion1 byte = 1
+
+// Sources of the ParametizedARVariable within a compiled RASetVariable
+const (
+	setVariableSourceNone byte = iota
+	setVariableSourceInline
+	setVariableSourceKey
+)
+
+// Validate is used by Lakshmi before Compile
+// Returns an error if the inlined ARVariable is too large to be compiled
+func (ara RASetVariable) Validate() error {
+	if ara.With.ARVariable == nil {
+		return nil
+	}
+	if err := ara.With.ARVariable.checkCompiled(); err != nil {
+		return fmt.Errorf("Invalid RASetVariable value for %q: %s", ara.Target, err.Error())
+	}
+	return nil
+}
+
 // Compile is used by Lakshmi
 // Returns the compiled []byte slice of the runtime action
 // To be stored in Redis
+// The inlined ARVariable is expected to be valid, see Validate
+//
+// The layout is as follows:
+// [1 byte: version][2 bytes: Target length][Target][1 byte: Operation]
+// [1 byte: source] followed by either nothing, the compiled ARVariable,
+// or [2 bytes: Key length][Key] depending on the source
+// [1 byte: number of Params]
+// Followed by each Param, sorted by name:
+// [2 bytes: name length][name][compiled value]
 func (ara RASetVariable) Compile() []byte {
-	b := []byte{}
-	return b
+	compiled := []byte{setVariableVersion1}
+	compiled = appendCompiledString(compiled, ara.Target)
+	compiled = append(compiled, byte(ara.Operation))
+
+	switch {
+	case ara.With.Key != nil:
+		compiled = append(compiled, setVariableSourceKey)
+		compiled = appendCompiledString(compiled, *ara.With.Key)
+	case ara.With.ARVariable != nil:
+		compiled = append(compiled, setVariableSourceInline)
+		compiled = append(compiled, ara.With.ARVariable.Compile()...)
+	default:
+		compiled = append(compiled, setVariableSourceNone)
+	}
+
+	names := []string{}
+	for name := range ara.With.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	compiled = append(compiled, byte(len(names)))
+	for _, name := range names {
+		compiled = appendCompiledString(compiled, name)
+		compiled = appendCompiledValue(compiled, ara.With.Params[name])
+	}
+
+	return compiled
 }
 
 // CreateFrom is used for evaluating the actions in Brahman and followed by Execute
 // This could be put in a single "Execute" but this is less monolothic
-func (ara *RASetVariable) CreateFrom(bytes []byte) error {
+func (ara *RASetVariable) CreateFrom(compiled []byte) error {
+	r := utilities.ByteReader{Bytes: compiled}
+
+	version, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("Error reading RASetVariable version: %s", err.Error())
+	}
+	if version != setVariableVersion1 {
+		return fmt.Errorf("Unsupported RASetVariable version: %v", version)
+	}
+
+	*ara = RASetVariable{}
+
+	ara.Target, err = r.LengthPrefixedString()
+	if err != nil {
+		return fmt.Errorf("Error reading RASetVariable target: %s", err.Error())
+	}
+
+	op, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("Error reading RASetVariable operation: %s", err.Error())
+	}
+	ara.Operation = SetVariableOperation(op)
+
+	source, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("Error reading RASetVariable source: %s", err.Error())
+	}
+	switch source {
+	case setVariableSourceNone:
+	case setVariableSourceKey:
+		key, err := r.LengthPrefixedString()
+		if err != nil {
+			return fmt.Errorf("Error reading RASetVariable key: %s", err.Error())
+		}
+		ara.With.Key = &key
+	case setVariableSourceInline:
+		ara.With.ARVariable, err = readCompiledARVariable(&r)
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("Unsupported RASetVariable source: %v", source)
+	}
+
+	numParams, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("Error reading RASetVariable params: %s", err.Error())
+	}
+	if numParams == 0 {
+		return nil
+	}
+
+	ara.With.Params = map[string]interface{}{}
+	for i := 0; i < int(numParams); i++ {
+		name, err := r.LengthPrefixedString()
+		if err != nil {
+			return fmt.Errorf("Error reading RASetVariable param name: %s", err.Error())
+		}
+		ara.With.Params[name], err = readCompiledValue(&r)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// intParam returns the integer parameter with the given name
+// Parameters decoded by CreateFrom or reloaded from JSON may be any numeric type,
+// but must be a whole number within the range of an int64
+func (ara *RASetVariable) intParam(name string) (int64, error) {
+	n, ok := toInt64(ara.With.Params[name])
+	if !ok {
+		return 0, newRuntimeError(RuntimeErrorInvalidParam, "Missing or invalid %v parameter", name)
+	}
+	return n, nil
+}
+
+// stringParam returns the string parameter with the given name
+func (ara *RASetVariable) stringParam(name string) (string, error) {
+	str, ok := ara.With.Params[name].(string)
+	if !ok {
+		return "", newRuntimeError(RuntimeErrorInvalidParam, "Missing or invalid %v parameter", name)
+	}
+	return str, nil
+}
+
 // Execute will mutate the AIRequest in some way
 // Whether it's the state itself or the OutputSSML
-func (ara *RASetVariable) Execute(state *AIRequest) {
-	original := state.State.ARVariables[ara.Target].Get()
-	var n interface{}
+func (ara *RASetVariable) Execute(state *AIRequest) error {
+	target, ok := state.State.ARVariables[ara.Target]
+	if !ok || target == nil {
+		return newRuntimeError(RuntimeErrorMissingVariable, "Target variable %q does not exist", ara.Target)
+	}
+	original := target.Get()
+
+	with := ara.With.ARVariable
 	if ara.With.Key != nil {
-		n = state.State.ARVariables[*ara.With.Key].Get()
-	} else {
-		n = ara.With.ARVariable.Get()
+		with = state.State.ARVariables[*ara.With.Key]
+		if with == nil {
+			return newRuntimeError(RuntimeErrorMissingVariable, "Variable %q does not exist", *ara.With.Key)
+		}
+	}
+	var n interface{}
+	if with != nil {
+		n = with.Get()
+	}
+
+	invalidType := func() error {
+		return newRuntimeError(RuntimeErrorInvalidType,
+			"Invalid type on RASetVariable Execute: operation %v on %q (%T) with %T",
+			ara.Operation, ara.Target, original, n)
+	}
+	// intOperands is used by the operations which are only valid for int
+	intOperands := func() (int64, int64, error) {
+		o, ok := toInt64(original)
+		if !ok {
+			return 0, 0, invalidType()
+		}
+		v, ok := toInt64(n)
+		if !ok {
+			return 0, 0, invalidType()
+		}
+		return o, v, nil
+	}
+	// floatOperands is used by the arithmetic operations on float variables
+	floatOperands := func() (float64, float64, error) {
+		o, ok := toFloat64(original)
+		if !ok {
+			return 0, 0, invalidType()
+		}
+		v, ok := toFloat64(n)
+		if !ok {
+			return 0, 0, invalidType()
+		}
+		return o, v, nil
+	}
+	isFloat := target.T == ARVariableTypeFloat
+	// element is used by the operations which add an element to an array
+	element := func() (ARVariable, error) {
+		if with == nil {
+			return ARVariable{}, invalidType()
+		}
+		return ARVariable{T: with.T, Val: with.Val}, nil
+	}
+	// index resolves the Index parameter against an array of length n
+	// Negative indexes count from the end
+	index := func(length int, inclusive bool) (int, error) {
+		i, err := ara.intParam("Index")
+		if err != nil {
+			return 0, err
+		}
+		if i < 0 {
+			i += int64(length)
+		}
+		max := int64(length) - 1
+		if inclusive {
+			max = int64(length)
+		}
+		if i < 0 || i > max {
+			return 0, newRuntimeError(RuntimeErrorInvalidParam, "Index %v out of range on %q", i, ara.Target)
+		}
+		return int(i), nil
+	}
+	// bounds returns the inclusive Min and Max parameters
+	bounds := func() (int64, int64, error) {
+		min, err := ara.intParam("Min")
+		if err != nil {
+			return 0, 0, err
+		}
+		max, err := ara.intParam("Max")
+		if err != nil {
+			return 0, 0, err
+		}
+		if min > max {
+			return 0, 0, newRuntimeError(RuntimeErrorInvalidParam, "Min %v is greater than Max %v on %q", min, max, ara.Target)
+		}
+		return min, max, nil
 	}
 	var newval interface{}
+	// intoName and popped are set by SVOPop with an Into parameter
+	var intoName string
+	var popped ARVariable
 
 	switch ara.Operation {
 	case SVOSet:
-		if ara.With.ARVariable.T != state.State.ARVariables[ara.Target].T {
-			// TODO: Better error handling here
-			log.SetFlags(log.Llongfile | log.Ltime)
-			log.Fatal("[ERROR] Inavalid type on RASetVariable Execute")
-			return
+		if with == nil || with.T != target.T {
+			return invalidType()
 		}
 		newval = n
 	case SVOAdd:
 		switch o := original.(type) {
-		case int64:
-			newval = o + n.(int64)
 		case string:
-			newval = fmt.Sprintf("%v%v", o, n.(string))
+			v, ok := n.(string)
+			if !ok {
+				return invalidType()
+			}
+			newval = o + v
 		default:
-			// TODO: Better error handling here
-			log.SetFlags(log.Llongfile | log.Ltime)
-			log.Fatal("[ERROR] Inavalid type on RASetVariable Execute")
-			return
+			if isFloat {
+				a, b, err := floatOperands()
+				if err != nil {
+					return err
+				}
+				newval = a + b
+				break
+			}
+			a, b, err := intOperands()
+			if err != nil {
+				return err
+			}
+			newval = a + b
 		}
 	case SVOSubtract:
-		switch o := original.(type) {
-		case int64:
-			newval = o - n.(int64)
-		default:
-			// TODO: Better error handling here
-			log.SetFlags(log.Llongfile | log.Ltime)
-			log.Fatal("[ERROR] Inavalid type on RASetVariable Execute")
-			return
+		if isFloat {
+			o, v, err := floatOperands()
+			if err != nil {
+				return err
+			}
+			newval = o - v
+			break
+		}
+		o, v, err := intOperands()
+		if err != nil {
+			return err
+		}
+		newval = o - v
+	case SVOMultiply:
+		if isFloat {
+			o, v, err := floatOperands()
+			if err != nil {
+				return err
+			}
+			newval = o * v
+			break
+		}
+		o, v, err := intOperands()
+		if err != nil {
+			return err
 		}
+		newval = o * v
 	case SVODivide:
-		switch o := original.(type) {
-		case int64:
-			newval = o / n.(int64)
-		default:
-			// TODO: Better error handling here
-			log.SetFlags(log.Llongfile | log.Ltime)
-			log.Fatal("[ERROR] Inavalid type on RASetVariable Execute")
-			return
+		if isFloat {
+			o, v, err := floatOperands()
+			if err != nil {
+				return err
+			}
+			if v == 0 {
+				return newRuntimeError(RuntimeErrorInvalidParam, "Division by zero on %q", ara.Target)
+			}
+			newval = o / v
+			break
 		}
+		o, v, err := intOperands()
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			return newRuntimeError(RuntimeErrorInvalidParam, "Division by zero on %q", ara.Target)
+		}
+		newval = o / v
 	case SVOModulo:
-		switch o := original.(type) {
-		case int64:
-			newval = o % n.(int64)
-		default:
-			// TODO: Better error handling here
-			log.SetFlags(log.Llongfile | log.Ltime)
-			log.Fatal("[ERROR] Inavalid type on RASetVariable Execute")
-			return
+		o, v, err := intOperands()
+		if err != nil {
+			return err
+		}
+		if v == 0 {
+			return newRuntimeError(RuntimeErrorInvalidParam, "Division by zero on %q", ara.Target)
 		}
+		newval = o % v
 	case SVONot:
 		switch o := original.(type) {
 		case bool:
 			newval = !o
 		default:
-			// TODO: Better error handling here
-			log.SetFlags(log.Llongfile | log.Ltime)
-			log.Fatal("[ERROR] Inavalid type on RASetVariable Execute")
-			return
+			return invalidType()
 		}
 	case SVOInsert:
-		index := ara.With.Params["Index"].(int)
-		switch o := original.(type) {
-		case []ARVariable:
-			newval = []ARVariable{}
-			newval = append(newval.([]ARVariable), o[:index]...)
-			newval = append(newval.([]ARVariable), n.(ARVariable))
-			newval = append(newval.([]ARVariable), o[index:]...)
-		default:
-			// TODO: Better error handling here
-			log.SetFlags(log.Llongfile | log.Ltime)
-			log.Fatal("[ERROR] Inavalid type on RASetVariable Execute")
-			return
+		o, ok := original.([]ARVariable)
+		if !ok {
+			return invalidType()
 		}
+		v, err := element()
+		if err != nil {
+			return err
+		}
+		i, err := index(len(o), true)
+		if err != nil {
+			return err
+		}
+		arr := make([]ARVariable, 0, len(o)+1)
+		arr = append(arr, o[:i]...)
+		arr = append(arr, v)
+		newval = append(arr, o[i:]...)
 	case SVODelete:
-		index := ara.With.Params["Index"].(int)
-		switch o := original.(type) {
-		case []ARVariable:
-			newval = []ARVariable{}
-			newval = append(o[:index], o[:index+1]...)
+		o, ok := original.([]ARVariable)
+		if !ok {
+			return invalidType()
+		}
+		i, err := index(len(o), false)
+		if err != nil {
+			return err
+		}
+		arr := make([]ARVariable, 0, len(o)-1)
+		arr = append(arr, o[:i]...)
+		newval = append(arr, o[i+1:]...)
+	case SVOAppend, SVOSetAdd:
+		o, ok := original.([]ARVariable)
+		if !ok {
+			return invalidType()
+		}
+		v, err := element()
+		if err != nil {
+			return err
+		}
+		if ara.Operation == SVOSetAdd {
+			for _, existing := range o {
+				if existing.T == v.T && reflect.DeepEqual(existing.Get(), v.Get()) {
+					return nil
+				}
+			}
+		}
+		arr := make([]ARVariable, 0, len(o)+1)
+		arr = append(arr, o...)
+		newval = append(arr, v)
+	case SVOPop:
+		o, ok := original.([]ARVariable)
+		if !ok {
+			return invalidType()
+		}
+		if len(o) == 0 {
+			return newRuntimeError(RuntimeErrorInvalidParam, "Cannot pop from empty array %q", ara.Target)
+		}
+		if _, ok := ara.With.Params["Into"]; ok {
+			name, err := ara.stringParam("Into")
+			if err != nil {
+				return err
+			}
+			into := state.State.ARVariables[name]
+			if into == nil {
+				return newRuntimeError(RuntimeErrorMissingVariable, "Variable %q does not exist", name)
+			}
+			popped = o[len(o)-1]
+			if into.T != popped.T {
+				return invalidType()
+			}
+			intoName = name
+		}
+		newval = append([]ARVariable{}, o[:len(o)-1]...)
+	case SVOClamp:
+		o, ok := toInt64(original)
+		if !ok {
+			return invalidType()
+		}
+		min, max, err := bounds()
+		if err != nil {
+			return err
+		}
+		switch {
+		case o < min:
+			newval = min
+		case o > max:
+			newval = max
 		default:
-			// TODO: Better error handling here
-			log.SetFlags(log.Llongfile | log.Ltime)
-			log.Fatal("[ERROR] Inavalid type on RASetVariable Execute")
-			return
+			newval = o
+		}
+	case SVORandomInt:
+		if _, ok := toInt64(original); !ok {
+			return invalidType()
 		}
+		min, max, err := bounds()
+		if err != nil {
+			return err
+		}
+		// The difference is computed in uint64, as max-min overflows an int64 for the widest bounds
+		// The span of max-min+1 values must fit within an int64 for Int63n
+		diff := uint64(max) - uint64(min)
+		if diff >= math.MaxInt64 {
+			return newRuntimeError(RuntimeErrorInvalidParam, "The range %v to %v is too wide on %q", min, max, ara.Target)
+		}
+		newval = min + state.random().Int63n(int64(diff)+1)
 	case SVOReplace:
-		search := ara.With.Params["Search"].(string)
-		replace := ara.With.Params["Replace"].(string)
-		switch o := original.(type) {
-		case string:
-			newval = strings.Replace(o, search, replace, 0)
-		default:
-			// TODO: Better error handling here
-			log.SetFlags(log.Llongfile | log.Ltime)
-			log.Fatal("[ERROR] Inavalid type on RASetVariable Execute")
-			return
+		search, err := ara.stringParam("Search")
+		if err != nil {
+			return err
+		}
+		replace, err := ara.stringParam("Replace")
+		if err != nil {
+			return err
+		}
+		count := -1
+		if _, ok := ara.With.Params["Count"]; ok {
+			n, err := ara.intParam("Count")
+			if err != nil {
+				return err
+			}
+			count = int(n)
+		}
+		o, ok := original.(string)
+		if !ok {
+			return invalidType()
+		}
+		newval = strings.Replace(o, search, replace, count)
+	case SVOUpper, SVOLower:
+		o, ok := original.(string)
+		if !ok {
+			return invalidType()
 		}
+		if ara.Operation == SVOUpper {
+			newval = strings.ToUpper(o)
+		} else {
+			newval = strings.ToLower(o)
+		}
+	default:
+		return newRuntimeError(RuntimeErrorInvalidParam, "Unsupported operation %v on %q", ara.Operation, ara.Target)
+	}
+	if reflect.DeepEqual(original, newval) {
+		return nil
+	}
+
+	// Snapshot the variables before the change for the variable update trigger
+	previous := make(map[string]*ARVariable, len(state.State.ARVariables))
+	for k, v := range state.State.ARVariables {
+		previous[k] = v
+	}
+	previous[ara.Target] = &ARVariable{T: target.T, Val: target.Val}
+
+	target.Val = newval
+	if intoName != "" {
+		into := state.State.ARVariables[intoName]
+		previous[intoName] = &ARVariable{T: into.T, Val: into.Val}
+		into.Val = popped.Val
 	}
-	state.State.ARVariables[ara.Target].Val = newval
+	return fireVariableUpdateTrigger(state, previous)
 }
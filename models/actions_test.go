@@ -1,8 +1,16 @@
 package models
 
 import (
+	"fmt"
+	"math"
 	"net/url"
+	"reflect"
 	"testing"
+
+	"github.com/talkative-ai/core/common"
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+	uuid "github.com/talkative-ai/go.uuid"
 )
 
 func TestActionSetIterable(t *testing.T) {
@@ -18,3 +26,410 @@ func TestActionSetIterable(t *testing.T) {
 	}
 
 }
+
+func TestRASetVariableCompile(t *testing.T) {
+	key := "gold"
+	tests := []RASetVariable{
+		{Target: "name", Operation: SVOSet, With: ParametizedARVariable{
+			ARVariable: &ARVariable{T: "string", Val: "Arjuna"},
+		}},
+		{Target: "gold", Operation: SVOAdd, With: ParametizedARVariable{
+			ARVariable: &ARVariable{T: "int", Val: int64(10)},
+		}},
+		{Target: "gold", Operation: SVOSubtract, With: ParametizedARVariable{
+			ARVariable: &ARVariable{T: "int", Val: int64(-3)},
+		}},
+		{Target: "gold", Operation: SVODivide, With: ParametizedARVariable{
+			Key: &key,
+		}},
+		{Target: "gold", Operation: SVOMultiply, With: ParametizedARVariable{
+			ARVariable: &ARVariable{T: "int", Val: int64(2)},
+		}},
+		{Target: "gold", Operation: SVOModulo, With: ParametizedARVariable{
+			ARVariable: &ARVariable{T: "int", Val: int64(7)},
+		}},
+		{Target: "door_open", Operation: SVONot},
+		{Target: "items", Operation: SVOInsert, With: ParametizedARVariable{
+			Params: map[string]interface{}{"Index": int64(1)},
+			ARVariable: &ARVariable{T: "array", Val: []ARVariable{
+				{T: "string", Val: "sword"},
+				{T: "bool", Val: false},
+			}},
+		}},
+		{Target: "items", Operation: SVODelete, With: ParametizedARVariable{
+			Params: map[string]interface{}{"Index": int64(0)},
+		}},
+		{Target: "name", Operation: SVOReplace, With: ParametizedARVariable{
+			Params: map[string]interface{}{"Search": "Arj", "Replace": "Bh"},
+		}},
+	}
+
+	for _, action := range tests {
+		decoded := RASetVariable{}
+		if err := decoded.CreateFrom(action.Compile()); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(action, decoded) {
+			t.Errorf("Decoded RASetVariable does not match\nExpected: %+v\nReceived: %+v", action, decoded)
+		}
+	}
+
+	compiled := tests[0].Compile()
+	compiled[0] = 0
+	if err := (&RASetVariable{}).CreateFrom(compiled); err == nil {
+		t.Error("Expected an error for an unsupported RASetVariable version")
+	}
+}
+
+func TestRAInitializeActorDialog(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	pubID := "1"
+	actorID := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	greetingID := "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+	store.HSet(KeynavCompiledDialogRootWithinActor(pubID, actorID.String()), "buy", []byte("other"))
+	store.HSet(KeynavCompiledDialogRootWithinActor(pubID, actorID.String()),
+		string(DialogInputGreeting), []byte(greetingID))
+
+	bundleKey := KeynavCompiledDialogNodeActionBundle(pubID, greetingID, 0)
+	store.Set(bundleKey, CompileActionBundle(&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "Welcome, traveller"}))
+	store.Set(KeynavCompiledEntity(pubID, AEIDDialogNode, greetingID), mustCompile(t, LBlock{AlwaysExec: bundleKey}))
+
+	action := RAInitializeActorDialog(actorID)
+	decoded := RAInitializeActorDialog{}
+	if err := decoded.CreateFrom(action.Compile()); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != action {
+		t.Fatalf("Decoded RAInitializeActorDialog does not match\nExpected: %v\nReceived: %v", action, decoded)
+	}
+
+	AAS := ActionSet{
+		SetZone:               RASetZone(uuid.FromStringOrNil("6ba7b812-9dad-11d1-80b4-00c04fd430c8")),
+		InitializeActorDialog: actorID,
+	}
+	actions := []RequestAction{}
+	for a := range AAS.Iterable() {
+		actions = append(actions, a)
+	}
+
+	state := AIRequest{
+		State:      MutableAIRequestState{PubID: pubID},
+		OutputSSML: ssml.NewBuilder(),
+	}
+	if err := ActionBundleEval(&state, CompileActionBundle(actions...)); err != nil {
+		t.Fatal(err)
+	}
+	if state.State.CurrentDialog == nil || *state.State.CurrentDialog != greetingID {
+		t.Errorf("Expected CurrentDialog %v, received %v", greetingID, state.State.CurrentDialog)
+	}
+	if state.OutputSSML.String() != "<speak>Welcome, traveller</speak>" {
+		t.Errorf("Unexpected output %v", state.OutputSSML.String())
+	}
+
+	missing := RAInitializeActorDialog(uuid.Nil)
+	err := missing.Execute(&state)
+	if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorNotFound {
+		t.Errorf("Expected a RuntimeErrorNotFound, received %v", err)
+	}
+}
+
+func TestRASetZoneTriggers(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	pubID := "1"
+	hall := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	cellar := uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+
+	storeTrigger := func(zoneID uuid.UUID, triggerType TriggerType, text string) {
+		bundleKey := KeynavCompiledTriggerActionBundle(pubID, zoneID.String(), uint64(triggerType), 0)
+		store.Set(bundleKey, CompileActionBundle(&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: text}))
+		store.HSet(KeynavCompiledTriggersWithinZone(pubID, zoneID.String()),
+			fmt.Sprintf("%v", triggerType), mustCompile(t, LBlock{AlwaysExec: bundleKey}))
+	}
+	storeTrigger(hall, TriggerInitializeZone, "[hall init]")
+	storeTrigger(hall, TriggerEnterZone, "[hall enter]")
+	storeTrigger(hall, TriggerExitZone, "[hall exit]")
+	storeTrigger(cellar, TriggerInitializeZone, "[cellar init]")
+	storeTrigger(cellar, TriggerEnterZone, "[cellar enter]")
+
+	tests := []struct {
+		zone   uuid.UUID
+		output string
+	}{
+		{hall, "[hall init][hall enter]"},
+		{cellar, "[hall exit][cellar init][cellar enter]"},
+		{hall, "[hall enter]"},
+		{cellar, "[hall exit][cellar enter]"},
+	}
+
+	state := MutableAIRequestState{PubID: pubID}
+	for i, test := range tests {
+		message := AIRequest{State: state, OutputSSML: ssml.NewBuilder()}
+		setZone := RASetZone(test.zone)
+		if err := setZone.Execute(&message); err != nil {
+			t.Fatal(err)
+		}
+		if message.OutputSSML.String() != "<speak>"+test.output+"</speak>" {
+			t.Errorf("Step %v: expected %v, received %v", i, test.output, message.OutputSSML.String())
+		}
+		if message.State.Zone != test.zone {
+			t.Errorf("Step %v: expected zone %v, received %v", i, test.zone, message.State.Zone)
+		}
+		state = message.State
+	}
+}
+
+func TestRASetZoneCascade(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	pubID := "1"
+	hall := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	cellar := uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+
+	// Entering either zone moves to the other
+	for zoneID, other := range map[uuid.UUID]uuid.UUID{hall: cellar, cellar: hall} {
+		setZone := RASetZone(other)
+		bundleKey := KeynavCompiledTriggerActionBundle(pubID, zoneID.String(), uint64(TriggerEnterZone), 0)
+		store.Set(bundleKey, CompileActionBundle(&setZone))
+		store.HSet(KeynavCompiledTriggersWithinZone(pubID, zoneID.String()),
+			fmt.Sprintf("%v", TriggerEnterZone), mustCompile(t, LBlock{AlwaysExec: bundleKey}))
+	}
+
+	message := AIRequest{State: MutableAIRequestState{PubID: pubID}, OutputSSML: ssml.NewBuilder()}
+	setZone := RASetZone(hall)
+	err := setZone.Execute(&message)
+	if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorCascadeLimit {
+		t.Fatalf("Expected a RuntimeErrorCascadeLimit, received %v", err)
+	}
+	if message.zoneChangeDepth != 0 {
+		t.Errorf("Expected the zone change depth to unwind, received %v", message.zoneChangeDepth)
+	}
+
+	// Setting the current zone ends the conversation without firing triggers
+	dialog := "greet"
+	message = AIRequest{State: MutableAIRequestState{PubID: pubID, Zone: hall, CurrentDialog: &dialog}, OutputSSML: ssml.NewBuilder()}
+	if err := setZone.Execute(&message); err != nil {
+		t.Fatal(err)
+	}
+	if message.State.Zone != hall || message.State.CurrentDialog != nil || message.OutputSSML.String() != ssml.NewBuilder().String() {
+		t.Errorf("Unexpected state %+v and output %v", message.State, message.OutputSSML.String())
+	}
+}
+
+func TestRASetVariableExecute(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	str := func(s string) ARVariable { return ARVariable{T: "string", Val: s} }
+	items := func(names ...string) *ARVariable {
+		arr := []ARVariable{}
+		for _, name := range names {
+			arr = append(arr, str(name))
+		}
+		return &ARVariable{T: "array", Val: arr}
+	}
+	with := func(arv ARVariable, params map[string]interface{}) ParametizedARVariable {
+		return ParametizedARVariable{ARVariable: &arv, Params: params}
+	}
+	integer := func(n int64) ARVariable { return ARVariable{T: "int", Val: n} }
+	params := func(kv ...interface{}) map[string]interface{} {
+		m := map[string]interface{}{}
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i].(string)] = kv[i+1]
+		}
+		return m
+	}
+	sword := "sword"
+
+	tests := []struct {
+		name      string
+		target    *ARVariable
+		operation SetVariableOperation
+		with      ParametizedARVariable
+		expected  interface{}
+		code      RuntimeErrorCode
+	}{
+		{"set", &ARVariable{T: "string", Val: "a"}, SVOSet, with(str("b"), nil), "b", -1},
+		{"set mismatched type", &ARVariable{T: "string", Val: "a"}, SVOSet, with(integer(1), nil), nil, RuntimeErrorInvalidType},
+		{"add int", &ARVariable{T: "int", Val: int64(2)}, SVOAdd, with(integer(3), nil), int64(5), -1},
+		{"add JSON number", &ARVariable{T: "int", Val: float64(2)}, SVOAdd, with(integer(3), nil), int64(5), -1},
+		{"add string", &ARVariable{T: "string", Val: "Hello "}, SVOAdd, with(str("world"), nil), "Hello world", -1},
+		{"add bool", &ARVariable{T: "bool", Val: true}, SVOAdd, with(integer(1), nil), nil, RuntimeErrorInvalidType},
+		{"subtract", &ARVariable{T: "int", Val: int64(2)}, SVOSubtract, with(integer(3), nil), int64(-1), -1},
+		{"multiply", &ARVariable{T: "int", Val: int64(4)}, SVOMultiply, with(integer(-3), nil), int64(-12), -1},
+		{"multiply string", &ARVariable{T: "string", Val: "4"}, SVOMultiply, with(integer(3), nil), nil, RuntimeErrorInvalidType},
+		{"divide", &ARVariable{T: "int", Val: int64(7)}, SVODivide, with(integer(2), nil), int64(3), -1},
+		{"divide by zero", &ARVariable{T: "int", Val: int64(7)}, SVODivide, with(integer(0), nil), nil, RuntimeErrorInvalidParam},
+		{"modulo", &ARVariable{T: "int", Val: int64(7)}, SVOModulo, with(integer(4), nil), int64(3), -1},
+		{"modulo by zero", &ARVariable{T: "int", Val: int64(7)}, SVOModulo, with(integer(0), nil), nil, RuntimeErrorInvalidParam},
+		{"not", &ARVariable{T: "bool", Val: true}, SVONot, ParametizedARVariable{}, false, -1},
+		{"insert", items("bow", "shield"), SVOInsert, with(str("sword"), params("Index", int64(1))),
+			items("bow", "sword", "shield").Val, -1},
+		{"insert at end", items("bow"), SVOInsert, with(str("sword"), params("Index", int64(1))),
+			items("bow", "sword").Val, -1},
+		{"insert negative index", items("bow", "shield"), SVOInsert, with(str("sword"), params("Index", int64(-1))),
+			items("bow", "sword", "shield").Val, -1},
+		{"insert out of range", items("bow"), SVOInsert, with(str("sword"), params("Index", int64(3))), nil, RuntimeErrorInvalidParam},
+		{"insert without index", items("bow"), SVOInsert, with(str("sword"), nil), nil, RuntimeErrorInvalidParam},
+		{"delete", items("bow", "sword", "shield"), SVODelete, with(ARVariable{}, params("Index", int64(1))),
+			items("bow", "shield").Val, -1},
+		{"delete last", items("bow", "sword"), SVODelete, with(ARVariable{}, params("Index", int64(-1))),
+			items("bow").Val, -1},
+		{"delete fractional index", items("bow", "sword"), SVODelete, with(ARVariable{}, params("Index", 1.7)), nil, RuntimeErrorInvalidParam},
+		{"delete out of range", items("bow"), SVODelete, with(ARVariable{}, params("Index", int64(1))), nil, RuntimeErrorInvalidParam},
+		{"append", items("bow"), SVOAppend, with(str("bow"), nil), items("bow", "bow").Val, -1},
+		{"append to string", &ARVariable{T: "string", Val: "bow"}, SVOAppend, with(str("bow"), nil), nil, RuntimeErrorInvalidType},
+		{"set add", items("bow"), SVOSetAdd, with(str("sword"), nil), items("bow", "sword").Val, -1},
+		{"set add existing", items("bow", "sword"), SVOSetAdd, with(str("sword"), nil), items("bow", "sword").Val, -1},
+		{"pop", items("bow", "sword"), SVOPop, ParametizedARVariable{}, items("bow").Val, -1},
+		{"pop empty", items(), SVOPop, ParametizedARVariable{}, nil, RuntimeErrorInvalidParam},
+		{"pop into missing", items("bow"), SVOPop, with(ARVariable{}, params("Into", "missing")), nil, RuntimeErrorMissingVariable},
+		{"clamp below", &ARVariable{T: "int", Val: int64(-5)}, SVOClamp, with(ARVariable{}, params("Min", int64(0), "Max", int64(10))), int64(0), -1},
+		{"clamp above", &ARVariable{T: "int", Val: int64(50)}, SVOClamp, with(ARVariable{}, params("Min", int64(0), "Max", int64(10))), int64(10), -1},
+		{"clamp within", &ARVariable{T: "int", Val: int64(5)}, SVOClamp, with(ARVariable{}, params("Min", int64(0), "Max", int64(10))), int64(5), -1},
+		{"clamp beyond float64 precision", &ARVariable{T: "int", Val: int64(1 << 60)}, SVOClamp, with(ARVariable{}, params("Min", int64(0), "Max", int64(1<<53+1))), int64(1<<53 + 1), -1},
+		{"clamp inverted", &ARVariable{T: "int", Val: int64(5)}, SVOClamp, with(ARVariable{}, params("Min", int64(10), "Max", int64(0))), nil, RuntimeErrorInvalidParam},
+		{"random int", &ARVariable{T: "int", Val: int64(0)}, SVORandomInt, with(ARVariable{}, params("Min", int64(6), "Max", int64(6))), int64(6), -1},
+		{"random int inverted", &ARVariable{T: "int", Val: int64(0)}, SVORandomInt, with(ARVariable{}, params("Min", int64(6), "Max", int64(1))), nil, RuntimeErrorInvalidParam},
+		{"random int too wide", &ARVariable{T: "int", Val: int64(0)}, SVORandomInt, with(ARVariable{}, params("Min", int64(math.MinInt64), "Max", int64(0))), nil, RuntimeErrorInvalidParam},
+		{"random int without bounds", &ARVariable{T: "int", Val: int64(0)}, SVORandomInt, ParametizedARVariable{}, nil, RuntimeErrorInvalidParam},
+		{"replace", &ARVariable{T: "string", Val: "a-b-c"}, SVOReplace, with(ARVariable{}, params("Search", "-", "Replace", "+")), "a+b+c", -1},
+		{"replace count", &ARVariable{T: "string", Val: "a-b-c"}, SVOReplace, with(ARVariable{}, params("Search", "-", "Replace", "+", "Count", int64(1))), "a+b-c", -1},
+		{"replace without search", &ARVariable{T: "string", Val: "a-b-c"}, SVOReplace, with(ARVariable{}, params("Replace", "+")), nil, RuntimeErrorInvalidParam},
+		{"upper", &ARVariable{T: "string", Val: "Hello"}, SVOUpper, ParametizedARVariable{}, "HELLO", -1},
+		{"lower", &ARVariable{T: "string", Val: "Hello"}, SVOLower, ParametizedARVariable{}, "hello", -1},
+		{"lower int", &ARVariable{T: "int", Val: int64(1)}, SVOLower, ParametizedARVariable{}, nil, RuntimeErrorInvalidType},
+		{"unsupported", &ARVariable{T: "int", Val: int64(1)}, SetVariableOperation(99), ParametizedARVariable{}, nil, RuntimeErrorInvalidParam},
+		{"keyed", &ARVariable{T: "string", Val: "a"}, SVOSet, ParametizedARVariable{Key: &sword}, "sword", -1},
+	}
+
+	for _, test := range tests {
+		originalVal := reflect.ValueOf(test.target.Val)
+		var originalItems []ARVariable
+		if arr, ok := test.target.Val.([]ARVariable); ok {
+			originalItems = append([]ARVariable{}, arr...)
+		}
+
+		message := AIRequest{
+			State: MutableAIRequestState{
+				ARVariables: map[string]*ARVariable{
+					"target": test.target,
+					"sword":  {T: "string", Val: "sword"},
+				},
+			},
+		}
+		action := RASetVariable{Target: "target", Operation: test.operation, With: test.with}
+		err := action.Execute(&message)
+
+		if test.code >= 0 {
+			if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != test.code {
+				t.Errorf("%v: expected RuntimeError %v, received %v", test.name, test.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if received := message.State.ARVariables["target"].Val; !reflect.DeepEqual(received, test.expected) {
+			t.Errorf("%v: expected %#v, received %#v", test.name, test.expected, received)
+		}
+		// Array operations must not modify the previous value in place
+		if originalItems != nil && originalVal.Len() > 0 &&
+			!reflect.DeepEqual(originalVal.Slice(0, len(originalItems)).Interface(), originalItems) {
+			t.Errorf("%v: the previous array was modified", test.name)
+		}
+	}
+}
+
+func TestRASetVariableRandomIntWide(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	tests := []struct {
+		name     string
+		min, max interface{}
+		valid    bool
+	}{
+		{"near the maximum", int64(math.MaxInt64 - 10), int64(math.MaxInt64), true},
+		{"near the minimum", int64(math.MinInt64), int64(math.MinInt64 + 10), true},
+		{"beyond float64 precision", int64(1<<53 + 1), int64(1<<53 + 1), true},
+		{"widest", int64(-1 << 62), int64(1<<62 - 1<<10), true},
+		{"too wide", int64(math.MinInt64), int64(math.MaxInt64), false},
+		{"beyond int64", float64(0), float64(1 << 63), false},
+		{"fractional", float64(0.5), float64(6), false},
+	}
+	for _, test := range tests {
+		roll := RASetVariable{Target: "roll", Operation: SVORandomInt, With: ParametizedARVariable{
+			Params: map[string]interface{}{"Min": test.min, "Max": test.max},
+		}}
+		decoded := RASetVariable{}
+		if err := decoded.CreateFrom(roll.Compile()); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		for seed := uint64(0); seed < 20; seed++ {
+			message := AIRequest{State: MutableAIRequestState{
+				ARVariables: map[string]*ARVariable{"roll": {T: "int", Val: int64(0)}},
+				Rand:        &common.SessionRand{State: seed},
+			}}
+			err := decoded.Execute(&message)
+			if !test.valid {
+				if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorInvalidParam {
+					t.Errorf("%v: expected a RuntimeErrorInvalidParam, received %v", test.name, err)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatalf("%v: %v", test.name, err)
+			}
+			n := message.State.ARVariables["roll"].Val.(int64)
+			if min, max := test.min.(int64), test.max.(int64); n < min || n > max {
+				t.Fatalf("%v: expected a roll within %v and %v, received %v", test.name, min, max, n)
+			}
+		}
+	}
+}
+
+func TestRASetVariablePopInto(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	message := AIRequest{
+		State: MutableAIRequestState{
+			ARVariables: map[string]*ARVariable{
+				"items": {T: "array", Val: []ARVariable{{T: "string", Val: "bow"}, {T: "string", Val: "sword"}}},
+				"held":  {T: "string", Val: ""},
+				"gold":  {T: "int", Val: int64(0)},
+			},
+		},
+	}
+
+	pop := RASetVariable{Target: "items", Operation: SVOPop, With: ParametizedARVariable{
+		Params: map[string]interface{}{"Into": "held"},
+	}}
+	if err := pop.Execute(&message); err != nil {
+		t.Fatal(err)
+	}
+	if held := message.State.ARVariables["held"].Val; held != "sword" {
+		t.Errorf("Expected to hold the sword, received %v", held)
+	}
+	if items := message.State.ARVariables["items"].Val.([]ARVariable); len(items) != 1 {
+		t.Errorf("Expected one remaining item, received %v", items)
+	}
+
+	pop.With.Params["Into"] = "gold"
+	err := pop.Execute(&message)
+	if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorInvalidType {
+		t.Errorf("Expected a RuntimeErrorInvalidType popping a string into an int, received %v", err)
+	}
+}
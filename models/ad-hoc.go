@@ -25,13 +25,14 @@ type ProjectItem struct {
 }
 
 type VersionedProject struct {
-	ProjectID   uuid.UUID
-	Version     int64
-	Title       string
-	Category    ProjectCategory
-	Tags        ProjectTagArray
-	ProjectData ProjectItemArray
-	TriggerData ProjectTriggerItemArray
+	ProjectID    uuid.UUID
+	Version      int64
+	Title        string
+	Category     ProjectCategory
+	Tags         ProjectTagArray
+	ProjectData  ProjectItemArray
+	TriggerData  ProjectTriggerItemArray
+	VariableData ProjectVariableArray
 }
 type ProjectTriggerItem struct {
 	ProjectID   uuid.UUID
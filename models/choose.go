@@ -0,0 +1,196 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	utilities "github.com/talkative-ai/core"
+)
+
+// ChooseMode is how an RAChooseSound picks one of its variants
+type ChooseMode uint8
+
+const (
+	// ChooseUniform picks any variant with equal probability
+	ChooseUniform ChooseMode = iota
+	// ChooseWeighted picks a variant in proportion to its weight
+	ChooseWeighted
+	// ChooseNoRepeat picks any variant except the most recently chosen ones
+	ChooseNoRepeat
+)
+
+// choiceHistoryLength is the number of chosen variants kept in the state for each choice
+const choiceHistoryLength = 16
+
+/////////////////////
+// RAChooseSound //
+/////////////////////
+
+// RAChooseSound plays one of several RAPlaySound variants
+// The chosen index is recorded in MutableAIRequestState.Choices under the Key
+// so that a session can be reproduced from its event log
+type RAChooseSound struct {
+	// Key uniquely identifies the choice within the project
+	Key      string
+	Mode     ChooseMode
+	Variants []RAPlaySound
+	// Weights of each variant, used by ChooseWeighted
+	Weights []uint32 `json:",omitempty"`
+	// NoRepeat is the number of most recent variants excluded by ChooseNoRepeat
+	NoRepeat uint16 `json:",omitempty"`
+}
+
+// GetRAID returns the ActionID of the current RequestAction
+func (ara *RAChooseSound) GetRAID() ActionID {
+	return RAIDChooseSound
+}
+
+// Compile is used by Lakshmi
+// Returns the compiled []byte slice of the runtime action
+// To be stored in Redis
+//
+// The layout is as follows:
+// [1 byte: Mode][2 bytes: NoRepeat][2 bytes: Key length][Key][2 bytes: number of variants]
+// followed by each [4 bytes: weight][4 bytes: compiled RAPlaySound length][compiled RAPlaySound]
+func (ara RAChooseSound) Compile() []byte {
+	compiled := []byte{byte(ara.Mode)}
+	noRepeat := make([]byte, 2)
+	binary.LittleEndian.PutUint16(noRepeat, ara.NoRepeat)
+	compiled = append(compiled, noRepeat...)
+	compiled = appendCompiledString(compiled, ara.Key)
+
+	count := make([]byte, 2)
+	binary.LittleEndian.PutUint16(count, uint16(len(ara.Variants)))
+	compiled = append(compiled, count...)
+	for i, variant := range ara.Variants {
+		var weight uint32
+		if i < len(ara.Weights) {
+			weight = ara.Weights[i]
+		}
+		sound := variant.Compile()
+		header := make([]byte, 8)
+		binary.LittleEndian.PutUint32(header, weight)
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(sound)))
+		compiled = append(compiled, header...)
+		compiled = append(compiled, sound...)
+	}
+	return compiled
+}
+
+// CreateFrom is used for evaluating the actions in Brahman and followed by Execute
+// This could be put in a single "Execute" but this is less monolothic
+func (ara *RAChooseSound) CreateFrom(bytes []byte) error {
+	r := utilities.NewByteReader(bytes)
+	mode, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("Error reading ChooseMode: %s", err.Error())
+	}
+	ara.Mode = ChooseMode(mode)
+	if ara.NoRepeat, err = r.Uint16(); err != nil {
+		return fmt.Errorf("Error reading NoRepeat: %s", err.Error())
+	}
+	if ara.Key, err = r.LengthPrefixedString(); err != nil {
+		return fmt.Errorf("Error reading choice key: %s", err.Error())
+	}
+	n, err := r.Uint16()
+	if err != nil {
+		return fmt.Errorf("Error reading variant count: %s", err.Error())
+	}
+	// Every variant is at least 9 bytes, so more variants cannot fit
+	if uint64(n)*9 > r.Remaining() {
+		return fmt.Errorf("Error reading variants: %s", utilities.ErrTruncated.Error())
+	}
+
+	ara.Variants = make([]RAPlaySound, n)
+	ara.Weights = make([]uint32, n)
+	for i := range ara.Variants {
+		if ara.Weights[i], err = r.Uint32(); err != nil {
+			return fmt.Errorf("Error reading variant weight: %s", err.Error())
+		}
+		length, err := r.Uint32()
+		if err != nil {
+			return fmt.Errorf("Error reading variant length: %s", err.Error())
+		}
+		sound, err := r.ReadNBytes(uint64(length))
+		if err != nil {
+			return fmt.Errorf("Error reading variant: %s", err.Error())
+		}
+		if err := ara.Variants[i].CreateFrom(sound); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// choose returns the index of the variant to play
+func (ara *RAChooseSound) choose(message *AIRequest) (int, error) {
+	n := len(ara.Variants)
+	switch ara.Mode {
+	case ChooseUniform:
+		return message.random().Intn(n), nil
+	case ChooseWeighted:
+		if len(ara.Weights) != n {
+			return 0, newRuntimeError(RuntimeErrorInvalidParam, "Expected %v weights on %q, found %v", n, ara.Key, len(ara.Weights))
+		}
+		var total uint64
+		for _, w := range ara.Weights {
+			total += uint64(w)
+		}
+		if total == 0 {
+			return 0, newRuntimeError(RuntimeErrorInvalidParam, "Weights on %q are all zero", ara.Key)
+		}
+		pick := uint64(message.random().Int63n(int64(total)))
+		for i, w := range ara.Weights {
+			if pick < uint64(w) {
+				return i, nil
+			}
+			pick -= uint64(w)
+		}
+		return n - 1, nil
+	case ChooseNoRepeat:
+		// At least one variant always remains to be chosen
+		exclude := int(ara.NoRepeat)
+		if exclude > n-1 {
+			exclude = n - 1
+		}
+		history := message.State.Choices[ara.Key]
+		if exclude > len(history) {
+			exclude = len(history)
+		}
+		recent := map[int]bool{}
+		for _, i := range history[len(history)-exclude:] {
+			recent[i] = true
+		}
+		candidates := make([]int, 0, n)
+		for i := 0; i < n; i++ {
+			if !recent[i] {
+				candidates = append(candidates, i)
+			}
+		}
+		return candidates[message.random().Intn(len(candidates))], nil
+	}
+	return 0, newRuntimeError(RuntimeErrorInvalidParam, "Unsupported ChooseMode %v on %q", ara.Mode, ara.Key)
+}
+
+// Execute will mutate the AIRequest in some way
+// Whether it's the state itself or the OutputSSML
+func (ara *RAChooseSound) Execute(message *AIRequest) error {
+	if len(ara.Variants) == 0 {
+		return newRuntimeError(RuntimeErrorInvalidParam, "No variants to choose from on %q", ara.Key)
+	}
+	i, err := ara.choose(message)
+	if err != nil {
+		return err
+	}
+
+	if message.State.Choices == nil {
+		message.State.Choices = map[string][]int{}
+	}
+	history := append(message.State.Choices[ara.Key], i)
+	if len(history) > choiceHistoryLength {
+		history = history[len(history)-choiceHistoryLength:]
+	}
+	message.State.Choices[ara.Key] = history
+
+	return ara.Variants[i].Execute(message)
+}
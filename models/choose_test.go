@@ -0,0 +1,148 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/talkative-ai/core/common"
+	ssml "github.com/talkative-ai/go-ssml"
+)
+
+func chooseVariants(texts ...string) []RAPlaySound {
+	variants := make([]RAPlaySound, len(texts))
+	for i, text := range texts {
+		variants[i] = RAPlaySound{SoundType: RAPlaySoundTypeText, Val: text}
+	}
+	return variants
+}
+
+func TestRAChooseSoundCompile(t *testing.T) {
+	choice := RAChooseSound{
+		Key:      "greeting",
+		Mode:     ChooseWeighted,
+		Variants: chooseVariants("Hello", "Hi there"),
+		Weights:  []uint32{3, 1},
+		NoRepeat: 1,
+	}
+	decoded := RAChooseSound{}
+	if err := decoded.CreateFrom(choice.Compile()); err != nil {
+		t.Fatal(err)
+	}
+	clearSoundTemplates(decoded.Variants)
+	if !reflect.DeepEqual(decoded, choice) {
+		t.Errorf("Expected %+v, received %+v", choice, decoded)
+	}
+
+	compiled := choice.Compile()
+	for i := 0; i < len(compiled); i++ {
+		if err := new(RAChooseSound).CreateFrom(compiled[:i]); err == nil {
+			t.Errorf("Expected an error decoding %v of %v bytes", i, len(compiled))
+		}
+	}
+}
+
+func TestRAChooseSoundReplay(t *testing.T) {
+	choice := RAChooseSound{Key: "greeting", Variants: chooseVariants("a", "b", "c", "d")}
+
+	play := func(seed int64) (string, []int) {
+		message := AIRequest{
+			State:      MutableAIRequestState{Rand: common.NewSessionRand(seed)},
+			OutputSSML: ssml.NewBuilder(),
+		}
+		for i := 0; i < 10; i++ {
+			if err := choice.Execute(&message); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return message.OutputSSML.String(), message.State.Choices["greeting"]
+	}
+
+	output, history := play(7)
+	replayOutput, replayHistory := play(7)
+	if output != replayOutput || !reflect.DeepEqual(history, replayHistory) {
+		t.Errorf("Expected the same seed to replay %v %v, received %v %v", output, history, replayOutput, replayHistory)
+	}
+	if len(history) != 10 {
+		t.Fatalf("Expected 10 recorded choices, received %v", history)
+	}
+
+	// The recorded indexes reproduce the output
+	expected := ssml.NewBuilder()
+	for _, i := range history {
+		expected = expected.Paragraph(choice.Variants[i].Val.(string))
+	}
+	if expected.String() != output {
+		t.Errorf("Expected %v from the recorded choices, received %v", expected.String(), output)
+	}
+}
+
+func TestRAChooseSoundModes(t *testing.T) {
+	message := AIRequest{
+		State:      MutableAIRequestState{Rand: common.NewSessionRand(1)},
+		OutputSSML: ssml.NewBuilder(),
+	}
+
+	weighted := RAChooseSound{
+		Key:      "weighted",
+		Mode:     ChooseWeighted,
+		Variants: chooseVariants("never", "always", "never"),
+		Weights:  []uint32{0, 5, 0},
+	}
+	for i := 0; i < 20; i++ {
+		if err := weighted.Execute(&message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, i := range message.State.Choices["weighted"] {
+		if i != 1 {
+			t.Fatalf("Expected only variant 1 to be chosen, received %v", message.State.Choices["weighted"])
+		}
+	}
+
+	noRepeat := RAChooseSound{
+		Key:      "no-repeat",
+		Mode:     ChooseNoRepeat,
+		Variants: chooseVariants("a", "b", "c"),
+		NoRepeat: 2,
+	}
+	for i := 0; i < choiceHistoryLength+4; i++ {
+		if err := noRepeat.Execute(&message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history := message.State.Choices["no-repeat"]
+	if len(history) != choiceHistoryLength {
+		t.Errorf("Expected the history to be capped at %v, received %v", choiceHistoryLength, len(history))
+	}
+	for i := 2; i < len(history); i++ {
+		if history[i] == history[i-1] || history[i] == history[i-2] {
+			t.Fatalf("Expected no repeats within 2 choices, received %v", history)
+		}
+	}
+
+	// NoRepeat beyond the number of variants still leaves one to choose
+	single := RAChooseSound{Key: "single", Mode: ChooseNoRepeat, Variants: chooseVariants("only"), NoRepeat: 5}
+	for i := 0; i < 3; i++ {
+		if err := single.Execute(&message); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRAChooseSoundInvalid(t *testing.T) {
+	for _, choice := range []RAChooseSound{
+		{Key: "empty"},
+		{Key: "weights", Mode: ChooseWeighted, Variants: chooseVariants("a", "b"), Weights: []uint32{1}},
+		{Key: "zero", Mode: ChooseWeighted, Variants: chooseVariants("a"), Weights: []uint32{0}},
+		{Key: "mode", Mode: ChooseMode(9), Variants: chooseVariants("a")},
+	} {
+		message := AIRequest{OutputSSML: ssml.NewBuilder()}
+		err := choice.Execute(&message)
+		if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorInvalidParam {
+			t.Errorf("%v: expected a RuntimeErrorInvalidParam, received %v", choice.Key, err)
+		}
+		if len(message.State.Choices) != 0 {
+			t.Errorf("%v: expected no choice to be recorded", choice.Key)
+		}
+	}
+}
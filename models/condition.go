@@ -0,0 +1,395 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	utilities "github.com/talkative-ai/core"
+)
+
+// ConditionType is the type of a node within a Condition tree
+type ConditionType string
+
+const (
+	// ConditionAnd yields true if every child yields true
+	ConditionAnd ConditionType = "and"
+	// ConditionOr yields true if at least one child yields true
+	ConditionOr ConditionType = "or"
+	// ConditionNot yields the negation of its only child
+	ConditionNot ConditionType = "not"
+	// ConditionCompare compares a variable to a value or to another variable
+	ConditionCompare ConditionType = "compare"
+)
+
+// maxConditionDepth bounds the nesting of a Condition tree
+const maxConditionDepth = 32
+
+// compiledConditionMarker precedes a compiled Condition within a compiled LStatement
+// in place of the AndGroup count of a compiled OrGroup. OrGroups are therefore limited to 254 AndGroups
+const compiledConditionMarker byte = 0xFF
+
+// Compiled node type tags used within compiled Conditions
+var conditionTypeTags = map[ConditionType]byte{
+	ConditionAnd:     1,
+	ConditionOr:      2,
+	ConditionNot:     3,
+	ConditionCompare: 4,
+}
+
+var conditionTagTypes = func() map[byte]ConditionType {
+	m := map[byte]ConditionType{}
+	for t, tag := range conditionTypeTags {
+		m[tag] = t
+	}
+	return m
+}()
+
+// Condition is a node within a tree of conditional logic, e.g. (A or B) and not C
+// An And without children yields true and an Or without children yields false
+type Condition struct {
+	Type     ConditionType
+	Children []Condition `json:",omitempty"`
+
+	// Operator, Var, Value and OtherVar are only used by ConditionCompare
+	// Var is compared to the variable OtherVar if it is set, otherwise to Value
+	// Operators on the runtime state rather than a variable, such as OpStrHasItem, ignore Var
+	Operator OperatorStr `json:",omitempty"`
+	Var      string      `json:",omitempty"`
+	Value    interface{} `json:",omitempty"`
+	OtherVar string      `json:",omitempty"`
+}
+
+// stateOperators are the operators which ignore Var
+var stateOperators = map[OperatorStr]bool{
+	OpStrHasItem:      true,
+	OpStrZoneVisited:  true,
+	OpStrActorPresent: true,
+}
+
+// variableOperators are the operators which may compare Var to OtherVar
+var variableOperators = map[OperatorStr]bool{
+	OpStrEQ:           true,
+	OpStrLT:           true,
+	OpStrGT:           true,
+	OpStrLE:           true,
+	OpStrGE:           true,
+	OpStrNE:           true,
+	OpStrContains:     true,
+	OpStrStartsWith:   true,
+	OpStrContainsText: true,
+}
+
+// Condition converts the OrGroup into the equivalent Condition tree
+// A nil or empty OrGroup has no conditions and converts to nil
+func (group *OrGroup) Condition() *Condition {
+	if group == nil || len(*group) == 0 {
+		return nil
+	}
+
+	or := Condition{Type: ConditionOr}
+	for _, and := range *group {
+		ops := []OperatorStr{}
+		for op := range and {
+			ops = append(ops, op)
+		}
+		sort.Slice(ops, func(i, j int) bool {
+			return operatorStrInt[ops[i]] < operatorStrInt[ops[j]] ||
+				operatorStrInt[ops[i]] == operatorStrInt[ops[j]] && ops[i] < ops[j]
+		})
+
+		node := Condition{Type: ConditionAnd}
+		for _, op := range ops {
+			ids := []int{}
+			for id := range and[op] {
+				ids = append(ids, id)
+			}
+			sort.Ints(ids)
+			for _, id := range ids {
+				node.Children = append(node.Children, Condition{
+					Type:     ConditionCompare,
+					Operator: op,
+					Var:      strconv.Itoa(id),
+					Value:    and[op][id],
+				})
+			}
+		}
+		or.Children = append(or.Children, node)
+	}
+
+	return &or
+}
+
+// Validate returns an error describing the first invalid node of the Condition tree
+func (c *Condition) Validate() error {
+	if c == nil {
+		return nil
+	}
+	return c.validate(0)
+}
+
+func (c *Condition) validate(depth int) error {
+	if depth >= maxConditionDepth {
+		return fmt.Errorf("Conditions may not be nested more than %v deep", maxConditionDepth)
+	}
+
+	switch c.Type {
+	case ConditionAnd, ConditionOr, ConditionNot:
+		if c.Type == ConditionNot && len(c.Children) != 1 {
+			return fmt.Errorf("Expected a single condition within not, received %v", len(c.Children))
+		}
+		if len(c.Children) > math.MaxUint16 {
+			return fmt.Errorf("Too many conditions within %v", c.Type)
+		}
+		for i := range c.Children {
+			if err := c.Children[i].validate(depth + 1); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ConditionCompare:
+	default:
+		return fmt.Errorf("Unsupported condition type %q", c.Type)
+	}
+
+	if len(c.Children) > 0 {
+		return fmt.Errorf("Unexpected conditions within %v", c.Operator)
+	}
+	if _, ok := operatorStrInt[c.Operator]; !ok {
+		return fmt.Errorf("Unsupported operator %q", c.Operator)
+	}
+	if c.Var == "" && !stateOperators[c.Operator] {
+		return fmt.Errorf("Missing variable for %v", c.Operator)
+	}
+	if err := checkCompiledString(c.Var, "variable name"); err != nil {
+		return err
+	}
+	if c.OtherVar == "" {
+		if c.Value == nil {
+			return fmt.Errorf("Missing value for %v", c.Operator)
+		}
+		return checkCompiledValue(c.Value)
+	}
+	if err := checkCompiledString(c.OtherVar, "variable name"); err != nil {
+		return err
+	}
+	if c.Value != nil {
+		return fmt.Errorf("Expected either a value or a variable for %v", c.Operator)
+	}
+	if !variableOperators[c.Operator] {
+		return fmt.Errorf("Unable to compare %v to a variable", c.Operator)
+	}
+	return nil
+}
+
+// RenameVariables replaces each variable of the Condition tree found within names
+// e.g. the legacy numeric variables, see LegacyVariableNames
+func (c *Condition) RenameVariables(names map[string]string) {
+	if c == nil {
+		return
+	}
+	for i := range c.Children {
+		c.Children[i].RenameVariables(names)
+	}
+	if name, ok := names[c.Var]; ok && !stateOperators[c.Operator] {
+		c.Var = name
+	}
+	if name, ok := names[c.OtherVar]; ok {
+		c.OtherVar = name
+	}
+}
+
+// Evaluate yields true if the Condition holds against the runtime state
+// A nil Condition has no conditions and therefore always yields true
+func (c *Condition) Evaluate(state *MutableAIRequestState) bool {
+	if c == nil {
+		return true
+	}
+
+	switch c.Type {
+	case ConditionAnd:
+		for i := range c.Children {
+			if !c.Children[i].Evaluate(state) {
+				return false
+			}
+		}
+		return true
+	case ConditionOr:
+		for i := range c.Children {
+			if c.Children[i].Evaluate(state) {
+				return true
+			}
+		}
+		return false
+	case ConditionNot:
+		return len(c.Children) == 1 && !c.Children[0].Evaluate(state)
+	case ConditionCompare:
+		op, ok := operatorStrInt[c.Operator]
+		if !ok {
+			return false
+		}
+		if c.OtherVar == "" {
+			return evaluateOperator(state, op, c.Var, c.Value)
+		}
+		other, ok := state.ARVariables[c.OtherVar]
+		return ok && other != nil && evaluateOperator(state, op, c.Var, other.Val)
+	}
+
+	return false
+}
+
+// Compile returns the compiled []byte slice of the Condition
+// The Condition is expected to be valid, see Validate
+//
+// The layout of each node is as follows:
+// [1 byte: node type]
+// And, Or and Not nodes are followed by their children:
+// [2 bytes: number of children][compiled children]
+// Compare nodes are followed by the comparison:
+// [varint: OperatorInt][2 bytes: Var length][Var]
+// [1 byte: 1][2 bytes: OtherVar length][OtherVar] or [1 byte: 0][compiled value]
+func (c *Condition) Compile() []byte {
+	return c.appendCompiled([]byte{})
+}
+
+func (c *Condition) appendCompiled(compiled []byte) []byte {
+	compiled = append(compiled, conditionTypeTags[c.Type])
+	if c.Type != ConditionCompare {
+		lenBytes := make([]byte, 2)
+		binary.LittleEndian.PutUint16(lenBytes, uint16(len(c.Children)))
+		compiled = append(compiled, lenBytes...)
+		for i := range c.Children {
+			compiled = c.Children[i].appendCompiled(compiled)
+		}
+		return compiled
+	}
+
+	compiled = appendCompiledOperator(compiled, operatorStrInt[c.Operator])
+	compiled = appendCompiledString(compiled, c.Var)
+	if c.OtherVar != "" {
+		return appendCompiledString(append(compiled, 1), c.OtherVar)
+	}
+	return appendCompiledValue(append(compiled, 0), c.Value)
+}
+
+// readCompiledCondition decodes a Condition compiled by Condition.Compile
+func readCompiledCondition(r *utilities.ByteReader, depth int) (Condition, error) {
+	c := Condition{}
+	if depth >= maxConditionDepth {
+		return c, fmt.Errorf("Conditions may not be nested more than %v deep", maxConditionDepth)
+	}
+
+	tag, err := r.ReadByte()
+	if err != nil {
+		return c, fmt.Errorf("Error reading condition: %s", err.Error())
+	}
+	t, ok := conditionTagTypes[tag]
+	if !ok {
+		return c, fmt.Errorf("Unsupported condition type: %v", tag)
+	}
+	c.Type = t
+
+	if t != ConditionCompare {
+		n, err := r.Uint16()
+		if err != nil {
+			return c, fmt.Errorf("Error reading condition count: %s", err.Error())
+		}
+		for i := 0; i < int(n); i++ {
+			child, err := readCompiledCondition(r, depth+1)
+			if err != nil {
+				return c, err
+			}
+			c.Children = append(c.Children, child)
+		}
+		return c, nil
+	}
+
+	op, err := readCompiledOperator(r)
+	if err != nil {
+		return c, err
+	}
+	c.Operator = operatorIntStr[op]
+	if c.Var, err = r.LengthPrefixedString(); err != nil {
+		return c, fmt.Errorf("Error reading condition variable: %s", err.Error())
+	}
+	isVar, err := r.ReadByte()
+	if err != nil {
+		return c, fmt.Errorf("Error reading condition operand: %s", err.Error())
+	}
+	if isVar != 0 {
+		if c.OtherVar, err = r.LengthPrefixedString(); err != nil {
+			return c, fmt.Errorf("Error reading condition variable: %s", err.Error())
+		}
+		return c, nil
+	}
+	c.Value, err = readCompiledValue(r)
+	return c, err
+}
+
+// readCompiledStatementCondition reads either the OrGroup or the Condition of a compiled LStatement
+func readCompiledStatementCondition(r *utilities.ByteReader) (*OrGroup, *Condition, error) {
+	if r.Remaining() == 0 || r.Bytes[r.Position] != compiledConditionMarker {
+		group, err := readCompiledOrGroup(r)
+		return group, nil, err
+	}
+	r.Position++
+	c, err := readCompiledCondition(r, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, &c, nil
+}
+
+// evaluateCompiledStatementCondition evaluates either the OrGroup or the Condition of a compiled LStatement
+func evaluateCompiledStatementCondition(r *utilities.ByteReader, state *MutableAIRequestState) (bool, error) {
+	if r.Remaining() == 0 || r.Bytes[r.Position] != compiledConditionMarker {
+		return evaluateCompiledOrGroup(r, state)
+	}
+	r.Position++
+	c, err := readCompiledCondition(r, 0)
+	if err != nil {
+		return false, err
+	}
+	return c.Evaluate(state), nil
+}
+
+// describeCondition renders the Condition as a readable expression
+// e.g. `($1 > 100 || $2 == "sword") && !(has_item("key"))`
+func describeCondition(c *Condition) string {
+	if c == nil {
+		return ""
+	}
+
+	switch c.Type {
+	case ConditionAnd, ConditionOr:
+		if len(c.Children) == 0 {
+			return fmt.Sprintf("%v()", c.Type)
+		}
+		joiner := " && "
+		if c.Type == ConditionOr {
+			joiner = " || "
+		}
+		parts := []string{}
+		for i := range c.Children {
+			part := describeCondition(&c.Children[i])
+			if len(c.Children[i].Children) > 1 && c.Children[i].Type != c.Type {
+				part = "(" + part + ")"
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, joiner)
+	case ConditionNot:
+		if len(c.Children) != 1 {
+			return "!()"
+		}
+		return "!(" + describeCondition(&c.Children[0]) + ")"
+	}
+
+	if c.OtherVar != "" {
+		return fmt.Sprintf("$%v %v $%v", c.Var, operatorSymbols[c.Operator], c.OtherVar)
+	}
+	return describeComparison(c.Operator, c.Var, c.Value)
+}
@@ -0,0 +1,240 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	utilities "github.com/talkative-ai/core"
+)
+
+// testCondition is (($1 > 100 || $2 == "sword") && !has_item(key)) || $3 > $1
+func testCondition() *Condition {
+	return &Condition{Type: ConditionOr, Children: []Condition{
+		{Type: ConditionAnd, Children: []Condition{
+			{Type: ConditionOr, Children: []Condition{
+				{Type: ConditionCompare, Operator: OpStrGT, Var: "1", Value: int64(100)},
+				{Type: ConditionCompare, Operator: OpStrEQ, Var: "2", Value: "sword"},
+			}},
+			{Type: ConditionNot, Children: []Condition{
+				{Type: ConditionCompare, Operator: OpStrHasItem, Value: testItemKey.String()},
+			}},
+		}},
+		{Type: ConditionCompare, Operator: OpStrGT, Var: "3", OtherVar: "1"},
+	}}
+}
+
+func TestConditionEvaluate(t *testing.T) {
+	tests := []struct {
+		gold, debt int64
+		weapon     string
+		inventory  map[string]uint32
+		result     bool
+	}{
+		{150, 0, "shield", nil, true},
+		{50, 0, "sword", nil, true},
+		{50, 0, "shield", nil, false},
+		{150, 0, "sword", map[string]uint32{testItemKey.String(): 1}, false},
+		{150, 200, "sword", map[string]uint32{testItemKey.String(): 1}, true},
+	}
+
+	condition := testCondition()
+	compiled := mustCompile(t, LBlock{Statements: &[][]LStatement{{{Condition: condition, Exec: "yes"}}}})
+	for i, test := range tests {
+		state := MutableAIRequestState{
+			Inventory: test.inventory,
+			ARVariables: map[string]*ARVariable{
+				"1": {T: "int", Val: test.gold},
+				"2": {T: "string", Val: test.weapon},
+				"3": {T: "int", Val: test.debt},
+			},
+		}
+		if condition.Evaluate(&state) != test.result {
+			t.Errorf("Case %v: expected %v", i, test.result)
+		}
+
+		executed := false
+		message := AIRequest{State: state}
+		err := LogicEval(&message, compiled, func(key string) error {
+			executed = executed || key == "yes"
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if executed != test.result {
+			t.Errorf("Case %v: expected the compiled condition to yield %v", i, test.result)
+		}
+	}
+
+	// The same operator may be used more than once on the same variable
+	twice := Condition{Type: ConditionAnd, Children: []Condition{
+		{Type: ConditionCompare, Operator: OpStrNE, Var: "1", Value: int64(3)},
+		{Type: ConditionCompare, Operator: OpStrNE, Var: "1", Value: int64(5)},
+	}}
+	for gold, result := range map[int64]bool{3: false, 4: true, 5: false} {
+		state := MutableAIRequestState{ARVariables: map[string]*ARVariable{"1": {T: "int", Val: gold}}}
+		if twice.Evaluate(&state) != result {
+			t.Errorf("Expected %v for %v", result, gold)
+		}
+	}
+
+	if !(*Condition)(nil).Evaluate(&MutableAIRequestState{}) {
+		t.Error("Expected a nil Condition to yield true")
+	}
+	if (&Condition{Type: ConditionOr}).Evaluate(&MutableAIRequestState{}) {
+		t.Error("Expected an Or without children to yield false")
+	}
+}
+
+func TestConditionCompile(t *testing.T) {
+	condition := testCondition()
+	compiled := condition.Compile()
+	decoded, err := readCompiledCondition(utilities.NewByteReader(compiled), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, condition) {
+		t.Errorf("Expected %+v, decoded %+v", condition, decoded)
+	}
+
+	for i := 0; i < len(compiled); i++ {
+		if _, err := readCompiledCondition(utilities.NewByteReader(compiled[:i]), 0); err == nil {
+			t.Errorf("Expected an error decoding %v of %v bytes", i, len(compiled))
+		}
+	}
+
+	nested := Condition{Type: ConditionCompare, Operator: OpStrEQ, Var: "1", Value: true}
+	for i := 0; i < maxConditionDepth; i++ {
+		nested = Condition{Type: ConditionNot, Children: []Condition{nested}}
+	}
+	if _, err := readCompiledCondition(utilities.NewByteReader(nested.Compile()), 0); err == nil {
+		t.Error("Expected an error decoding a Condition nested too deeply")
+	}
+	if err := nested.Validate(); err == nil {
+		t.Error("Expected an error validating a Condition nested too deeply")
+	}
+
+	statements := [][]LStatement{{
+		{Condition: condition, Exec: "tree"},
+		{Operators: &OrGroup{AndGroup{OpStrEQ: VarValMap{2: "sword"}}}, Exec: "flat"},
+		{Exec: "else"},
+	}}
+	block := LBlock{AlwaysExec: "always", Statements: &statements}
+	decodedBlock := LBlock{}
+	if err := decodedBlock.CreateFrom(mustCompile(t, block)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decodedBlock, block) {
+		t.Errorf("Expected %+v, decoded %+v", *block.Statements, *decodedBlock.Statements)
+	}
+
+	dis, err := DisassembleLBlock(mustCompile(t, block))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `(($1 > 100 || $2 == "sword") && !(has_item("6ba7b820-9dad-11d1-80b4-00c04fd430c8"))) || $3 > $1`
+	if dis.Statements[0][0].Condition != expected {
+		t.Errorf("Expected the condition %v, received %v", expected, dis.Statements[0][0].Condition)
+	}
+}
+
+func TestConditionValidate(t *testing.T) {
+	if err := testCondition().Validate(); err != nil {
+		t.Error(err)
+	}
+
+	tests := map[string]Condition{
+		"type":     {Type: "xor"},
+		"not":      {Type: ConditionNot},
+		"operator": {Type: ConditionCompare, Operator: "like", Var: "1", Value: "a"},
+		"variable": {Type: ConditionCompare, Operator: OpStrEQ, Value: "a"},
+		"value":    {Type: ConditionCompare, Operator: OpStrEQ, Var: "1"},
+		"both":     {Type: ConditionCompare, Operator: OpStrEQ, Var: "1", Value: "a", OtherVar: "2"},
+		"between":  {Type: ConditionCompare, Operator: OpStrBetween, Var: "1", OtherVar: "2"},
+		"children": {Type: ConditionCompare, Operator: OpStrEQ, Var: "1", Value: "a", Children: []Condition{{Type: ConditionAnd}}},
+		"nested": {Type: ConditionAnd, Children: []Condition{
+			{Type: ConditionNot, Children: []Condition{{Type: ConditionOr}, {Type: ConditionOr}}},
+		}},
+	}
+	for name, condition := range tests {
+		if err := condition.Validate(); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+		condition := condition
+		block := LBlock{Statements: &[][]LStatement{{{Condition: &condition}}}}
+		if _, err := block.Compile(); err == nil {
+			t.Errorf("%v: expected an error compiling", name)
+		}
+	}
+
+	invalid := []string{
+		`{"Condition": {"Type": "not"}, "Exec": {}}`,
+		`{"Operators": [{"like": {"1": "a"}}], "Exec": {}}`,
+	}
+	for _, data := range invalid {
+		if err := json.Unmarshal([]byte(data), &RawLStatement{}); err == nil {
+			t.Errorf("Expected an error decoding %v", data)
+		}
+	}
+}
+
+func TestRawLStatementMigration(t *testing.T) {
+	legacy := []byte(`[[
+		{"Operators": [{"gt": {"1": 100}, "eq": {"2": "sword"}}, {"has_item": {"0": "key"}}], "Exec": {}},
+		{"Operators": null, "Exec": {}}
+	]]`)
+
+	statements := RawLStatementUnified{}
+	if err := statements.Scan(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &Condition{Type: ConditionOr, Children: []Condition{
+		{Type: ConditionAnd, Children: []Condition{
+			{Type: ConditionCompare, Operator: OpStrEQ, Var: "2", Value: "sword"},
+			{Type: ConditionCompare, Operator: OpStrGT, Var: "1", Value: float64(100)},
+		}},
+		{Type: ConditionAnd, Children: []Condition{
+			{Type: ConditionCompare, Operator: OpStrHasItem, Var: "0", Value: "key"},
+		}},
+	}}
+	if !reflect.DeepEqual(statements[0][0].Condition, expected) {
+		t.Errorf("Expected %+v, received %+v", expected, statements[0][0].Condition)
+	}
+	if statements[0][0].Operators != nil || statements[0][1].Operators != nil || statements[0][1].Condition != nil {
+		t.Errorf("Unexpected migration %+v", statements[0])
+	}
+
+	value, err := statements.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(value.([]byte)), "Operators") {
+		t.Errorf("Expected the Operators to be migrated, received %s", value)
+	}
+
+	// Migrated statements decode as they were stored
+	again := RawLStatementUnified{}
+	if err := json.Unmarshal(value.([]byte), &again); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, statements) {
+		t.Errorf("Expected %+v, received %+v", statements, again)
+	}
+
+	// The Condition yields the same result as the OrGroup it was migrated from
+	group := OrGroup{AndGroup{OpStrGT: VarValMap{1: int64(100)}, OpStrEQ: VarValMap{2: "sword"}}, AndGroup{OpStrLT: VarValMap{1: int64(10)}}}
+	for _, gold := range []int64{5, 50, 150} {
+		for _, weapon := range []string{"sword", "shield"} {
+			state := MutableAIRequestState{ARVariables: map[string]*ARVariable{
+				"1": {T: "int", Val: gold},
+				"2": {T: "string", Val: weapon},
+			}}
+			if group.Condition().Evaluate(&state) != group.EvaluateState(&state) {
+				t.Errorf("Expected the migrated Condition to agree with the OrGroup for %v and %v", gold, weapon)
+			}
+		}
+	}
+}
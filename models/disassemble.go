@@ -0,0 +1,327 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	utilities "github.com/talkative-ai/core"
+	"github.com/talkative-ai/core/redis"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// DisassembledBundle is a readable, JSON serializable action bundle
+type DisassembledBundle struct {
+	Legacy     bool
+	Version    uint8  `json:",omitempty"`
+	Count      int    `json:",omitempty"`
+	CRC        uint32 `json:",omitempty"`
+	Actions    []DisassembledAction
+	References []string `json:",omitempty"`
+	Error      string   `json:",omitempty"`
+}
+
+// DisassembledAction is a single decoded action within a DisassembledBundle
+type DisassembledAction struct {
+	ID     ActionID
+	Name   string `json:",omitempty"`
+	Offset uint64
+	Length uint32
+	Action interface{} `json:",omitempty"`
+	Error  string      `json:",omitempty"`
+}
+
+// DisassembledLBlock is a readable, JSON serializable LBlock
+type DisassembledLBlock struct {
+	AlwaysExec string `json:",omitempty"`
+	// Statements are the "if/elif/else" chains in the order they are evaluated
+	Statements [][]DisassembledStatement `json:",omitempty"`
+	References []string                  `json:",omitempty"`
+}
+
+// DisassembledStatement is a single branch of an "if/elif/else" chain
+// Condition is empty for an "else" branch
+type DisassembledStatement struct {
+	Condition string     `json:",omitempty"`
+	Operators *OrGroup   `json:",omitempty"`
+	Tree      *Condition `json:",omitempty"`
+	Exec      string     `json:",omitempty"`
+}
+
+// DisassembledEntry is a single compiled key dumped by DisassembleNamespace
+// Exactly one of the values is set, depending on the type of the key
+type DisassembledEntry struct {
+	Key    string
+	Type   string
+	Bundle *DisassembledBundle            `json:",omitempty"`
+	LBlock *DisassembledLBlock            `json:",omitempty"`
+	LHash  map[string]*DisassembledLBlock `json:",omitempty"`
+	String *string                        `json:",omitempty"`
+	Hash   map[string]string              `json:",omitempty"`
+	Set    []string                       `json:",omitempty"`
+	Error  string                         `json:",omitempty"`
+}
+
+var operatorSymbols = map[OperatorStr]string{
+	OpStrEQ: "==",
+	OpStrNE: "!=",
+	OpStrLT: "<",
+	OpStrGT: ">",
+	OpStrLE: "<=",
+	OpStrGE: ">=",
+
+	OpStrContains:     "contains",
+	OpStrStartsWith:   "starts_with",
+	OpStrContainsText: "contains_text",
+}
+
+// DisassembleBundle decodes a versioned or legacy action bundle
+// Decoding continues past actions which fail to decode
+// An error is only returned if the bundle framing itself is invalid
+func DisassembleBundle(bundle []byte) (DisassembledBundle, error) {
+	result := DisassembledBundle{Actions: []DisassembledAction{}}
+
+	header, stream, err := DecodeActionBundleHeader(bundle)
+	if err != nil {
+		result.Error = err.Error()
+		return result, err
+	}
+	result.Legacy = header.Legacy
+	if !header.Legacy {
+		result.Version, result.Count, result.CRC = header.Version, header.Count, header.CRC
+	}
+
+	base := uint64(len(bundle) - len(stream))
+	r := utilities.NewByteReader(stream)
+	for !r.Finished() {
+		dis := DisassembledAction{Offset: base + r.Position}
+		barr, err := r.ReadNBytes(12)
+		if err != nil {
+			err = fmt.Errorf("Truncated action at byte %v", dis.Offset)
+			result.Error = err.Error()
+			return result, err
+		}
+		dis.ID = ActionID(binary.LittleEndian.Uint64(barr))
+		dis.Length = binary.LittleEndian.Uint32(barr[8:])
+		actionBytes, err := r.ReadNBytes(uint64(dis.Length))
+		if err != nil {
+			err = fmt.Errorf("Truncated action at byte %v", dis.Offset)
+			result.Error = err.Error()
+			return result, err
+		}
+
+		action, err := GetActionFromID(dis.ID)
+		if err == nil {
+			dis.Name = reflect.TypeOf(action).Elem().Name()
+			err = action.CreateFrom(actionBytes)
+		}
+		if err != nil {
+			dis.Error = err.Error()
+		} else {
+			dis.Action = disassembleAction(action)
+			if ara, ok := action.(*RASetVariable); ok && ara.With.Key != nil {
+				result.References = append(result.References, *ara.With.Key)
+			}
+			if ara, ok := action.(*RASchedule); ok && ara.BundleKey != "" {
+				result.References = append(result.References, ara.BundleKey)
+			}
+		}
+		result.Actions = append(result.Actions, dis)
+	}
+
+	if !header.Legacy && len(result.Actions) != header.Count {
+		err = fmt.Errorf("Expected %v actions, found %v", header.Count, len(result.Actions))
+		result.Error = err.Error()
+		return result, err
+	}
+
+	return result, nil
+}
+
+// disassembleAction returns a JSON friendly representation of the action
+// Values which would otherwise serialize as raw bytes or structs are converted to strings
+func disassembleAction(action RequestAction) interface{} {
+	switch ara := action.(type) {
+	case *RASetZone:
+		return map[string]string{"Zone": uuid.UUID(*ara).String()}
+	case *RAInitializeActorDialog:
+		return map[string]string{"Actor": uuid.UUID(*ara).String()}
+	case *RAPlaySound:
+		if u, ok := ara.Val.(*url.URL); ok {
+			return RAPlaySound{SoundType: ara.SoundType, Val: u.String()}
+		}
+	case *RAChooseSound:
+		choice := *ara
+		choice.Variants = make([]RAPlaySound, len(ara.Variants))
+		for i, variant := range ara.Variants {
+			choice.Variants[i] = variant
+			if u, ok := variant.Val.(*url.URL); ok {
+				choice.Variants[i].Val = u.String()
+			}
+		}
+		return choice
+	}
+	return action
+}
+
+// DisassembleLBlock decodes a compiled LBlock
+func DisassembleLBlock(compiled []byte) (DisassembledLBlock, error) {
+	block := LBlock{}
+	if err := block.CreateFrom(compiled); err != nil {
+		return DisassembledLBlock{}, err
+	}
+
+	result := DisassembledLBlock{AlwaysExec: block.AlwaysExec}
+	references := map[string]bool{}
+	if block.AlwaysExec != "" {
+		references[block.AlwaysExec] = true
+	}
+	if block.Statements != nil {
+		for _, stmts := range *block.Statements {
+			chain := make([]DisassembledStatement, len(stmts))
+			for i, stmt := range stmts {
+				chain[i] = DisassembledStatement{
+					Condition: describeOrGroup(stmt.Operators),
+					Operators: stmt.Operators,
+					Tree:      stmt.Condition,
+					Exec:      stmt.Exec,
+				}
+				if stmt.Condition != nil {
+					chain[i].Condition = describeCondition(stmt.Condition)
+				}
+				if stmt.Exec != "" {
+					references[stmt.Exec] = true
+				}
+			}
+			result.Statements = append(result.Statements, chain)
+		}
+	}
+	for key := range references {
+		result.References = append(result.References, key)
+	}
+	sort.Strings(result.References)
+
+	return result, nil
+}
+
+// describeOrGroup renders the conditions as a readable expression
+// e.g. `$1 > 100 && $2 == "sword" || $3 == true`
+func describeOrGroup(group *OrGroup) string {
+	if group == nil || len(*group) == 0 {
+		return ""
+	}
+	ors := []string{}
+	for _, and := range *group {
+		ands := []string{}
+		for op, vars := range and {
+			for id, val := range vars {
+				ands = append(ands, describeComparison(op, strconv.Itoa(id), val))
+			}
+		}
+		sort.Strings(ands)
+		ors = append(ors, strings.Join(ands, " && "))
+	}
+	return strings.Join(ors, " || ")
+}
+
+// describeComparison renders a single comparison of the variable to the value
+func describeComparison(op OperatorStr, id string, val interface{}) string {
+	switch op {
+	case OpStrHasItem, OpStrZoneVisited, OpStrActorPresent:
+		return fmt.Sprintf("%v(%#v)", op, val)
+	case OpStrExists:
+		if val == false {
+			return fmt.Sprintf("!exists($%v)", id)
+		}
+		return fmt.Sprintf("exists($%v)", id)
+	case OpStrBetween:
+		if bounds, ok := val.([]interface{}); ok && len(bounds) == 2 {
+			return fmt.Sprintf("$%v between %#v and %#v", id, bounds[0], bounds[1])
+		}
+	}
+	return fmt.Sprintf("$%v %v %#v", id, operatorSymbols[op], val)
+}
+
+var (
+	compiledDialogNodeKeyPattern = regexp.MustCompile(fmt.Sprintf(`^%v:[^:]+:e:%v:[^:]+$`,
+		compiledNamespaceV2, AEIDDialogNode))
+	compiledTriggersWithinZonePattern = regexp.MustCompile(fmt.Sprintf(`^%v:[^:]+:e:%v:[^:]+:e:%v$`,
+		compiledNamespaceV2, AEIDZone, AEIDTrigger))
+)
+
+// DisassembleNamespace dumps every key within the KeynavCompiledEntity namespace of a published project
+// Action bundles, dialog node logic and zone triggers are disassembled. Other keys are dumped as is
+// Entries are sorted by key
+func DisassembleNamespace(store redis.ScanStore, pubID string) ([]DisassembledEntry, error) {
+	keys, err := store.Keys(KeynavCompiledEntities(pubID))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	entries := []DisassembledEntry{}
+	for _, key := range keys {
+		entry := DisassembledEntry{Key: key}
+		entry.Type, err = store.Type(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := disassembleEntry(store, &entry); err != nil {
+			entry.Error = err.Error()
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func disassembleEntry(store redis.ScanStore, entry *DisassembledEntry) error {
+	switch entry.Type {
+	case "string":
+		val, err := store.Get(entry.Key)
+		if err != nil {
+			return err
+		}
+		switch {
+		case actionBundleKeyPattern.MatchString(entry.Key):
+			bundle, err := DisassembleBundle(val)
+			entry.Bundle = &bundle
+			return err
+		case compiledDialogNodeKeyPattern.MatchString(entry.Key):
+			block, err := DisassembleLBlock(val)
+			entry.LBlock = &block
+			return err
+		}
+		str := string(val)
+		entry.String = &str
+	case "hash":
+		hash, err := store.HGetAll(entry.Key)
+		if err != nil {
+			return err
+		}
+		if !compiledTriggersWithinZonePattern.MatchString(entry.Key) {
+			entry.Hash = hash
+			return nil
+		}
+		entry.LHash = map[string]*DisassembledLBlock{}
+		for field, val := range hash {
+			block, err := DisassembleLBlock([]byte(val))
+			if err != nil {
+				return fmt.Errorf("Field %v: %s", field, err.Error())
+			}
+			entry.LHash[field] = &block
+		}
+	case "set":
+		members, err := store.SMembers(entry.Key)
+		if err != nil {
+			return err
+		}
+		entry.Set = members
+	}
+	return nil
+}
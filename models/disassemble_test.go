@@ -0,0 +1,152 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/talkative-ai/core/redis"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+func TestDisassembleBundle(t *testing.T) {
+	zoneID := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	setZone := RASetZone(zoneID)
+	audio, _ := url.Parse("https://example.com/chime.wav")
+	key := "c:v2:1:e:5:gold"
+	bundle := CompileActionBundle(
+		&RAPlaySound{SoundType: RAPlaySoundTypeAudio, Val: audio},
+		&setZone,
+		&RASetVariable{Target: "1", Operation: SVOSet, With: ParametizedARVariable{Key: &key}},
+	)
+	// An unregistered action is reported without aborting the disassembly
+	unknown := append([]byte{0, 0, 0, 0, 0, 0, 4, 0, 1, 0, 0, 0, 42}, legacyBundleActions(&setZone)...)
+
+	dis, err := DisassembleBundle(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dis.Legacy || dis.Count != 3 || len(dis.Actions) != 3 {
+		t.Fatalf("Unexpected disassembly %+v", dis)
+	}
+	names := []string{}
+	for _, action := range dis.Actions {
+		names = append(names, action.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"RAPlaySound", "RASetZone", "RASetVariable"}) {
+		t.Errorf("Unexpected action names %v", names)
+	}
+	if dis.Actions[0].Offset != ActionBundleHeaderSize {
+		t.Errorf("Expected the first action at byte %v, received %v", ActionBundleHeaderSize, dis.Actions[0].Offset)
+	}
+	if !reflect.DeepEqual(dis.References, []string{key}) {
+		t.Errorf("Unexpected references %v", dis.References)
+	}
+
+	encoded, err := json.Marshal(dis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := struct {
+		Actions []struct{ Action map[string]interface{} }
+	}{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if val := decoded.Actions[0].Action["Val"]; val != audio.String() {
+		t.Errorf("Expected the audio URL as a string, received %v", val)
+	}
+	if zone := decoded.Actions[1].Action["Zone"]; zone != zoneID.String() {
+		t.Errorf("Expected the zone as a string, received %v", zone)
+	}
+
+	dis, err = DisassembleBundle(unknown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dis.Legacy || len(dis.Actions) != 2 || dis.Actions[0].Error == "" || dis.Actions[1].Error != "" {
+		t.Errorf("Unexpected disassembly %+v", dis)
+	}
+
+	if _, err := DisassembleBundle(bundle[:len(bundle)-1]); err == nil {
+		t.Error("Expected an error disassembling a truncated bundle")
+	}
+}
+
+func TestDisassembleNamespace(t *testing.T) {
+	store := redis.NewMemoryStore()
+
+	pubID := "1"
+	zoneID := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	actorID := "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+
+	storeDialogNode(t, store, pubID, "greet", "Hello")
+	richKey := KeynavCompiledDialogNodeActionBundle(pubID, "greet", 1)
+	poorKey := KeynavCompiledDialogNodeActionBundle(pubID, "greet", 2)
+	statements := [][]LStatement{{
+		{Exec: richKey, Operators: &OrGroup{AndGroup{OpStrGT: VarValMap{1: int64(100)}}}},
+		{Exec: poorKey},
+	}}
+	store.Set(KeynavCompiledEntity(pubID, AEIDDialogNode, "greet"), mustCompile(t, LBlock{
+		AlwaysExec: KeynavCompiledDialogNodeActionBundle(pubID, "greet", 0),
+		Statements: &statements,
+	}))
+	store.Set(KeynavCompiledDialogRootUnknownWithinActor(pubID, actorID), []byte("greet"))
+	store.HSet(KeynavCompiledDialogRootWithinActor(pubID, actorID), "hello", []byte("greet"))
+	store.HSet(KeynavCompiledTriggersWithinZone(pubID, zoneID),
+		fmt.Sprintf("%v", TriggerEnterZone), mustCompile(t, LBlock{AlwaysExec: richKey}))
+	store.SAdd(KeynavCompiledActorsWithinZone(pubID, zoneID), actorID)
+	// Another project is not dumped
+	storeDialogNode(t, store, "2", "greet", "Hello")
+
+	entries, err := DisassembleNamespace(store, pubID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey := map[string]DisassembledEntry{}
+	for _, entry := range entries {
+		if entry.Error != "" {
+			t.Errorf("%v: %v", entry.Key, entry.Error)
+		}
+		byKey[entry.Key] = entry
+	}
+	if len(entries) != 6 {
+		t.Errorf("Expected 6 entries, received %v", len(entries))
+	}
+
+	node := byKey[KeynavCompiledEntity(pubID, AEIDDialogNode, "greet")].LBlock
+	if node == nil || len(node.Statements) != 1 || len(node.References) != 3 {
+		t.Fatalf("Unexpected dialog node %+v", node)
+	}
+	if cond := node.Statements[0][0].Condition; cond != "$1 > 100" {
+		t.Errorf("Unexpected condition %q", cond)
+	}
+	if cond := node.Statements[0][1].Condition; cond != "" {
+		t.Errorf("Expected an else branch, received %q", cond)
+	}
+
+	bundle := byKey[KeynavCompiledDialogNodeActionBundle(pubID, "greet", 0)].Bundle
+	if bundle == nil || len(bundle.Actions) != 1 || bundle.Actions[0].Name != "RAPlaySound" {
+		t.Errorf("Unexpected bundle %+v", bundle)
+	}
+
+	unknown := byKey[KeynavCompiledDialogRootUnknownWithinActor(pubID, actorID)]
+	if unknown.String == nil || *unknown.String != "greet" {
+		t.Errorf("Unexpected unknown handler %+v", unknown)
+	}
+
+	triggers := byKey[KeynavCompiledTriggersWithinZone(pubID, zoneID)].LHash
+	if trigger := triggers[fmt.Sprintf("%v", TriggerEnterZone)]; trigger == nil || trigger.AlwaysExec != richKey {
+		t.Errorf("Unexpected triggers %+v", triggers)
+	}
+
+	if actors := byKey[KeynavCompiledActorsWithinZone(pubID, zoneID)].Set; !reflect.DeepEqual(actors, []string{actorID}) {
+		t.Errorf("Unexpected actors %v", actors)
+	}
+
+	if _, err := json.Marshal(entries); err != nil {
+		t.Error(err)
+	}
+}
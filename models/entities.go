@@ -97,6 +97,8 @@ type Project struct {
 	ZoneActors           []ZoneActor            `db:"-"`
 	PrivateProjectGrants []PrivateProjectGrants `db:"-"`
 	Notes                []Note                 `db:"-"`
+	Variables            []ProjectVariable      `db:"-"`
+	Items                []Item                 `db:"-"`
 }
 
 type PublishedProject struct {
@@ -115,6 +117,8 @@ func (p Project) PrepareMarshal() map[string]interface{} {
 		"ZoneActors": p.ZoneActors,
 		"Category":   p.Category,
 		"Tags":       p.Tags,
+		"Variables":  p.Variables,
+		"Items":      p.Items,
 	}
 
 	if p.StartZoneID.Valid {
@@ -147,6 +151,10 @@ const (
 	AEIDDialogNode
 	// AEIDActionBundle EntityID for ActionBundle
 	AEIDActionBundle
+	// AEIDItem EntityID for Item
+	AEIDItem
+	// AEIDProject EntityID for Project
+	AEIDProject
 )
 
 // DialogNode is a single instance of a Dialog
@@ -237,6 +245,7 @@ const (
 	// DialogInputQuestionPossessional Possessional question
 	// (Example: “Do you have <Actor>?”)
 	// Provides an Actor
+	// Unless a dialog handles it, RunTurn answers "Do I have <Item>?" from the inventory
 	DialogInputQuestionPossessional DialogInput = "question_possessional"
 )
 
@@ -281,6 +290,7 @@ type Actor struct {
 
 	Title           string
 	ProjectID       uuid.UUID        `json:"-"`
+	Voice           *string          `json:",omitempty"`
 	Dialogs         []DialogNode     `json:",omitempty" db:"-"`
 	DialogRelations []DialogRelation `json:",omitempty" db:"-"`
 }
@@ -324,6 +334,29 @@ type Trigger struct {
 	PatchAction *PatchAction `json:",omitempty" db:"-"`
 }
 
+// ProjectVariable model for the variables declared by a project
+// Initial is the value of the variable when the app is started or reset
+type ProjectVariable struct {
+	Model
+
+	ProjectID uuid.UUID `json:"-"`
+	Name      string
+	Initial   ARVariable
+	// LegacyID is the numeric ID by which conditions created before variables were named
+	// refer to the variable, e.g. Var "7". It is 0 for variables created since
+	LegacyID int `json:",omitempty"`
+}
+
+// Item model for the Item entities
+// Items are held within the inventory of the runtime state
+type Item struct {
+	Model
+
+	ProjectID   uuid.UUID `json:"-"`
+	Title       string
+	Description string
+}
+
 // Note model for the Note entities
 type Note struct {
 	Model
@@ -0,0 +1,152 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// Seeds for the fuzz tests beyond these are in testdata/fuzz
+
+func fuzzBundleSeeds() [][]byte {
+	audio, _ := url.Parse("https://example.com/chime.wav")
+	zone := RASetZone(uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8"))
+	actor := RAInitializeActorDialog(uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8"))
+	reset := RAResetApp(true)
+	key := "c:v2:1:e:5:gold"
+	actions := []RequestAction{
+		&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "You have {{gold|no}} gold"},
+		&RAPlaySound{SoundType: RAPlaySoundTypeAudio, Val: audio},
+		&RAPlaySound{SoundType: RAPlaySoundTypeProsody, Val: SSMLProsody{Text: "Slowly", Rate: "slow"}},
+		&RASetVariable{Target: "gold", Operation: SVOAdd, With: ParametizedARVariable{
+			ARVariable: &ARVariable{T: "int", Val: int64(5)},
+		}},
+		&RASetVariable{Target: "items", Operation: SVOSet, With: ParametizedARVariable{
+			ARVariable: &ARVariable{T: "array", Val: []ARVariable{{T: "string", Val: "sword"}}},
+		}},
+		&RASetVariable{Target: "gold", Operation: SVOSet, With: ParametizedARVariable{Key: &key}},
+		&zone,
+		&actor,
+		&reset,
+		&RAChooseSound{Key: "greeting", Mode: ChooseWeighted, Variants: []RAPlaySound{
+			{SoundType: RAPlaySoundTypeText, Val: "Hello"},
+			{SoundType: RAPlaySoundTypeAudio, Val: audio},
+		}, Weights: []uint32{1, 2}},
+		&RASchedule{Name: "prompt", BundleKey: "c:v2:1:e:4:prompt", Turns: 3},
+		&RAGiveItem{Item: uuid.FromStringOrNil("6ba7b820-9dad-11d1-80b4-00c04fd430c8"), Quantity: 2},
+		&RATakeItem{Item: uuid.FromStringOrNil("6ba7b820-9dad-11d1-80b4-00c04fd430c8")},
+	}
+	seeds := [][]byte{{}}
+	for _, action := range actions {
+		seeds = append(seeds, CompileActionBundle(action), legacyBundleActions(action))
+	}
+	return append(seeds, CompileActionBundle(actions...))
+}
+
+func fuzzLBlockSeeds(t testing.TB) [][]byte {
+	statements := [][]LStatement{{
+		{Exec: "rich", Operators: &OrGroup{AndGroup{OpStrGT: VarValMap{1: int64(100)}}}},
+		{Exec: "named", Operators: &OrGroup{
+			AndGroup{OpStrEQ: VarValMap{2: "Arjuna"}, OpStrNE: VarValMap{3: true}},
+			AndGroup{OpStrLE: VarValMap{4: 1.5}},
+		}},
+		{Exec: "ranged", Operators: &OrGroup{
+			AndGroup{OpStrBetween: VarValMap{1: []interface{}{int64(100), int64(200)}}, OpStrExists: VarValMap{5: false}},
+			AndGroup{OpStrStartsWith: VarValMap{2: "arj"}, OpStrActorPresent: VarValMap{0: "actor"}},
+		}},
+		{Exec: "tree", Condition: &Condition{Type: ConditionAnd, Children: []Condition{
+			{Type: ConditionNot, Children: []Condition{{Type: ConditionCompare, Operator: OpStrEQ, Var: "1", OtherVar: "gold"}}},
+			{Type: ConditionCompare, Operator: OpStrContains, Var: "2", Value: "sword"},
+		}}},
+		{Exec: "poor"},
+	}}
+	return [][]byte{
+		{},
+		mustCompile(t, LBlock{AlwaysExec: "always"}),
+		mustCompile(t, LBlock{AlwaysExec: "always", Statements: &statements}),
+		mustCompile(t, LBlock{Statements: &statements}),
+	}
+}
+
+// fuzzRequest returns an AIRequest evaluated against an empty MemoryStore
+func fuzzRequest() *AIRequest {
+	return &AIRequest{
+		State: MutableAIRequestState{
+			PubID: "1",
+			ARVariables: map[string]*ARVariable{
+				"1":    {T: "int", Val: int64(150)},
+				"gold": {T: "int", Val: int64(10)},
+			},
+		},
+		OutputSSML: ssml.NewBuilder(),
+	}
+}
+
+func FuzzActionBundleEval(f *testing.F) {
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = redis.NewMemoryStore()
+
+	for _, seed := range fuzzBundleSeeds() {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, bundle []byte) {
+		err := ActionBundleEval(fuzzRequest(), bundle)
+		if _, ok := err.(*RuntimeError); err != nil && !ok {
+			t.Errorf("Expected a *RuntimeError, received %T %v", err, err)
+		}
+	})
+}
+
+func FuzzLogicLazyEval(f *testing.F) {
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = redis.NewMemoryStore()
+
+	for _, seed := range fuzzLBlockSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, compiled []byte) {
+		err := evalLogicBlock(fuzzRequest(), "", compiled)
+		if _, ok := err.(*RuntimeError); err != nil && !ok {
+			t.Errorf("Expected a *RuntimeError, received %T %v", err, err)
+		}
+
+		// LogicLazyEval must yield the same keys and error as LogicEval
+		expected := []string{}
+		expectedErr := LogicEval(fuzzRequest(), compiled, func(key string) error {
+			expected = append(expected, key)
+			return nil
+		})
+
+		stateComms := make(chan AIRequest)
+		results := LogicLazyEval(stateComms, compiled)
+		received := []string{}
+		var receivedErr error
+		for done := false; !done; {
+			select {
+			case res, ok := <-results:
+				if !ok {
+					done = true
+					break
+				}
+				if res.Error != nil {
+					receivedErr = res.Error
+					continue
+				}
+				received = append(received, res.Value)
+			case stateComms <- *fuzzRequest():
+			}
+		}
+
+		if !reflect.DeepEqual(received, expected) {
+			t.Errorf("Unexpected keys from LogicLazyEval\nExpected: %v\nReceived: %v", expected, received)
+		}
+		if fmt.Sprint(receivedErr) != fmt.Sprint(expectedErr) {
+			t.Errorf("Unexpected error from LogicLazyEval\nExpected: %v\nReceived: %v", expectedErr, receivedErr)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	utilities "github.com/talkative-ai/core"
+	"github.com/talkative-ai/core/redis"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// possessionalPrefixes are the prepared beginnings of a possessional question about the inventory
+var possessionalPrefixes = []string{"do i have ", "do you have ", "have i got ", "have you got "}
+
+// itemArticles are stripped from the beginning of item titles
+var itemArticles = []string{"a ", "an ", "the ", "any ", "some ", "my "}
+
+// trimArticle removes a leading article from the title
+func trimArticle(title string) string {
+	lower := strings.ToLower(title)
+	for _, article := range itemArticles {
+		if strings.HasPrefix(lower, article) {
+			return strings.TrimSpace(title[len(article):])
+		}
+	}
+	return title
+}
+
+// PrepareItemTitle normalizes an item title for matching against possessional questions
+// e.g. "The Rusty Key" and "rusty key" are both prepared as "rusty key"
+func PrepareItemTitle(title string) string {
+	return trimArticle(PrepareTurnInput(title))
+}
+
+// CompileItems is used by Lakshmi to compile the items of a project
+// into the hash at KeynavCompiledItems, mapping each prepared title to its compiled item
+//
+// The layout of a compiled item is as follows:
+// [2 bytes: ID length][ID][2 bytes: Title length][Title]
+func CompileItems(items []Item) (map[string][]byte, error) {
+	compiled := map[string][]byte{}
+	for _, item := range items {
+		field := PrepareItemTitle(item.Title)
+		if field == "" {
+			return nil, fmt.Errorf("Missing title on item %v", item.ID)
+		}
+		if _, dup := compiled[field]; dup {
+			return nil, fmt.Errorf("Duplicate item title %q", item.Title)
+		}
+		compiled[field] = appendCompiledString(appendCompiledString([]byte{}, item.ID.String()), item.Title)
+	}
+	return compiled, nil
+}
+
+// readCompiledItem reads an item compiled by CompileItems
+func readCompiledItem(compiled []byte) (id string, title string, err error) {
+	r := utilities.NewByteReader(compiled)
+	if id, err = r.LengthPrefixedString(); err != nil {
+		return "", "", fmt.Errorf("Error reading item ID: %s", err.Error())
+	}
+	if title, err = r.LengthPrefixedString(); err != nil {
+		return "", "", fmt.Errorf("Error reading item title: %s", err.Error())
+	}
+	return id, title, nil
+}
+
+// answerPossessional answers a possessional question such as "Do I have the key?" from the inventory
+// The prepared input is not a possessional question if it does not name an item of the project,
+// in which case itemID is empty and nothing is output
+func answerPossessional(message *AIRequest, input string) (itemID string, err error) {
+	subject := ""
+	for _, prefix := range possessionalPrefixes {
+		if strings.HasPrefix(input, prefix) {
+			subject = trimArticle(strings.TrimPrefix(input, prefix))
+			break
+		}
+	}
+	if subject == "" {
+		return "", nil
+	}
+
+	compiled, err := redis.Runtime.HGet(KeynavCompiledItems(message.State.PubID), subject)
+	if err != nil {
+		return "", newRuntimeError(RuntimeErrorStore, "Error fetching items: %s", err.Error())
+	}
+	if compiled == "" {
+		return "", nil
+	}
+	itemID, title, err := readCompiledItem([]byte(compiled))
+	if err != nil {
+		return "", newRuntimeError(RuntimeErrorMalformed, "%s", err.Error())
+	}
+
+	title = trimArticle(title)
+	if message.State.Inventory[itemID] > 0 {
+		message.OutputSSML = message.OutputSSML.Paragraph(fmt.Sprintf("Yes, you have the %v.", title))
+	} else {
+		message.OutputSSML = message.OutputSSML.Paragraph(fmt.Sprintf("No, you do not have the %v.", title))
+	}
+	return itemID, nil
+}
+
+// compileItemAction compiles the item and quantity of RAGiveItem and RATakeItem
+//
+// The layout is as follows:
+// [16 bytes: item ID][4 bytes: Quantity]
+func compileItemAction(item uuid.UUID, quantity uint32) []byte {
+	compiled := append([]byte{}, item.Bytes()...)
+	quantityBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(quantityBytes, quantity)
+	return append(compiled, quantityBytes...)
+}
+
+// readItemAction reads the item and quantity compiled by compileItemAction
+func readItemAction(bytes []byte) (uuid.UUID, uint32, error) {
+	r := utilities.NewByteReader(bytes)
+	idBytes, err := r.ReadNBytes(uuid.Size)
+	if err != nil {
+		return uuid.Nil, 0, fmt.Errorf("Error reading item ID: %s", err.Error())
+	}
+	item, err := uuid.FromBytes(idBytes)
+	if err != nil {
+		return uuid.Nil, 0, err
+	}
+	quantity, err := r.Uint32()
+	if err != nil {
+		return uuid.Nil, 0, fmt.Errorf("Error reading item quantity: %s", err.Error())
+	}
+	return item, quantity, nil
+}
+
+// itemQuantity returns the quantity of an RAGiveItem or RATakeItem
+// A Quantity of 0 is a single item
+func itemQuantity(quantity uint32) uint32 {
+	if quantity == 0 {
+		return 1
+	}
+	return quantity
+}
+
+/////////////////
+// RAGiveItem //
+/////////////////
+
+// RAGiveItem adds Quantity of the Item to the inventory
+type RAGiveItem struct {
+	Item     uuid.UUID
+	Quantity uint32 `json:",omitempty"`
+}
+
+// GetRAID returns the ActionID of the current RequestAction
+func (ara *RAGiveItem) GetRAID() ActionID {
+	return RAIDGiveItem
+}
+
+// Compile is used by Lakshmi
+// Returns the compiled []byte slice of the runtime action
+// To be stored in Redis
+func (ara RAGiveItem) Compile() []byte {
+	return compileItemAction(ara.Item, ara.Quantity)
+}
+
+// CreateFrom is used for evaluating the actions in Brahman and followed by Execute
+// This could be put in a single "Execute" but this is less monolothic
+func (ara *RAGiveItem) CreateFrom(bytes []byte) (err error) {
+	ara.Item, ara.Quantity, err = readItemAction(bytes)
+	return err
+}
+
+// Execute will mutate the AIRequest in some way
+// Whether it's the state itself or the OutputSSML
+func (ara *RAGiveItem) Execute(message *AIRequest) error {
+	if ara.Item == uuid.Nil {
+		return newRuntimeError(RuntimeErrorInvalidParam, "Missing item")
+	}
+	if message.State.Inventory == nil {
+		message.State.Inventory = map[string]uint32{}
+	}
+	message.State.Inventory[ara.Item.String()] += itemQuantity(ara.Quantity)
+	return nil
+}
+
+/////////////////
+// RATakeItem //
+/////////////////
+
+// RATakeItem removes up to Quantity of the Item from the inventory
+type RATakeItem struct {
+	Item     uuid.UUID
+	Quantity uint32 `json:",omitempty"`
+}
+
+// GetRAID returns the ActionID of the current RequestAction
+func (ara *RATakeItem) GetRAID() ActionID {
+	return RAIDTakeItem
+}
+
+// Compile is used by Lakshmi
+// Returns the compiled []byte slice of the runtime action
+// To be stored in Redis
+func (ara RATakeItem) Compile() []byte {
+	return compileItemAction(ara.Item, ara.Quantity)
+}
+
+// CreateFrom is used for evaluating the actions in Brahman and followed by Execute
+// This could be put in a single "Execute" but this is less monolothic
+func (ara *RATakeItem) CreateFrom(bytes []byte) (err error) {
+	ara.Item, ara.Quantity, err = readItemAction(bytes)
+	return err
+}
+
+// Execute will mutate the AIRequest in some way
+// Whether it's the state itself or the OutputSSML
+func (ara *RATakeItem) Execute(message *AIRequest) error {
+	if ara.Item == uuid.Nil {
+		return newRuntimeError(RuntimeErrorInvalidParam, "Missing item")
+	}
+	id := ara.Item.String()
+	quantity := itemQuantity(ara.Quantity)
+	if message.State.Inventory[id] <= quantity {
+		delete(message.State.Inventory, id)
+		return nil
+	}
+	message.State.Inventory[id] -= quantity
+	return nil
+}
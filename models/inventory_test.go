@@ -0,0 +1,201 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+var (
+	testItemKey     = uuid.FromStringOrNil("6ba7b820-9dad-11d1-80b4-00c04fd430c8")
+	testItemLantern = uuid.FromStringOrNil("6ba7b821-9dad-11d1-80b4-00c04fd430c8")
+)
+
+func TestItemActionsCompile(t *testing.T) {
+	give := RAGiveItem{Item: testItemKey, Quantity: 3}
+	decodedGive := RAGiveItem{}
+	if err := decodedGive.CreateFrom(give.Compile()); err != nil {
+		t.Fatal(err)
+	}
+	if decodedGive != give {
+		t.Errorf("Expected %+v, received %+v", give, decodedGive)
+	}
+
+	take := RATakeItem{Item: testItemLantern}
+	decodedTake := RATakeItem{}
+	if err := decodedTake.CreateFrom(take.Compile()); err != nil {
+		t.Fatal(err)
+	}
+	if decodedTake != take {
+		t.Errorf("Expected %+v, received %+v", take, decodedTake)
+	}
+
+	if err := new(RAGiveItem).CreateFrom(give.Compile()[:19]); err == nil {
+		t.Error("Expected an error decoding a truncated RAGiveItem")
+	}
+}
+
+func TestItemActionsExecute(t *testing.T) {
+	message := AIRequest{}
+	key := testItemKey.String()
+
+	steps := []struct {
+		action   RequestAction
+		expected uint32
+	}{
+		{&RAGiveItem{Item: testItemKey}, 1},
+		{&RAGiveItem{Item: testItemKey, Quantity: 4}, 5},
+		{&RATakeItem{Item: testItemKey, Quantity: 2}, 3},
+		{&RATakeItem{Item: testItemKey}, 2},
+		// Taking more than is held empties the inventory
+		{&RATakeItem{Item: testItemKey, Quantity: 10}, 0},
+		{&RATakeItem{Item: testItemKey}, 0},
+	}
+	for i, step := range steps {
+		if err := step.action.Execute(&message); err != nil {
+			t.Fatal(err)
+		}
+		if message.State.Inventory[key] != step.expected {
+			t.Errorf("Step %v: expected %v, received %v", i, step.expected, message.State.Inventory[key])
+		}
+	}
+	if _, ok := message.State.Inventory[key]; ok {
+		t.Error("Expected an emptied item to be removed from the inventory")
+	}
+
+	err := (&RAGiveItem{}).Execute(&message)
+	if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorInvalidParam {
+		t.Errorf("Expected a RuntimeErrorInvalidParam, received %v", err)
+	}
+}
+
+func TestHasItemOperator(t *testing.T) {
+	group := OrGroup{AndGroup{
+		OpStrHasItem: VarValMap{0: testItemKey.String(), 1: testItemLantern.String()},
+		OpStrGT:      VarValMap{1: int64(10)},
+	}}
+	compiled := mustCompile(t, LBlock{Statements: &[][]LStatement{{{Operators: &group, Exec: "yes"}}}})
+
+	tests := []struct {
+		inventory map[string]uint32
+		gold      int64
+		result    bool
+	}{
+		{nil, 50, false},
+		{map[string]uint32{testItemKey.String(): 1}, 50, false},
+		{map[string]uint32{testItemKey.String(): 1, testItemLantern.String(): 2}, 50, true},
+		{map[string]uint32{testItemKey.String(): 1, testItemLantern.String(): 2}, 5, false},
+	}
+	for i, test := range tests {
+		state := MutableAIRequestState{
+			Inventory:   test.inventory,
+			ARVariables: map[string]*ARVariable{"1": {T: "int", Val: test.gold}},
+		}
+		if group.EvaluateState(&state) != test.result {
+			t.Errorf("Case %v: expected %v", i, test.result)
+		}
+
+		executed := false
+		message := AIRequest{State: state}
+		err := LogicEval(&message, compiled, func(key string) error {
+			executed = executed || key == "yes"
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if executed != test.result {
+			t.Errorf("Case %v: expected the compiled condition to yield %v", i, test.result)
+		}
+	}
+
+	if condition := describeOrGroup(&OrGroup{AndGroup{OpStrHasItem: VarValMap{0: "key"}}}); condition != `has_item("key")` {
+		t.Errorf("Unexpected condition %v", condition)
+	}
+}
+
+func TestRunTurnPossessional(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	pubID := "1"
+	zoneID := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	actorID := "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+
+	items, err := CompileItems([]Item{
+		{Model: Model{ID: testItemKey}, Title: "The Rusty Key"},
+		{Model: Model{ID: testItemLantern}, Title: "Lantern"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, compiled := range items {
+		store.HSet(KeynavCompiledItems(pubID), field, compiled)
+	}
+
+	storeDialogNode(t, store, pubID, "shrug", "The shopkeeper shrugs.")
+	store.Set(KeynavCompiledDialogRootUnknownWithinActor(pubID, actorID), []byte("shrug"))
+	// A dialog handles the question before the inventory does
+	storeDialogNode(t, store, pubID, "lantern", "The shopkeeper points at your lantern.")
+	store.HSet(KeynavCompiledDialogRootWithinActor(pubID, actorID), "do you have a lantern", []byte("lantern"))
+
+	state := MutableAIRequestState{
+		PubID:      pubID,
+		Zone:       zoneID,
+		ZoneActors: map[uuid.UUID][]string{zoneID: {actorID}},
+		Inventory:  map[string]uint32{testItemKey.String(): 1},
+	}
+
+	tests := []struct {
+		input  string
+		itemID string
+		ssml   string
+	}{
+		{"Do I have the rusty key?", testItemKey.String(), "Yes, you have the Rusty Key."},
+		{"do i have a lantern", testItemLantern.String(), "No, you do not have the Lantern."},
+		{"Do you have a lantern?", "", "The shopkeeper points at your lantern."},
+		{"Do I have a dragon?", "", "The shopkeeper shrugs."},
+	}
+	for _, test := range tests {
+		message := AIRequest{State: state, OutputSSML: ssml.NewBuilder()}
+		result, err := RunTurn(&message, test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		itemID := ""
+		if result.ItemID != nil {
+			itemID = *result.ItemID
+		}
+		if itemID != test.itemID {
+			t.Errorf("%q: expected item %q, received %q", test.input, test.itemID, itemID)
+		}
+		if result.SSML != "<speak>"+test.ssml+"</speak>" {
+			t.Errorf("%q: unexpected SSML %v", test.input, result.SSML)
+		}
+	}
+
+	if _, err := CompileItems([]Item{{Title: "A Key"}, {Title: "the key"}}); err == nil {
+		t.Error("Expected an error compiling items with the same prepared title")
+	}
+}
+
+func TestRAResetAppEmptiesInventory(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	message := AIRequest{
+		State:      MutableAIRequestState{PubID: "1", Inventory: map[string]uint32{testItemKey.String(): 2}},
+		OutputSSML: ssml.NewBuilder(),
+	}
+	reset := RAResetApp(true)
+	if err := reset.Execute(&message); err != nil {
+		t.Fatal(err)
+	}
+	if len(message.State.Inventory) != 0 {
+		t.Errorf("Expected an empty inventory, received %v", message.State.Inventory)
+	}
+}
@@ -33,6 +33,11 @@ func KeynavCompiledEntity(pubID string, entityID AEID, uniqueID string) string {
 	return fmt.Sprintf("%v:%v:e:%v:%v", compiledNamespaceV2, pubID, entityID, uniqueID)
 }
 
+// KeynavCompiledEntities generates the prefix shared by every compiled entity key of a published project
+func KeynavCompiledEntities(pubID string) string {
+	return fmt.Sprintf("%v:%v:e:", compiledNamespaceV2, pubID)
+}
+
 // KeynavCompiledDialogRootWithinActor generates the key for a dialog root node within a actor
 // Notice that we're not using a node ID. This is because the list of nodes within a actor
 // are not readily available, for performance reasons.
@@ -69,6 +74,12 @@ func KeynavCompiledActorsWithinZone(pubID, zoneID string) string {
 		AEIDActor)
 }
 
+// KeynavCompiledItems generates the key to a hash of every item within the project
+// Each field is the prepared title of an item, see PrepareItemTitle
+func KeynavCompiledItems(pubID string) string {
+	return fmt.Sprintf("%v:%v:e:%v", compiledNamespaceV2, pubID, AEIDItem)
+}
+
 // KeynavCompiledDialogNodeActionBundle generates the key for
 // an action bundle within a dialog node
 func KeynavCompiledDialogNodeActionBundle(pubID, dialogID string, bundleID uint64) string {
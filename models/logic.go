@@ -2,7 +2,14 @@ package models
 
 import (
 	"database/sql/driver"
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	utilities "github.com/talkative-ai/core"
 )
 
 // LBlock is the RawLBlock after ActionSet has been bundled
@@ -35,6 +42,8 @@ type LBlock struct {
 type LStatement struct {
 	Operators *OrGroup
 	Exec      string
+	// Condition is evaluated instead of the OrGroup if it is set
+	Condition *Condition `json:",omitempty"`
 }
 
 // RawLBlock contains every execution block
@@ -57,10 +66,32 @@ func (a *RawLStatementUnified) Value() (driver.Value, error) {
 	return json.Marshal(a)
 }
 
-// RawLStatement contains an OrGroup of AndGroups
+// RawLStatement contains the Condition under which the Exec ActionSet executes
+// Operators is only read from JSON stored before Condition existed,
+// and is migrated into the equivalent Condition when decoded
 type RawLStatement struct {
-	Operators *OrGroup
+	Operators *OrGroup `json:",omitempty"`
 	Exec      ActionSet
+	Condition *Condition `json:",omitempty"`
+}
+
+// UnmarshalJSON decodes the RawLStatement, migrating Operators into Condition
+// Returns an error if the Condition is invalid, see Condition.Validate
+func (stmt *RawLStatement) UnmarshalJSON(b []byte) error {
+	type rawLStatement RawLStatement
+	decoded := rawLStatement{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return err
+	}
+	*stmt = RawLStatement(decoded)
+	if stmt.Condition == nil {
+		stmt.Condition = stmt.Operators.Condition()
+	}
+	stmt.Operators = nil
+	if err := stmt.Condition.Validate(); err != nil {
+		return fmt.Errorf("Invalid condition: %s", err.Error())
+	}
+	return nil
 }
 
 // VarValMap contains a mapping of variables
@@ -93,11 +124,34 @@ const (
 	OpStrGE OperatorStr = "ge"
 	// OpStrNE !=
 	OpStrNE OperatorStr = "ne"
+	// OpStrHasItem yields true if the item is within the inventory
+	// The values of its VarValMap are item IDs. The keys only distinguish them
+	OpStrHasItem OperatorStr = "has_item"
+	// OpStrContains yields true if the list variable contains the value
+	OpStrContains OperatorStr = "contains"
+	// OpStrStartsWith yields true if the string variable starts with the value, ignoring case
+	OpStrStartsWith OperatorStr = "starts_with"
+	// OpStrContainsText yields true if the string variable contains the value, ignoring case
+	OpStrContainsText OperatorStr = "contains_text"
+	// OpStrBetween yields true if the variable is within the inclusive range
+	// The value is a list of the lower and upper bounds
+	OpStrBetween OperatorStr = "between"
+	// OpStrExists yields true if the variable is set and the value is true,
+	// or if the variable is not set and the value is false
+	OpStrExists OperatorStr = "exists"
+	// OpStrZoneVisited yields true if the zone has been entered since the app was reset
+	// The values of its VarValMap are zone IDs. The keys only distinguish them
+	OpStrZoneVisited OperatorStr = "zone_visited"
+	// OpStrActorPresent yields true if the actor is within the current zone
+	// The values of its VarValMap are actor IDs. The keys only distinguish them
+	OpStrActorPresent OperatorStr = "actor_present"
 )
 
 // OperatorInt is a compiled OperatorStr
 // Compiled by Lakshmi and for use by the runtime Brahman
-type OperatorInt int8
+// Operators are compiled as varints, so the operators which fit within
+// 7 bits compile to the single byte they compiled to before OperatorInt was widened
+type OperatorInt uint32
 
 const (
 	// OpIntEQ =
@@ -112,16 +166,466 @@ const (
 	OpIntGE
 	// OpIntNE !=
 	OpIntNE
+	// OpIntHasItem has item
+	OpIntHasItem
+	// OpIntContains list contains
+	OpIntContains
+	// OpIntStartsWith string starts with
+	OpIntStartsWith
+	// OpIntContainsText string contains
+	OpIntContainsText
+	// OpIntBetween within range
+	OpIntBetween
+	// OpIntExists variable is set
+	OpIntExists
+	// OpIntZoneVisited zone visited
+	OpIntZoneVisited
+	// OpIntActorPresent actor present
+	OpIntActorPresent
 )
 
 // GenerateOperatorStrIntMap is a helper function for Lakshmi's compilation process
 func GenerateOperatorStrIntMap() map[OperatorStr]OperatorInt {
 	return map[OperatorStr]OperatorInt{
-		OpStrEQ: OpIntEQ,
-		OpStrLT: OpIntLT,
-		OpStrGT: OpIntGT,
-		OpStrLE: OpIntLE,
-		OpStrGE: OpIntGE,
-		OpStrNE: OpIntNE,
+		OpStrEQ:           OpIntEQ,
+		OpStrLT:           OpIntLT,
+		OpStrGT:           OpIntGT,
+		OpStrLE:           OpIntLE,
+		OpStrGE:           OpIntGE,
+		OpStrNE:           OpIntNE,
+		OpStrHasItem:      OpIntHasItem,
+		OpStrContains:     OpIntContains,
+		OpStrStartsWith:   OpIntStartsWith,
+		OpStrContainsText: OpIntContainsText,
+		OpStrBetween:      OpIntBetween,
+		OpStrExists:       OpIntExists,
+		OpStrZoneVisited:  OpIntZoneVisited,
+		OpStrActorPresent: OpIntActorPresent,
+	}
+}
+
+// operatorStrInt and operatorIntStr are shared by compilation and evaluation
+// so that neither allocates an operator map per call
+var operatorStrInt = GenerateOperatorStrIntMap()
+
+var operatorIntStr = func() map[OperatorInt]OperatorStr {
+	m := map[OperatorInt]OperatorStr{}
+	for str, op := range operatorStrInt {
+		m[op] = str
+	}
+	return m
+}()
+
+// Compiled value type tags used within compiled conditions
+const (
+	compiledValueInt byte = iota
+	compiledValueFloat
+	compiledValueBool
+	compiledValueString
+	compiledValueList
+)
+
+// Compile is used by Lakshmi
+// Returns the compiled []byte slice of the LBlock as expected by LogicLazyEval
+// To be stored in Redis
+// Returns an error if the LBlock does not fit the compiled layout, see checkCompiledCount
+//
+// The layout is as follows:
+// [2 bytes: AlwaysExec length][AlwaysExec key]
+// [1 byte: number of statement blocks]
+// Followed by each statement block:
+// [8 bytes: block length][compiled []LStatement]
+//
+// The statement block count and blocks are omitted if there are no Statements
+func (block LBlock) Compile() ([]byte, error) {
+	if err := checkCompiledString(block.AlwaysExec, "AlwaysExec key"); err != nil {
+		return nil, err
+	}
+	compiled := appendCompiledString([]byte{}, block.AlwaysExec)
+	if block.Statements == nil || len(*block.Statements) == 0 {
+		return compiled, nil
+	}
+
+	if err := checkCompiledCount(len(*block.Statements), math.MaxUint8, "statement blocks"); err != nil {
+		return nil, err
+	}
+	compiled = append(compiled, byte(len(*block.Statements)))
+	for _, stmts := range *block.Statements {
+		stmtBytes, err := compileLStatements(stmts)
+		if err != nil {
+			return nil, err
+		}
+		lenBytes := make([]byte, 8)
+		binary.LittleEndian.PutUint64(lenBytes, uint64(len(stmtBytes)))
+		compiled = append(compiled, lenBytes...)
+		compiled = append(compiled, stmtBytes...)
+	}
+
+	return compiled, nil
+}
+
+// compileLStatements compiles a single "if/elif/else" block
+//
+// The layout is as follows:
+// [1 byte: number of LStatements]
+// Followed by each LStatement:
+// [2 bytes: Exec length][Exec key][compiled OrGroup]
+// Or if the LStatement has a Condition:
+// [2 bytes: Exec length][Exec key][1 byte: 0xFF][compiled Condition]
+func compileLStatements(stmts []LStatement) ([]byte, error) {
+	if err := checkCompiledCount(len(stmts), math.MaxUint8, "statements"); err != nil {
+		return nil, err
+	}
+	compiled := []byte{byte(len(stmts))}
+	for _, stmt := range stmts {
+		if err := checkCompiledString(stmt.Exec, "Exec key"); err != nil {
+			return nil, err
+		}
+		compiled = appendCompiledString(compiled, stmt.Exec)
+		if stmt.Condition != nil {
+			if err := stmt.Condition.Validate(); err != nil {
+				return nil, err
+			}
+			compiled = append(compiled, compiledConditionMarker)
+			compiled = stmt.Condition.appendCompiled(compiled)
+			continue
+		}
+		group, err := stmt.Operators.Compile()
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, group...)
+	}
+	return compiled, nil
+}
+
+// Compile returns the compiled []byte slice of the OrGroup
+// A nil or empty OrGroup compiles to a single zero byte,
+// which the runtime treats as a statement without conditions (i.e. "else")
+// Returns an error if the OrGroup does not fit the compiled layout, see checkCompiledCount
+//
+// The layout is as follows:
+// [1 byte: number of AndGroups]
+// Followed by each AndGroup:
+// [1 byte: number of operators]
+// Followed by each operator, sorted by OperatorInt:
+// [varint: OperatorInt][1 byte: number of variables]
+// Followed by each variable, sorted by ID:
+// [2 bytes: variable key length][variable key][compiled value]
+//
+// The variable key is the decimal representation of the VarValMap key,
+// which is the key of the variable within MutableAIRequestState.ARVariables
+func (group *OrGroup) Compile() ([]byte, error) {
+	if group == nil {
+		return []byte{0}, nil
+	}
+
+	// An AndGroup count of compiledConditionMarker would be read as a compiled Condition
+	if err := checkCompiledCount(len(*group), int(compiledConditionMarker)-1, "AndGroups"); err != nil {
+		return nil, err
+	}
+	compiled := []byte{byte(len(*group))}
+	for _, and := range *group {
+		ops := []OperatorStr{}
+		for op := range and {
+			ops = append(ops, op)
+		}
+		sort.Slice(ops, func(i, j int) bool {
+			return operatorStrInt[ops[i]] < operatorStrInt[ops[j]]
+		})
+
+		if err := checkCompiledCount(len(ops), math.MaxUint8, "operators"); err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, byte(len(ops)))
+		for _, op := range ops {
+			opInt, ok := operatorStrInt[op]
+			if !ok {
+				return nil, fmt.Errorf("Unsupported operator %q", op)
+			}
+			vars := and[op]
+			ids := []int{}
+			for id := range vars {
+				ids = append(ids, id)
+			}
+			sort.Ints(ids)
+
+			if err := checkCompiledCount(len(ids), math.MaxUint8, "variables"); err != nil {
+				return nil, err
+			}
+			compiled = appendCompiledOperator(compiled, opInt)
+			compiled = append(compiled, byte(len(ids)))
+			for _, id := range ids {
+				if err := checkCompiledValue(vars[id]); err != nil {
+					return nil, err
+				}
+				compiled = appendCompiledString(compiled, strconv.Itoa(id))
+				compiled = appendCompiledValue(compiled, vars[id])
+			}
+		}
+	}
+
+	return compiled, nil
+}
+
+// checkCompiledCount returns an error if n does not fit within a count of the compiled layout
+// Counts are written as a single byte, or as 2 bytes for the elements of a list
+func checkCompiledCount(n, max int, what string) error {
+	if n > max {
+		return fmt.Errorf("Too many %v: %v exceeds the maximum of %v", what, n, max)
+	}
+	return nil
+}
+
+// checkCompiledString returns an error if the string is too long to be prefixed with its 2 byte length
+func checkCompiledString(s, what string) error {
+	if len(s) > math.MaxUint16 {
+		return fmt.Errorf("%v is too long: %v bytes exceeds the maximum of %v", what, len(s), math.MaxUint16)
+	}
+	return nil
+}
+
+// checkCompiledValue returns an error if the value does not fit the layout of appendCompiledValue
+func checkCompiledValue(val interface{}) error {
+	switch v := val.(type) {
+	case []interface{}:
+		if err := checkCompiledCount(len(v), math.MaxUint16, "values within a list"); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := checkCompiledValue(item); err != nil {
+				return err
+			}
+		}
+	case bool, int, int64, float64:
+	default:
+		return checkCompiledString(fmt.Sprintf("%v", v), "Value")
+	}
+	return nil
+}
+
+// appendCompiledOperator appends the OperatorInt as a varint
+func appendCompiledOperator(compiled []byte, op OperatorInt) []byte {
+	opBytes := make([]byte, binary.MaxVarintLen32)
+	return append(compiled, opBytes[:binary.PutUvarint(opBytes, uint64(op))]...)
+}
+
+// readCompiledOperator reads an OperatorInt compiled by appendCompiledOperator
+func readCompiledOperator(r *utilities.ByteReader) (OperatorInt, error) {
+	n, err := r.Uvarint()
+	if err != nil {
+		return 0, fmt.Errorf("Error reading operator: %s", err.Error())
+	}
+	if _, ok := operatorIntStr[OperatorInt(n)]; n > math.MaxUint32 || !ok {
+		return 0, fmt.Errorf("Unsupported operator: %v", n)
+	}
+	return OperatorInt(n), nil
+}
+
+// appendCompiledString appends a string prefixed with its 2 byte length
+func appendCompiledString(compiled []byte, s string) []byte {
+	lenBytes := make([]byte, 2)
+	binary.LittleEndian.PutUint16(lenBytes, uint16(len(s)))
+	compiled = append(compiled, lenBytes...)
+	return append(compiled, []byte(s)...)
+}
+
+// appendCompiledValue appends a comparison value prefixed with its type tag
+// Integral JSON numbers are compiled as integers
+// Lists, such as the bounds of OpStrBetween, are prefixed with their 2 byte length
+func appendCompiledValue(compiled []byte, val interface{}) []byte {
+	valBytes := make([]byte, 8)
+	switch v := val.(type) {
+	case []interface{}:
+		compiled = append(compiled, compiledValueList)
+		lenBytes := make([]byte, 2)
+		binary.LittleEndian.PutUint16(lenBytes, uint16(len(v)))
+		compiled = append(compiled, lenBytes...)
+		for _, item := range v {
+			compiled = appendCompiledValue(compiled, item)
+		}
+		return compiled
+	case bool:
+		compiled = append(compiled, compiledValueBool)
+		if v {
+			return append(compiled, 1)
+		}
+		return append(compiled, 0)
+	case int:
+		binary.LittleEndian.PutUint64(valBytes, uint64(v))
+	case int64:
+		binary.LittleEndian.PutUint64(valBytes, uint64(v))
+	case float64:
+		// Only whole numbers within the range of an int64 are compiled as an int
+		if v != math.Trunc(v) || v < math.MinInt64 || v >= math.MaxInt64 {
+			binary.LittleEndian.PutUint64(valBytes, math.Float64bits(v))
+			compiled = append(compiled, compiledValueFloat)
+			return append(compiled, valBytes...)
+		}
+		binary.LittleEndian.PutUint64(valBytes, uint64(int64(v)))
+	default:
+		compiled = append(compiled, compiledValueString)
+		return appendCompiledString(compiled, fmt.Sprintf("%v", v))
+	}
+	compiled = append(compiled, compiledValueInt)
+	return append(compiled, valBytes...)
+}
+
+// CreateFrom decodes an LBlock compiled by LBlock.Compile
+// This is useful for testing and debugging compiled logic
+func (block *LBlock) CreateFrom(compiled []byte) error {
+	r := utilities.ByteReader{Bytes: compiled}
+
+	alwaysExec, err := r.LengthPrefixedString()
+	if err != nil {
+		return fmt.Errorf("Error reading AlwaysExec key: %s", err.Error())
+	}
+	block.AlwaysExec = alwaysExec
+	block.Statements = nil
+
+	if r.Finished() {
+		return nil
+	}
+
+	numStatements, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+
+	statements := make([][]LStatement, numStatements)
+	for i := range statements {
+		stmtLen, err := r.Uint64()
+		if err != nil {
+			return fmt.Errorf("Error reading logical statement: %s", err.Error())
+		}
+		stmtBytes, err := r.ReadNBytes(stmtLen)
+		if err != nil {
+			return fmt.Errorf("Error reading logical statement: %s", err.Error())
+		}
+		statements[i], err = decodeLStatements(stmtBytes)
+		if err != nil {
+			return err
+		}
+	}
+	block.Statements = &statements
+
+	return nil
+}
+
+// decodeLStatements decodes a single "if/elif/else" block compiled by compileLStatements
+func decodeLStatements(compiled []byte) ([]LStatement, error) {
+	r := utilities.ByteReader{Bytes: compiled}
+
+	numStmts, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading statement count: %s", err.Error())
+	}
+
+	stmts := make([]LStatement, numStmts)
+	for i := range stmts {
+		stmts[i].Exec, err = r.LengthPrefixedString()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading statement exec key: %s", err.Error())
+		}
+		stmts[i].Operators, stmts[i].Condition, err = readCompiledStatementCondition(&r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stmts, nil
+}
+
+// readCompiledOrGroup decodes an OrGroup compiled by OrGroup.Compile
+// An OrGroup without AndGroups decodes to nil
+func readCompiledOrGroup(r *utilities.ByteReader) (*OrGroup, error) {
+	numAnd, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading OrGroup: %s", err.Error())
+	}
+	if numAnd == 0 {
+		return nil, nil
+	}
+
+	group := make(OrGroup, numAnd)
+	for i := range group {
+		numOps, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading AndGroup: %s", err.Error())
+		}
+		group[i] = AndGroup{}
+		for j := 0; j < int(numOps); j++ {
+			opInt, err := readCompiledOperator(r)
+			if err != nil {
+				return nil, err
+			}
+			op := operatorIntStr[opInt]
+			numVars, err := r.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("Error reading variable count: %s", err.Error())
+			}
+			vars := VarValMap{}
+			for k := 0; k < int(numVars); k++ {
+				key, err := r.LengthPrefixedString()
+				if err != nil {
+					return nil, fmt.Errorf("Error reading variable key: %s", err.Error())
+				}
+				id, err := strconv.Atoi(key)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid variable key: %s", key)
+				}
+				vars[id], err = readCompiledValue(r)
+				if err != nil {
+					return nil, err
+				}
+			}
+			group[i][op] = vars
+		}
+	}
+
+	return &group, nil
+}
+
+// readCompiledValue reads a comparison value compiled by appendCompiledValue
+// Integers are decoded as int64, floats as float64 and lists as []interface{}
+func readCompiledValue(r *utilities.ByteReader) (interface{}, error) {
+	t, err := r.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading value type: %s", err.Error())
+	}
+	switch t {
+	case compiledValueInt, compiledValueFloat:
+		n, err := r.Uint64()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading numeric value: %s", err.Error())
+		}
+		if t == compiledValueFloat {
+			return math.Float64frombits(n), nil
+		}
+		return int64(n), nil
+	case compiledValueBool:
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading bool value: %s", err.Error())
+		}
+		return b != 0, nil
+	case compiledValueString:
+		return r.LengthPrefixedString()
+	case compiledValueList:
+		n, err := r.Uint16()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading list length: %s", err.Error())
+		}
+		list := []interface{}{}
+		for i := 0; i < int(n); i++ {
+			item, err := readCompiledValue(r)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	default:
+		return nil, fmt.Errorf("Unsupported value type: %v", t)
 	}
 }
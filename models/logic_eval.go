@@ -1,12 +1,13 @@
 package models
 
 import (
-	"bytes"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 
 	utilities "github.com/talkative-ai/core"
-
-	"encoding/binary"
+	uuid "github.com/talkative-ai/go.uuid"
 )
 
 type Result struct {
@@ -15,89 +16,503 @@ type Result struct {
 }
 
 // Evaluates the byte slice statement
-// Returns an exec id
-func evaluateStatement(state AIRequest, stmt []byte) (key string, eval bool) {
-	key = ""
-	eval = false
+// The statement is an "if/elif/else" block compiled by LBlock.Compile
+// Returns the exec id of the first LStatement whose OrGroup yields true
+// If no LStatement yields true, eval is false
+//
+// The statement is evaluated in place rather than decoded with decodeLStatements,
+// and reading stops at the first LStatement that yields true
+func evaluateStatement(state *AIRequest, stmt []byte) (key string, eval bool, err error) {
+	r := utilities.ByteReader{Bytes: stmt}
 
-	return
+	numStmts, err := r.ReadByte()
+	if err != nil {
+		return "", false, fmt.Errorf("Error reading statement count: %s", err.Error())
+	}
+
+	for i := 0; i < int(numStmts); i++ {
+		execLen, err := r.Uint16()
+		if err != nil {
+			return "", false, fmt.Errorf("Error reading statement exec key: %s", err.Error())
+		}
+		exec, err := r.ReadNBytes(uint64(execLen))
+		if err != nil {
+			return "", false, fmt.Errorf("Error reading statement exec key: %s", err.Error())
+		}
+		eval, err := evaluateCompiledStatementCondition(&r, &state.State)
+		if err != nil {
+			return "", false, err
+		}
+		if eval {
+			return string(exec), true, nil
+		}
+	}
+
+	return "", false, nil
 }
 
-// LogicLazyEval is used during Talkative project user request runtime.
-// When a request is made in-game, it's routed to the appropriate dialog
-// The dialog has logical blocks attached therein,
-// which yield Redis Keys for respective ActionBundle binaries
-func LogicLazyEval(stateComms chan AIRequest, compiled []byte) <-chan Result {
+// evaluateCompiledOrGroup evaluates an OrGroup compiled by OrGroup.Compile
+// with the same semantics as OrGroup.Evaluate
+// Returns as soon as an AndGroup yields true, otherwise the reader is left after the OrGroup
+func evaluateCompiledOrGroup(r *utilities.ByteReader, state *MutableAIRequestState) (bool, error) {
+	numAnd, err := r.ReadByte()
+	if err != nil {
+		return false, fmt.Errorf("Error reading OrGroup: %s", err.Error())
+	}
+	if numAnd == 0 {
+		return true, nil
+	}
 
-	ch := make(chan Result)
-	go func() {
-		defer close(ch)
+	for i := 0; i < int(numAnd); i++ {
+		numOps, err := r.ReadByte()
+		if err != nil {
+			return false, fmt.Errorf("Error reading AndGroup: %s", err.Error())
+		}
+		and := true
+		for j := 0; j < int(numOps); j++ {
+			op, err := readCompiledOperator(r)
+			if err != nil {
+				return false, err
+			}
+			numVars, err := r.ReadByte()
+			if err != nil {
+				return false, fmt.Errorf("Error reading variable count: %s", err.Error())
+			}
+			for k := 0; k < int(numVars); k++ {
+				keyLen, err := r.Uint16()
+				if err != nil {
+					return false, fmt.Errorf("Error reading variable key: %s", err.Error())
+				}
+				key, err := r.ReadNBytes(uint64(keyLen))
+				if err != nil {
+					return false, fmt.Errorf("Error reading variable key: %s", err.Error())
+				}
+				val, err := readCompiledValue(r)
+				if err != nil {
+					return false, err
+				}
+				if !and {
+					continue
+				}
+				and = evaluateOperator(state, op, string(key), val)
+			}
+		}
+		if and {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
 
-		reader := bytes.NewReader(compiled)
-		r := utilities.ByteReader{
-			Reader:   reader,
-			Position: 0,
+// selectStatement returns the index of the first LStatement whose OrGroup yields true
+// Returns -1 if none yield true
+func selectStatement(stmts []LStatement, state *MutableAIRequestState) int {
+	for i, s := range stmts {
+		if s.EvaluateState(state) {
+			return i
 		}
+	}
+	return -1
+}
 
-		// Reading the "AlwaysExec" key
-		// First get the length of the string
-		barr, err := r.ReadNBytes(2)
-		if err != nil {
-			ch <- Result{Error: fmt.Errorf("Error reading AlwaysExec length: %s", err.Error())}
+// EvaluateState yields true if the Condition, or the OrGroup if there is no Condition,
+// yields true against the runtime state
+func (stmt LStatement) EvaluateState(state *MutableAIRequestState) bool {
+	if stmt.Condition != nil {
+		return stmt.Condition.Evaluate(state)
+	}
+	return stmt.Operators.EvaluateState(state)
+}
+
+// Evaluate yields true if at least one AndGroup yields true
+// A nil or empty OrGroup has no conditions and therefore always yields true
+// Conditions on anything other than the variables, such as OpStrHasItem, never yield true
+func (group *OrGroup) Evaluate(vars map[string]*ARVariable) bool {
+	return group.EvaluateState(&MutableAIRequestState{ARVariables: vars})
+}
+
+// EvaluateState yields true if at least one AndGroup yields true against the runtime state
+func (group *OrGroup) EvaluateState(state *MutableAIRequestState) bool {
+	if group == nil || len(*group) == 0 {
+		return true
+	}
+	for _, and := range *group {
+		if and.EvaluateState(state) {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate yields true if every variable compared to its value
+// with respect to the operator yields true
+// Variables that do not exist in the runtime state always yield false
+func (and AndGroup) Evaluate(vars map[string]*ARVariable) bool {
+	return and.EvaluateState(&MutableAIRequestState{ARVariables: vars})
+}
+
+// EvaluateState yields true if every operator yields true against the runtime state
+// Variables are looked up by their legacy numeric ID. Compiled OrGroups only exist within projects
+// published before variables were named, as Lakshmi now compiles Conditions renamed by LegacyVariableNames
+func (and AndGroup) EvaluateState(state *MutableAIRequestState) bool {
+	for opStr, varVals := range and {
+		op, ok := operatorStrInt[opStr]
+		if !ok {
+			return false
+		}
+		for id, val := range varVals {
+			if !evaluateOperator(state, op, strconv.Itoa(id), val) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// evaluateOperator yields true if the operator holds for the variable with the key and the value
+// OpIntHasItem, OpIntZoneVisited and OpIntActorPresent ignore the key
+// and look the value up within the runtime state instead
+func evaluateOperator(state *MutableAIRequestState, op OperatorInt, key string, val interface{}) bool {
+	switch op {
+	case OpIntHasItem:
+		itemID, ok := val.(string)
+		return ok && state.Inventory[itemID] > 0
+	case OpIntZoneVisited:
+		zoneID, ok := val.(string)
+		return ok && state.ZoneInitialized[uuid.FromStringOrNil(zoneID)]
+	case OpIntActorPresent:
+		actorID, ok := val.(string)
+		if !ok {
+			return false
+		}
+		for _, id := range state.ZoneActors[state.Zone] {
+			if id == actorID {
+				return true
+			}
+		}
+		return false
+	case OpIntExists:
+		expected, ok := val.(bool)
+		v, set := state.ARVariables[key]
+		return ok && (set && v != nil) == expected
+	}
+	v, ok := state.ARVariables[key]
+	return ok && v != nil && compareOperator(op, v.Val, val)
+}
+
+// compareOperator compares the runtime value to the expected value
+// Because OperatorInt is a bit set, the comparison yields true
+// if any of the operators within op yield true
+// Values of mismatching types never yield true
+func compareOperator(op OperatorInt, actual, expected interface{}) bool {
+	if op&OpIntContains != 0 && listContains(actual, expected) {
+		return true
+	}
+	if op&(OpIntStartsWith|OpIntContainsText) != 0 && matchText(op, actual, expected) {
+		return true
+	}
+	if op&OpIntBetween != 0 && between(actual, expected) {
+		return true
+	}
+
+	cmp, ordered, ok := compareValues(actual, expected)
+	if !ok {
+		return false
+	}
+	if op&OpIntEQ != 0 && cmp == 0 {
+		return true
+	}
+	if op&OpIntNE != 0 && cmp != 0 {
+		return true
+	}
+	if !ordered {
+		return false
+	}
+	return op&OpIntLT != 0 && cmp < 0 ||
+		op&OpIntGT != 0 && cmp > 0 ||
+		op&OpIntLE != 0 && cmp <= 0 ||
+		op&OpIntGE != 0 && cmp >= 0
+}
+
+// listContains yields true if the list holds an element equal to the value
+func listContains(list, val interface{}) bool {
+	arr, ok := list.([]ARVariable)
+	if !ok {
+		return false
+	}
+	for _, item := range arr {
+		if cmp, _, ok := compareValues(item.Val, val); ok && cmp == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchText yields true if OpIntStartsWith or OpIntContainsText within op
+// holds for the strings, ignoring case
+func matchText(op OperatorInt, actual, expected interface{}) bool {
+	a, aok := actual.(string)
+	b, bok := expected.(string)
+	if !aok || !bok {
+		return false
+	}
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	return op&OpIntStartsWith != 0 && strings.HasPrefix(a, b) ||
+		op&OpIntContainsText != 0 && strings.Contains(a, b)
+}
+
+// between yields true if the value is within the inclusive bounds
+// The bounds are a list of the lower and upper bound
+func between(val, bounds interface{}) bool {
+	b, ok := bounds.([]interface{})
+	if !ok || len(b) != 2 {
+		return false
+	}
+	lower, lordered, lok := compareValues(val, b[0])
+	upper, uordered, uok := compareValues(val, b[1])
+	return lok && uok && lordered && uordered && lower >= 0 && upper <= 0
+}
+
+// compareValues returns -1, 0 or 1 if a is less than, equal to or greater than b
+// ordered is false for types which only support equality (bool)
+// ok is false if the values cannot be compared
+func compareValues(a, b interface{}) (cmp int, ordered bool, ok bool) {
+	if af, aok := toFloat64(a); aok {
+		bf, bok := toFloat64(b)
+		if !bok {
 			return
 		}
-		strlen := binary.LittleEndian.Uint16(barr)
+		switch {
+		case af < bf:
+			cmp = -1
+		case af > bf:
+			cmp = 1
+		}
+		return cmp, true, true
+	}
 
-		// Read the Redis key for the AlwaysExec Action Bundle
-		execkey, err := r.ReadNBytes(uint64(strlen))
-		if err != nil {
-			ch <- Result{Error: fmt.Errorf("Error reading AlwaysExec key: %s", err.Error())}
+	switch av := a.(type) {
+	case string:
+		bv, bok := b.(string)
+		if !bok {
 			return
 		}
+		return strings.Compare(av, bv), true, true
+	case bool:
+		bv, bok := b.(bool)
+		if !bok {
+			return
+		}
+		if av != bv {
+			cmp = 1
+		}
+		return cmp, false, true
+	}
 
-		// Dispatch
-		ch <- Result{Value: string(execkey)}
+	return
+}
 
-		if r.Finished() {
-			return
+// toFloat64 converts any numeric runtime value to a float64
+// ARVariables may hold int, int64 or float64 values
+// depending on whether they were compiled or reloaded from JSON
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// toInt64 converts integral numeric values to int64
+// Values decoded from JSON are float64 and are accepted if integral and within the range of an int64
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int32:
+		return int64(n), true
+	case float64:
+		// float64(math.MaxInt64) rounds up to 2^63, which does not fit
+		if n == math.Trunc(n) && n >= math.MinInt64 && n < math.MaxInt64 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// LogicIterator evaluates a compiled logic block in-line, one statement chain at a time
+// AlwaysExec is read when the iterator is created. Each call to Next evaluates
+// the remaining statement chains against the current state of the AIRequest
+// until one of them yields the key of an ActionBundle
+type LogicIterator struct {
+	// AlwaysExec is the key of the unconditional ActionBundle, if any
+	AlwaysExec string
+
+	r         utilities.ByteReader
+	remaining int
+	err       error
+}
+
+// NewLogicIterator reads the header of the compiled logic block
+func NewLogicIterator(compiled []byte) (*LogicIterator, error) {
+	it := &LogicIterator{r: utilities.ByteReader{Bytes: compiled}}
+
+	// Read the Redis key for the AlwaysExec Action Bundle
+	execkey, err := it.r.LengthPrefixedString()
+	if err != nil {
+		return nil, it.fail(it.r.Position, "Error reading AlwaysExec key: %s", err.Error())
+	}
+	it.AlwaysExec = execkey
+
+	if it.r.Finished() {
+		return it, nil
+	}
+
+	// Get the number of conditional statement blocks
+	numStatements, err := it.r.ReadByte()
+	if err != nil {
+		return nil, it.fail(it.r.Position, "Error reading statement count: %s", err.Error())
+	}
+	it.remaining = int(numStatements)
+
+	return it, nil
+}
+
+// fail records a RuntimeError at the given offset of the compiled logic block
+// The iterator yields nothing further
+func (it *LogicIterator) fail(offset uint64, format string, args ...interface{}) error {
+	err := newRuntimeError(RuntimeErrorLogic, format, args...)
+	err.Offset = offset
+	it.err = err
+	it.remaining = 0
+	return err
+}
+
+// More yields true if statement chains remain to be evaluated
+func (it *LogicIterator) More() bool {
+	return it.remaining > 0
+}
+
+// Next evaluates statement chains against the state of message until one yields
+// Returns the key of the selected ActionBundle, or ok == false once every chain is evaluated
+// The caller should execute the ActionBundle before calling Next again,
+// as it may mutate the state the following chains are evaluated against
+func (it *LogicIterator) Next(message *AIRequest) (key string, ok bool, err error) {
+	for it.remaining > 0 {
+		it.remaining--
+		offset := it.r.Position
+		stmtlen, err := it.r.Uint64()
+		if err != nil {
+			return "", false, it.fail(offset, "Error reading logical statement: %s", err.Error())
+		}
+		stmt, err := it.r.ReadNBytes(stmtlen)
+		if err != nil {
+			return "", false, it.fail(offset, "Error reading logical statement: %s", err.Error())
 		}
 
-		// Get the number of conditional statement blocks
-		numStatements, err := r.ReadByte()
+		key, eval, err := evaluateStatement(message, stmt)
+		if err != nil {
+			return "", false, it.fail(offset, "Error evaluating logical statement: %s", err.Error())
+		}
+		if eval {
+			return key, true, nil
+		}
+	}
+	return "", false, it.err
+}
+
+// LogicEval evaluates a compiled logic block in-line against the AIRequest
+// exec is called with the AlwaysExec key, which may be empty,
+// followed by the key of each ActionBundle selected by a statement chain
+// Statement chains are evaluated against the state as mutated by the previous exec
+// Evaluation stops at the first error returned by exec
+func LogicEval(message *AIRequest, compiled []byte, exec func(key string) error) error {
+	it, err := NewLogicIterator(compiled)
+	if err != nil {
+		return err
+	}
+	if err := exec(it.AlwaysExec); err != nil {
+		return err
+	}
+	for {
+		key, ok, err := it.Next(message)
+		if err != nil || !ok {
+			return err
+		}
+		if err := exec(key); err != nil {
+			return err
+		}
+	}
+}
+
+// LogicLazyEval is used during Talkative project user request runtime.
+// When a request is made in-game, it's routed to the appropriate dialog
+// The dialog has logical blocks attached therein,
+// which yield Redis Keys for respective ActionBundle binaries
+//
+// The AlwaysExec key is sent first. Before evaluating the statements, and again
+// after each key yielded, the current AIRequest must be sent on stateComms
+// LogicLazyEval is a wrapper of LogicIterator. Prefer LogicEval
+func LogicLazyEval(stateComms chan AIRequest, compiled []byte) <-chan Result {
+
+	ch := make(chan Result)
+	go func() {
+		defer close(ch)
+
+		it, err := NewLogicIterator(compiled)
 		if err != nil {
 			ch <- Result{Error: err}
 			return
 		}
 
-		awaitNewState := true
-		var state AIRequest
+		// Dispatch
+		ch <- Result{Value: it.AlwaysExec}
 
-		for i := 0; i < int(numStatements); i++ {
-			barr, err := r.ReadNBytes(8)
+		for it.More() {
+			// The ActionBundle will mutate the state
+			// Therefore we must wait for a new one to pass
+			// to the next evaluation
+			state := <-stateComms
+			key, ok, err := it.Next(&state)
 			if err != nil {
-				ch <- Result{Error: fmt.Errorf("Error reqading logical statement: %s", err.Error())}
+				ch <- Result{Error: err}
 				return
 			}
-			stmtlen := binary.LittleEndian.Uint64(barr)
-
-			// evaluateStatement does not run in parallel
-			if awaitNewState {
-				state = <-stateComms
-				awaitNewState = false
-			}
-			key, eval := evaluateStatement(state, compiled[r.Position:r.Position+stmtlen])
-			if eval {
-				// If the statement evaluated to true
-				// Send the key for the ActionBundle back for processing
-				ch <- Result{Value: key}
-				// The ActionBundle will mutate the state
-				// Therefore we must wait for a new one to pass
-				// to the next evaluateStatement function
-				awaitNewState = true
+			if !ok {
+				return
 			}
+			// Send the key for the ActionBundle back for processing
+			ch <- Result{Value: key}
 		}
 
 	}()
 
 	return ch
 }
+
+// evalLogicBlock evaluates a compiled logic block stored at key
+// and executes every ActionBundle it yields against the AIRequest
+func evalLogicBlock(message *AIRequest, key string, compiled []byte) error {
+	err := LogicEval(message, compiled, func(bundleKey string) error {
+		if bundleKey == "" {
+			return nil
+		}
+		return ActionBundleEvalKey(message, bundleKey)
+	})
+	if rerr, ok := err.(*RuntimeError); ok && !rerr.located {
+		rerr.BundleKey = key
+		rerr.located = true
+	}
+	return err
+}
@@ -0,0 +1,462 @@
+package models
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	utilities "github.com/talkative-ai/core"
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+func testLBlock() LBlock {
+	statements := [][]LStatement{
+		{
+			{Exec: "a_if", Operators: &OrGroup{
+				AndGroup{OpStrGT: VarValMap{1: int64(100)}},
+			}},
+			{Exec: "a_elif", Operators: &OrGroup{
+				AndGroup{OpStrGE: VarValMap{1: int64(50)}, OpStrEQ: VarValMap{2: true}},
+				AndGroup{OpStrEQ: VarValMap{3: "alice"}},
+			}},
+			{Exec: "a_else"},
+		},
+		{
+			{Exec: "b_if", Operators: &OrGroup{
+				AndGroup{OpStrEQ: VarValMap{3: "alice"}},
+			}},
+			{Exec: "b_elif", Operators: &OrGroup{
+				AndGroup{OpStrLT: VarValMap{4: 1.5}},
+			}},
+		},
+		{
+			{Exec: "c_if", Operators: &OrGroup{
+				AndGroup{OpStrLE: VarValMap{1: int64(10)}, OpStrNE: VarValMap{3: "bob"}},
+			}},
+			{Exec: "c_else"},
+		},
+	}
+	return LBlock{
+		AlwaysExec: "always",
+		Statements: &statements,
+	}
+}
+
+// mustCompile compiles the LBlock, failing the test on an error
+func mustCompile(t testing.TB, block LBlock) []byte {
+	t.Helper()
+	compiled, err := block.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compiled
+}
+
+// mustCompileOrGroup compiles the OrGroup, failing the test on an error
+func mustCompileOrGroup(t testing.TB, group *OrGroup) []byte {
+	t.Helper()
+	compiled, err := group.Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compiled
+}
+
+func TestLBlockCompileRoundTrip(t *testing.T) {
+	block := testLBlock()
+	compiled := mustCompile(t, block)
+
+	decoded := LBlock{}
+	if err := decoded.CreateFrom(compiled); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(block, decoded) {
+		t.Fatalf("Decoded LBlock does not match\nExpected: %+v\nReceived: %+v", block, decoded)
+	}
+
+	// Map iteration order must not affect the compiled output
+	for i := 0; i < 10; i++ {
+		if !reflect.DeepEqual(compiled, mustCompile(t, block)) {
+			t.Fatal("LBlock compilation is not deterministic")
+		}
+	}
+
+	empty := LBlock{AlwaysExec: "always"}
+	decoded = LBlock{}
+	if err := decoded.CreateFrom(mustCompile(t, empty)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(empty, decoded) {
+		t.Fatalf("Decoded LBlock does not match\nExpected: %+v\nReceived: %+v", empty, decoded)
+	}
+}
+
+func TestLBlockCompileLimits(t *testing.T) {
+	manyStatements := make([]LStatement, 256)
+	manyBlocks := make([][]LStatement, 256)
+	// 255 AndGroups would collide with the compiledConditionMarker
+	manyAnds := make(OrGroup, 255)
+	for i := range manyAnds {
+		manyAnds[i] = AndGroup{OpStrEQ: VarValMap{1: true}}
+	}
+	manyVars := VarValMap{}
+	for i := 0; i < 256; i++ {
+		manyVars[i] = true
+	}
+	long := strings.Repeat("a", math.MaxUint16+1)
+
+	tests := map[string]LBlock{
+		"always":     {AlwaysExec: long},
+		"blocks":     {Statements: &manyBlocks},
+		"statements": {Statements: &[][]LStatement{manyStatements}},
+		"exec":       {Statements: &[][]LStatement{{{Exec: long}}}},
+		"ands":       {Statements: &[][]LStatement{{{Operators: &manyAnds}}}},
+		"vars":       {Statements: &[][]LStatement{{{Operators: &OrGroup{AndGroup{OpStrEQ: manyVars}}}}}},
+		"value":      {Statements: &[][]LStatement{{{Operators: &OrGroup{AndGroup{OpStrEQ: VarValMap{1: long}}}}}}},
+		"list":       {Statements: &[][]LStatement{{{Operators: &OrGroup{AndGroup{OpStrContains: VarValMap{1: make([]interface{}, math.MaxUint16+1)}}}}}}},
+		"operator":   {Statements: &[][]LStatement{{{Operators: &OrGroup{AndGroup{"like": VarValMap{1: "a"}}}}}}},
+	}
+	for name, block := range tests {
+		if _, err := block.Compile(); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+
+	// The largest counts still round trip
+	maxStatements := make([]LStatement, math.MaxUint8)
+	maxStatements[0].Exec = strings.Repeat("a", math.MaxUint16)
+	block := LBlock{Statements: &[][]LStatement{maxStatements}}
+	decoded := LBlock{}
+	if err := decoded.CreateFrom(mustCompile(t, block)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, block) {
+		t.Error("Expected the largest statement block to round trip")
+	}
+
+	maxAnds := manyAnds[:254]
+	block = LBlock{Statements: &[][]LStatement{{{Operators: &maxAnds}}}}
+	decoded = LBlock{}
+	if err := decoded.CreateFrom(mustCompile(t, block)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, block) {
+		t.Error("Expected the largest OrGroup to round trip")
+	}
+}
+
+func TestLogicLazyEval(t *testing.T) {
+	state := AIRequest{
+		State: MutableAIRequestState{
+			ARVariables: map[string]*ARVariable{
+				"1": {T: "int", Val: int64(50)},
+				"2": {T: "bool", Val: true},
+				"3": {T: "string", Val: "bob"},
+				"4": {T: "int", Val: float64(3)},
+			},
+		},
+	}
+
+	stateComms := make(chan AIRequest)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case stateComms <- state:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	keys := []string{}
+	for res := range LogicLazyEval(stateComms, mustCompile(t, testLBlock())) {
+		if res.Error != nil {
+			t.Fatal(res.Error)
+		}
+		keys = append(keys, res.Value)
+	}
+
+	expected := []string{"always", "a_elif", "c_else"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("Unexpected keys\nExpected: %v\nReceived: %v", expected, keys)
+	}
+}
+
+func TestLogicEval(t *testing.T) {
+	message := AIRequest{
+		State: MutableAIRequestState{
+			ARVariables: map[string]*ARVariable{
+				"1": {T: "int", Val: int64(50)},
+				"2": {T: "bool", Val: true},
+				"3": {T: "string", Val: "bob"},
+				"4": {T: "int", Val: float64(3)},
+			},
+		},
+	}
+
+	keys := []string{}
+	err := LogicEval(&message, mustCompile(t, testLBlock()), func(key string) error {
+		keys = append(keys, key)
+		// Chains following a_elif are evaluated against the mutated state
+		if key == "a_elif" {
+			message.State.ARVariables["3"].Val = "alice"
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"always", "a_elif", "b_if", "c_else"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("Unexpected keys\nExpected: %v\nReceived: %v", expected, keys)
+	}
+}
+
+func TestLogicIteratorErrors(t *testing.T) {
+	compiled := mustCompile(t, testLBlock())
+
+	if _, err := NewLogicIterator(compiled[:1]); err == nil {
+		t.Error("Expected an error reading a truncated AlwaysExec key")
+	}
+
+	it, err := NewLogicIterator(compiled[:len(compiled)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := AIRequest{State: MutableAIRequestState{ARVariables: map[string]*ARVariable{}}}
+	for {
+		_, ok, err := it.Next(&message)
+		if err != nil {
+			if rerr, isRuntime := err.(*RuntimeError); !isRuntime || rerr.Code != RuntimeErrorLogic || rerr.Offset == 0 {
+				t.Errorf("Expected a located RuntimeErrorLogic, received %v", err)
+			}
+			break
+		}
+		if !ok {
+			t.Fatal("Expected an error reading a truncated statement")
+		}
+	}
+	if it.More() {
+		t.Error("Expected the iterator to stop after an error")
+	}
+}
+
+func TestCompareOperator(t *testing.T) {
+	tests := []struct {
+		op       OperatorInt
+		actual   interface{}
+		expected interface{}
+		result   bool
+	}{
+		{OpIntEQ, int64(5), int64(5), true},
+		{OpIntEQ, 5, float64(5), true},
+		{OpIntNE, int64(5), int64(6), true},
+		{OpIntLT, int64(5), int64(6), true},
+		{OpIntLT, int64(6), int64(6), false},
+		{OpIntLE, int64(6), int64(6), true},
+		{OpIntGT, 2.5, int64(2), true},
+		{OpIntGE, int64(1), int64(2), false},
+		{OpIntLT | OpIntEQ, int64(2), int64(2), true},
+		{OpIntEQ, "bob", "bob", true},
+		{OpIntLT, "alice", "bob", true},
+		{OpIntEQ, true, true, true},
+		{OpIntNE, true, false, true},
+		{OpIntLT, false, true, false},
+		{OpIntEQ, "5", int64(5), false},
+		{OpIntNE, "5", int64(5), false},
+		{OpIntContains, []ARVariable{{T: "string", Val: "sword"}, {T: "int", Val: int64(3)}}, "sword", true},
+		{OpIntContains, []ARVariable{{T: "string", Val: "sword"}, {T: "int", Val: int64(3)}}, float64(3), true},
+		{OpIntContains, []ARVariable{{T: "string", Val: "sword"}}, "shield", false},
+		{OpIntContains, "sword", "sword", false},
+		{OpIntStartsWith, "Arjuna the Archer", "arjuna", true},
+		{OpIntStartsWith, "Arjuna the Archer", "archer", false},
+		{OpIntContainsText, "Arjuna the Archer", "ARCHER", true},
+		{OpIntContainsText, int64(5), "5", false},
+		{OpIntBetween, int64(5), []interface{}{int64(1), float64(5)}, true},
+		{OpIntBetween, 0.5, []interface{}{int64(1), int64(5)}, false},
+		{OpIntBetween, "m", []interface{}{"a", "n"}, true},
+		{OpIntBetween, true, []interface{}{false, true}, false},
+		{OpIntBetween, int64(5), []interface{}{int64(1)}, false},
+		{OpIntBetween | OpIntEQ, int64(9), int64(9), true},
+	}
+
+	for _, test := range tests {
+		if compareOperator(test.op, test.actual, test.expected) != test.result {
+			t.Errorf("compareOperator(%v, %#v, %#v) expected %v",
+				test.op, test.actual, test.expected, test.result)
+		}
+	}
+}
+
+func TestEvaluateStateOperators(t *testing.T) {
+	zone := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	visited := uuid.FromStringOrNil("6ba7b812-9dad-11d1-80b4-00c04fd430c8")
+	actor := "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+	state := MutableAIRequestState{
+		Zone:            zone,
+		ZoneActors:      map[uuid.UUID][]string{zone: {actor}, visited: {"elsewhere"}},
+		ZoneInitialized: map[uuid.UUID]bool{zone: true, visited: true, uuid.Nil: false},
+		ARVariables: map[string]*ARVariable{
+			"1": {T: "string", Val: "Arjuna"},
+			"2": {T: "array", Val: []ARVariable{{T: "string", Val: "sword"}}},
+		},
+	}
+
+	tests := []struct {
+		and    AndGroup
+		result bool
+	}{
+		{AndGroup{OpStrExists: VarValMap{1: true}}, true},
+		{AndGroup{OpStrExists: VarValMap{3: true}}, false},
+		{AndGroup{OpStrExists: VarValMap{3: false}}, true},
+		{AndGroup{OpStrExists: VarValMap{1: false}}, false},
+		{AndGroup{OpStrExists: VarValMap{1: "yes"}}, false},
+		{AndGroup{OpStrZoneVisited: VarValMap{0: visited.String()}}, true},
+		{AndGroup{OpStrZoneVisited: VarValMap{0: uuid.Nil.String()}}, false},
+		{AndGroup{OpStrZoneVisited: VarValMap{0: "not a zone"}}, false},
+		{AndGroup{OpStrActorPresent: VarValMap{0: actor}}, true},
+		{AndGroup{OpStrActorPresent: VarValMap{0: "elsewhere"}}, false},
+		{AndGroup{OpStrContains: VarValMap{2: "sword"}, OpStrStartsWith: VarValMap{1: "ARJ"}}, true},
+		{AndGroup{OpStrContains: VarValMap{2: "shield"}, OpStrStartsWith: VarValMap{1: "ARJ"}}, false},
+		{AndGroup{OpStrBetween: VarValMap{1: []interface{}{"A", "B"}}}, true},
+	}
+
+	for i, test := range tests {
+		group := OrGroup{test.and}
+		if group.EvaluateState(&state) != test.result {
+			t.Errorf("Case %v: expected %v", i, test.result)
+		}
+
+		// The compiled condition must yield the same result
+		compiled := mustCompileOrGroup(t, &group)
+		r := utilities.NewByteReader(compiled)
+		eval, err := evaluateCompiledOrGroup(r, &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eval != test.result {
+			t.Errorf("Case %v: expected the compiled condition to yield %v", i, test.result)
+		}
+		decoded, err := readCompiledOrGroup(utilities.NewByteReader(compiled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*decoded, group) {
+			t.Errorf("Case %v: expected %#v, decoded %#v", i, group, *decoded)
+		}
+	}
+}
+
+func TestCompiledOperatorEncoding(t *testing.T) {
+	// Operators compiled before OperatorInt was widened were a single byte
+	legacy := []byte{1, 1, byte(OpIntGT), 1, 1, 0, '1', compiledValueInt, 100, 0, 0, 0, 0, 0, 0, 0}
+	group := OrGroup{AndGroup{OpStrGT: VarValMap{1: int64(100)}}}
+	if compiled := mustCompileOrGroup(t, &group); !reflect.DeepEqual(compiled, legacy) {
+		t.Errorf("Expected %v, compiled %v", legacy, compiled)
+	}
+
+	compiled := mustCompileOrGroup(t, &OrGroup{AndGroup{OpStrActorPresent: VarValMap{0: "a"}}})
+	if compiled[2] != 0x80 || compiled[3] != 0x40 {
+		t.Errorf("Expected a 2 byte varint operator, compiled %v", compiled[2:4])
+	}
+
+	for _, op := range [][]byte{{0x03}, {0x80, 0x80, 0x01}, {0x80}} {
+		r := utilities.NewByteReader(append([]byte{1, 1}, op...))
+		if _, err := evaluateCompiledOrGroup(r, &MutableAIRequestState{}); err == nil {
+			t.Errorf("Expected an error for the operator %v", op)
+		}
+	}
+
+	if condition := describeOrGroup(&OrGroup{AndGroup{
+		OpStrBetween: VarValMap{1: []interface{}{int64(1), int64(5)}},
+		OpStrExists:  VarValMap{2: false},
+	}}); condition != "!exists($2) && $1 between 1 and 5" {
+		t.Errorf("Unexpected condition %v", condition)
+	}
+}
+
+// benchmarkTurn stores a typical dialog node: an unconditional greeting,
+// and two statement chains which play a sound and update a variable
+func benchmarkTurn(b *testing.B) (*AIRequest, []byte) {
+	store := redis.NewMemoryStore()
+	redis.Runtime = store
+
+	greeting := KeynavCompiledDialogNodeActionBundle("1", "greet", 0)
+	rich := KeynavCompiledDialogNodeActionBundle("1", "greet", 1)
+	poor := KeynavCompiledDialogNodeActionBundle("1", "greet", 2)
+	store.Set(greeting, CompileActionBundle(&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "Welcome back"}))
+	store.Set(rich, CompileActionBundle(&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "You look wealthy"}))
+	store.Set(poor, CompileActionBundle(&RASetVariable{Target: "2", Operation: SVOSet, With: ParametizedARVariable{
+		ARVariable: &ARVariable{T: "bool", Val: true},
+	}}))
+
+	statements := [][]LStatement{
+		{
+			{Exec: rich, Operators: &OrGroup{AndGroup{OpStrGT: VarValMap{1: int64(100)}}}},
+			{Exec: poor},
+		},
+		{
+			{Exec: rich, Operators: &OrGroup{AndGroup{OpStrEQ: VarValMap{2: true}}}},
+		},
+	}
+	compiled := mustCompile(b, LBlock{AlwaysExec: greeting, Statements: &statements})
+
+	message := &AIRequest{State: MutableAIRequestState{ARVariables: map[string]*ARVariable{
+		"1": {T: "int", Val: int64(50)},
+		"2": {T: "bool", Val: false},
+	}}}
+	return message, compiled
+}
+
+func BenchmarkLogicEval(b *testing.B) {
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	message, compiled := benchmarkTurn(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		message.OutputSSML = ssml.NewBuilder()
+		message.State.ARVariables["2"].Val = false
+		if err := evalLogicBlock(message, "", compiled); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLogicLazyEval(b *testing.B) {
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	message, compiled := benchmarkTurn(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		message.OutputSSML = ssml.NewBuilder()
+		message.State.ARVariables["2"].Val = false
+		stateComms := make(chan AIRequest)
+		results := LogicLazyEval(stateComms, compiled)
+		for done := false; !done; {
+			select {
+			case res, ok := <-results:
+				if !ok {
+					done = true
+					break
+				}
+				if res.Error != nil {
+					b.Fatal(res.Error)
+				}
+				if res.Value != "" {
+					if err := ActionBundleEvalKey(message, res.Value); err != nil {
+						b.Fatal(err)
+					}
+				}
+			case stateComms <- *message:
+			}
+		}
+	}
+}
@@ -9,4 +9,13 @@ const (
 	PublishStatusProblem
 	PublishStatusUnderReview
 	PublishStatusDenied
+	// PublishStatusAwaitingReview is submitted for review without any reviewer assigned
+	PublishStatusAwaitingReview
+	// PublishStatusApproved has reached a quorum of approvals and is ready to publish
+	PublishStatusApproved
+	// PublishStatusReviewDisputed has every assigned review without reaching a quorum
+	// Another reviewer must be assigned
+	PublishStatusReviewDisputed
+	// PublishStatusAppealed is Denied, and the denial is appealed and awaits another round of review
+	PublishStatusAppealed
 )
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/talkative-ai/core/common"
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// playRandomTurns executes a RAChooseSound and an SVORandomInt on each turn
+// The state is saved and reloaded between turns, as Brahman does between requests
+func playRandomTurns(t *testing.T, state MutableAIRequestState, turns int) (string, []int64) {
+	choice := RAChooseSound{Key: "greeting", Variants: chooseVariants("a", "b", "c", "d", "e")}
+	roll := RASetVariable{Target: "roll", Operation: SVORandomInt, With: ParametizedARVariable{
+		Params: map[string]interface{}{"Min": int64(1), "Max": int64(20)},
+	}}
+
+	output := ""
+	rolls := []int64{}
+	for i := 0; i < turns; i++ {
+		message := AIRequest{State: state, OutputSSML: ssml.NewBuilder()}
+		if err := choice.Execute(&message); err != nil {
+			t.Fatal(err)
+		}
+		if err := roll.Execute(&message); err != nil {
+			t.Fatal(err)
+		}
+		output += message.OutputSSML.String()
+		rolls = append(rolls, message.State.ARVariables["roll"].Get().(int64))
+
+		saved, err := json.Marshal(message.State)
+		if err != nil {
+			t.Fatal(err)
+		}
+		state = MutableAIRequestState{}
+		if err := json.Unmarshal(saved, &state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return output, rolls
+}
+
+func TestSessionRandomReplay(t *testing.T) {
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = redis.NewMemoryStore()
+
+	newState := func(sessionID uuid.UUID, rand *common.SessionRand) MutableAIRequestState {
+		return MutableAIRequestState{
+			SessionID:   sessionID,
+			ARVariables: map[string]*ARVariable{"roll": {T: "int", Val: int64(0)}},
+			Rand:        rand,
+		}
+	}
+	session := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	other := uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+
+	// The same session produces the same output across saved and reloaded states
+	output, rolls := playRandomTurns(t, newState(session, nil), 20)
+	replayOutput, replayRolls := playRandomTurns(t, newState(session, nil), 20)
+	if output != replayOutput || !reflect.DeepEqual(rolls, replayRolls) {
+		t.Errorf("Expected session %v to replay identically", session)
+	}
+	for _, roll := range rolls {
+		if roll < 1 || roll > 20 {
+			t.Fatalf("Expected rolls within [1, 20], received %v", rolls)
+		}
+	}
+
+	otherOutput, otherRolls := playRandomTurns(t, newState(other, nil), 20)
+	if output == otherOutput && reflect.DeepEqual(rolls, otherRolls) {
+		t.Errorf("Expected sessions %v and %v to differ", session, other)
+	}
+
+	// An explicit seed takes precedence over the SessionID
+	seededOutput, seededRolls := playRandomTurns(t, newState(session, common.NewSessionRand(42)), 20)
+	otherSeededOutput, otherSeededRolls := playRandomTurns(t, newState(other, common.NewSessionRand(42)), 20)
+	if seededOutput != otherSeededOutput || !reflect.DeepEqual(seededRolls, otherSeededRolls) {
+		t.Errorf("Expected the same seed to replay identically across sessions")
+	}
+}
+
+func TestSessionRandChooseString(t *testing.T) {
+	list := []string{"Hello", "Hi", "Greetings"}
+	a := common.NewSessionRand(3)
+	b := common.NewSessionRand(3)
+	for i := 0; i < 10; i++ {
+		if x, y := a.ChooseString(list), b.ChooseString(list); x != y {
+			t.Fatalf("Expected %v and %v to match", x, y)
+		}
+	}
+	if s := a.ChooseString(nil); s != "" {
+		t.Errorf("Expected an empty string from an empty list, received %v", s)
+	}
+}
@@ -14,11 +14,14 @@ type ProjectReviewResult int
 const (
 	ProjectReviewResultApprove ProjectReviewResult = iota
 	ProjectReviewResultReject
+	// ProjectReviewResultPending is undecided, e.g. a review drafted by a Screener
+	ProjectReviewResultPending
 )
 
 type ProjectReview struct {
 	ProjectID     uuid.UUID
 	Version       int64
+	Round         int
 	Reviewer      string
 	BadTitle      bool
 	Result        ProjectReviewResult
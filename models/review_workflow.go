@@ -0,0 +1,251 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-gorp/gorp"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// Default number of matching reviews required to decide a version
+const (
+	DefaultRequiredApprovals  = 2
+	DefaultRequiredRejections = 2
+)
+
+// ReviewWorkflow is the review state of a single version of a project
+// Assignments, Reviews, Comments and Transitions are stored within their own tables
+type ReviewWorkflow struct {
+	ProjectID uuid.UUID
+	Version   int64
+	Status    PublishStatus
+	// Round is 0 for the first review and 1 for the review of an appeal
+	Round int
+	// RequiredApprovals and RequiredRejections are the quorum of matching reviews within a round
+	RequiredApprovals  int
+	RequiredRejections int
+
+	Assignments []ReviewAssignment `db:"-"`
+	Reviews     []ProjectReview    `db:"-"`
+	Comments    []ReviewComment    `db:"-"`
+	Transitions []ReviewTransition `db:"-"`
+}
+
+// ReviewAssignment assigns a reviewer to a round of review of a version
+type ReviewAssignment struct {
+	ProjectID   uuid.UUID
+	Version     int64
+	Round       int
+	Reviewer    string
+	AssignedBy  string
+	AssignedAt  gorp.NullTime `json:"AssignedAt,omitempty"`
+	CompletedAt gorp.NullTime `json:"CompletedAt,omitempty"`
+}
+
+func (m *ReviewAssignment) PreInsert(s gorp.SqlExecutor) error {
+	m.AssignedAt.Time = time.Now()
+	m.AssignedAt.Valid = true
+	return nil
+}
+
+// ReviewComment is a reviewer comment on a dialog node of a version
+type ReviewComment struct {
+	Model
+	ProjectID uuid.UUID
+	Version   int64
+	Round     int
+	Reviewer  string
+	DialogID  uuid.UUID
+	Text      string
+}
+
+// ReviewTransition logs a change of the PublishStatus of a version
+type ReviewTransition struct {
+	Model
+	ProjectID uuid.UUID
+	Version   int64
+	Round     int
+	From      PublishStatus
+	To        PublishStatus
+	// Actor is the reviewer or user who caused the transition
+	Actor  string
+	Reason string `json:",omitempty"`
+}
+
+// NewReviewWorkflow creates the workflow of a version which is not yet submitted for review
+func NewReviewWorkflow(projectID uuid.UUID, version int64) *ReviewWorkflow {
+	return &ReviewWorkflow{
+		ProjectID:          projectID,
+		Version:            version,
+		Status:             PublishStatusNotPublished,
+		RequiredApprovals:  DefaultRequiredApprovals,
+		RequiredRejections: DefaultRequiredRejections,
+	}
+}
+
+// Submit submits the version for review
+func (w *ReviewWorkflow) Submit(actor string) error {
+	if w.Status != PublishStatusNotPublished && w.Status != PublishStatusProblem {
+		return fmt.Errorf("Unable to submit a version with status %v", w.Status)
+	}
+	w.transition(PublishStatusAwaitingReview, actor, "")
+	return nil
+}
+
+// Assign assigns the reviewer to the current round of review
+func (w *ReviewWorkflow) Assign(reviewer, actor string) error {
+	switch w.Status {
+	case PublishStatusAwaitingReview, PublishStatusAppealed, PublishStatusUnderReview, PublishStatusReviewDisputed:
+	default:
+		return fmt.Errorf("Unable to assign a reviewer to a version with status %v", w.Status)
+	}
+	if reviewer == "" {
+		return fmt.Errorf("Missing reviewer")
+	}
+	if w.assignment(reviewer) != nil {
+		return fmt.Errorf("%v is already assigned", reviewer)
+	}
+
+	w.Assignments = append(w.Assignments, ReviewAssignment{
+		ProjectID:  w.ProjectID,
+		Version:    w.Version,
+		Round:      w.Round,
+		Reviewer:   reviewer,
+		AssignedBy: actor,
+	})
+	if w.Status != PublishStatusUnderReview {
+		w.transition(PublishStatusUnderReview, actor, fmt.Sprintf("Assigned %v", reviewer))
+	}
+	return nil
+}
+
+// AddReview records the review of an assigned reviewer
+// The version is Approved or Denied once either quorum is reached within the round,
+// and disputed if every assigned reviewer has reviewed without reaching a quorum
+func (w *ReviewWorkflow) AddReview(review ProjectReview) error {
+	if w.Status != PublishStatusUnderReview {
+		return fmt.Errorf("Unable to review a version with status %v", w.Status)
+	}
+	if review.ProjectID != w.ProjectID || review.Version != w.Version {
+		return fmt.Errorf("Expected a review of %v version %v", w.ProjectID, w.Version)
+	}
+	if review.Result != ProjectReviewResultApprove && review.Result != ProjectReviewResultReject {
+		return fmt.Errorf("Expected the review to approve or reject, received %v", review.Result)
+	}
+	assignment := w.assignment(review.Reviewer)
+	if assignment == nil {
+		return fmt.Errorf("%v is not assigned", review.Reviewer)
+	}
+	if assignment.CompletedAt.Valid {
+		return fmt.Errorf("%v has already reviewed", review.Reviewer)
+	}
+
+	assignment.CompletedAt = gorp.NullTime{Time: time.Now(), Valid: true}
+	review.Round = w.Round
+	if !review.ReviewedAt.Valid {
+		review.ReviewedAt = assignment.CompletedAt
+	}
+	w.Reviews = append(w.Reviews, review)
+
+	approvals, rejections := 0, 0
+	for _, r := range w.Reviews {
+		if r.Round != w.Round {
+			continue
+		}
+		switch r.Result {
+		case ProjectReviewResultApprove:
+			approvals++
+		case ProjectReviewResultReject:
+			rejections++
+		}
+	}
+
+	switch {
+	case approvals >= w.RequiredApprovals:
+		w.transition(PublishStatusApproved, review.Reviewer, fmt.Sprintf("%v of %v approved", approvals, approvals+rejections))
+	case rejections >= w.RequiredRejections:
+		w.transition(PublishStatusDenied, review.Reviewer, fmt.Sprintf("%v of %v rejected", rejections, approvals+rejections))
+	case w.pending() == 0:
+		w.transition(PublishStatusReviewDisputed, review.Reviewer,
+			fmt.Sprintf("%v approved and %v rejected without a quorum", approvals, rejections))
+	}
+	return nil
+}
+
+// Comment attaches a comment of an assigned reviewer to a dialog node
+func (w *ReviewWorkflow) Comment(reviewer string, dialogID uuid.UUID, text string) error {
+	if w.Status != PublishStatusUnderReview && w.Status != PublishStatusReviewDisputed {
+		return fmt.Errorf("Unable to comment on a version with status %v", w.Status)
+	}
+	if w.assignment(reviewer) == nil {
+		return fmt.Errorf("%v is not assigned", reviewer)
+	}
+	if dialogID == uuid.Nil {
+		return fmt.Errorf("Missing dialog node")
+	}
+	if text == "" {
+		return fmt.Errorf("Missing comment")
+	}
+
+	w.Comments = append(w.Comments, ReviewComment{
+		ProjectID: w.ProjectID,
+		Version:   w.Version,
+		Round:     w.Round,
+		Reviewer:  reviewer,
+		DialogID:  dialogID,
+		Text:      text,
+	})
+	return nil
+}
+
+// Appeal appeals a denial, which starts another round of review
+// A version may only be appealed once
+func (w *ReviewWorkflow) Appeal(actor, reason string) error {
+	if w.Status != PublishStatusDenied {
+		return fmt.Errorf("Unable to appeal a version with status %v", w.Status)
+	}
+	if w.Round > 0 {
+		return fmt.Errorf("The version has already been appealed")
+	}
+	if reason == "" {
+		return fmt.Errorf("Missing reason for the appeal")
+	}
+	w.Round++
+	w.transition(PublishStatusAppealed, actor, reason)
+	return nil
+}
+
+// assignment returns the assignment of the reviewer within the current round
+func (w *ReviewWorkflow) assignment(reviewer string) *ReviewAssignment {
+	for i := range w.Assignments {
+		if w.Assignments[i].Round == w.Round && w.Assignments[i].Reviewer == reviewer {
+			return &w.Assignments[i]
+		}
+	}
+	return nil
+}
+
+// pending returns the number of assigned reviewers who have not reviewed within the current round
+func (w *ReviewWorkflow) pending() int {
+	n := 0
+	for _, a := range w.Assignments {
+		if a.Round == w.Round && !a.CompletedAt.Valid {
+			n++
+		}
+	}
+	return n
+}
+
+func (w *ReviewWorkflow) transition(to PublishStatus, actor, reason string) {
+	w.Transitions = append(w.Transitions, ReviewTransition{
+		ProjectID: w.ProjectID,
+		Version:   w.Version,
+		Round:     w.Round,
+		From:      w.Status,
+		To:        to,
+		Actor:     actor,
+		Reason:    reason,
+	})
+	w.Status = to
+}
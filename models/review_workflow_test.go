@@ -0,0 +1,156 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func workflowStatuses(w *ReviewWorkflow) []PublishStatus {
+	statuses := []PublishStatus{}
+	for _, t := range w.Transitions {
+		statuses = append(statuses, t.To)
+	}
+	return statuses
+}
+
+func TestReviewWorkflow(t *testing.T) {
+	w := NewReviewWorkflow(validateID(0), 4)
+	review := func(reviewer string, result ProjectReviewResult) error {
+		return w.AddReview(ProjectReview{ProjectID: w.ProjectID, Version: w.Version, Reviewer: reviewer, Result: result})
+	}
+
+	if err := w.Assign("ana@talkative.ai", "lead@talkative.ai"); err == nil {
+		t.Error("Expected an error assigning a reviewer before the version is submitted")
+	}
+	if err := w.Submit("creator"); err != nil {
+		t.Fatal(err)
+	}
+	for _, reviewer := range []string{"ana@talkative.ai", "ben@talkative.ai"} {
+		if err := w.Assign(reviewer, "lead@talkative.ai"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Assign("ben@talkative.ai", "lead@talkative.ai"); err == nil {
+		t.Error("Expected an error assigning the same reviewer twice")
+	}
+
+	if err := w.Comment("ana@talkative.ai", validateID(11), "Mentions a casino"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Comment("eve@talkative.ai", validateID(11), "Unassigned"); err == nil {
+		t.Error("Expected an error commenting as an unassigned reviewer")
+	}
+	if err := review("eve@talkative.ai", ProjectReviewResultApprove); err == nil {
+		t.Error("Expected an error reviewing as an unassigned reviewer")
+	}
+	// A draft of the Screener is not a decision
+	if err := review("ana@talkative.ai", ProjectReviewResultPending); err == nil {
+		t.Error("Expected an error reviewing without a decision")
+	}
+
+	// A split round is disputed until another reviewer is assigned
+	if err := review("ana@talkative.ai", ProjectReviewResultReject); err != nil {
+		t.Fatal(err)
+	}
+	if err := review("ana@talkative.ai", ProjectReviewResultReject); err == nil {
+		t.Error("Expected an error reviewing twice")
+	}
+	if err := review("ben@talkative.ai", ProjectReviewResultApprove); err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != PublishStatusReviewDisputed {
+		t.Fatalf("Expected the review to be disputed, received %v", w.Status)
+	}
+	if err := w.Assign("cam@talkative.ai", "lead@talkative.ai"); err != nil {
+		t.Fatal(err)
+	}
+	if err := review("cam@talkative.ai", ProjectReviewResultReject); err != nil {
+		t.Fatal(err)
+	}
+	if w.Status != PublishStatusDenied {
+		t.Fatalf("Expected the version to be denied, received %v", w.Status)
+	}
+
+	// The appeal is reviewed by a new round of reviewers
+	if err := w.Appeal("creator", ""); err == nil {
+		t.Error("Expected an error appealing without a reason")
+	}
+	if err := w.Appeal("creator", "The casino is a museum"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Assign("ana@talkative.ai", "lead@talkative.ai"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Assign("dee@talkative.ai", "lead@talkative.ai"); err != nil {
+		t.Fatal(err)
+	}
+	for _, reviewer := range []string{"ana@talkative.ai", "dee@talkative.ai"} {
+		if err := review(reviewer, ProjectReviewResultApprove); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []PublishStatus{
+		PublishStatusAwaitingReview,
+		PublishStatusUnderReview,
+		PublishStatusReviewDisputed,
+		PublishStatusUnderReview,
+		PublishStatusDenied,
+		PublishStatusAppealed,
+		PublishStatusUnderReview,
+		PublishStatusApproved,
+	}
+	if received := workflowStatuses(w); !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Expected the transitions %v, received %v", expected, received)
+	}
+	for i, transition := range w.Transitions[1:] {
+		if transition.From != w.Transitions[i].To {
+			t.Errorf("Transition %v: expected from %v, received %v", i+1, w.Transitions[i].To, transition.From)
+		}
+	}
+	last := w.Transitions[len(w.Transitions)-1]
+	if last.Actor != "dee@talkative.ai" || last.Round != 1 || last.Reason != "2 of 2 approved" {
+		t.Errorf("Unexpected transition %+v", last)
+	}
+
+	if len(w.Reviews) != 5 || w.Reviews[2].Round != 0 || w.Reviews[3].Round != 1 || !w.Reviews[3].ReviewedAt.Valid {
+		t.Errorf("Unexpected reviews %+v", w.Reviews)
+	}
+	if len(w.Comments) != 1 || w.Comments[0].DialogID != validateID(11) {
+		t.Errorf("Unexpected comments %+v", w.Comments)
+	}
+	if err := w.Comment("ana@talkative.ai", validateID(11), "Too late"); err == nil {
+		t.Error("Expected an error commenting on an approved version")
+	}
+}
+
+func TestReviewWorkflowAppealOnce(t *testing.T) {
+	w := NewReviewWorkflow(validateID(0), 1)
+	w.RequiredRejections = 1
+	steps := []func() error{
+		func() error { return w.Submit("creator") },
+		func() error { return w.Assign("ana@talkative.ai", "lead@talkative.ai") },
+		func() error {
+			return w.AddReview(ProjectReview{ProjectID: w.ProjectID, Version: w.Version, Reviewer: "ana@talkative.ai", Result: ProjectReviewResultReject})
+		},
+		func() error { return w.Appeal("creator", "Please reconsider") },
+		func() error { return w.Assign("ben@talkative.ai", "lead@talkative.ai") },
+		func() error {
+			return w.AddReview(ProjectReview{ProjectID: w.ProjectID, Version: w.Version, Reviewer: "ben@talkative.ai", Result: ProjectReviewResultReject})
+		},
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("Step %v: %s", i, err.Error())
+		}
+	}
+	if w.Status != PublishStatusDenied {
+		t.Fatalf("Expected the appeal to be denied, received %v", w.Status)
+	}
+	if err := w.Appeal("creator", "Please reconsider again"); err == nil {
+		t.Error("Expected an error appealing twice")
+	}
+	if err := w.AddReview(ProjectReview{ProjectID: w.ProjectID, Version: 2, Reviewer: "ben@talkative.ai"}); err == nil {
+		t.Error("Expected an error reviewing another version")
+	}
+}
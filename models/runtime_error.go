@@ -0,0 +1,128 @@
+package models
+
+import (
+	"fmt"
+	"log"
+)
+
+// RuntimeErrorCode classifies a RuntimeError
+type RuntimeErrorCode int
+
+const (
+	// RuntimeErrorInvalidType a value did not have the type expected by the action
+	RuntimeErrorInvalidType RuntimeErrorCode = iota
+	// RuntimeErrorMissingVariable an ARVariable referenced by the action does not exist
+	RuntimeErrorMissingVariable
+	// RuntimeErrorInvalidParam an action parameter is missing or out of range
+	RuntimeErrorInvalidParam
+	// RuntimeErrorMalformed a compiled action or bundle could not be decoded
+	RuntimeErrorMalformed
+	// RuntimeErrorStore a compiled binary could not be fetched from Redis
+	RuntimeErrorStore
+	// RuntimeErrorLogic a compiled logic block could not be evaluated
+	RuntimeErrorLogic
+	// RuntimeErrorUnsupportedAction no action has been registered with the ActionID
+	RuntimeErrorUnsupportedAction
+	// RuntimeErrorNotFound a compiled entity referenced by the action does not exist
+	RuntimeErrorNotFound
+	// RuntimeErrorCascadeLimit variable update triggers exceeded MaxVariableUpdateDepth,
+	// or zone changes exceeded MaxZoneChangeDepth
+	RuntimeErrorCascadeLimit
+)
+
+// RuntimeError is returned by Brahman's runtime when a compiled action
+// or logic block fails. Instead of terminating the process,
+// the error is reported along with where it occurred
+type RuntimeError struct {
+	Code RuntimeErrorCode
+	// ActionID of the failing action. Not applicable to RuntimeErrorLogic
+	ActionID ActionID
+	// BundleKey is the Redis key of the ActionBundle or logic block
+	BundleKey string
+	// Offset is the byte offset of the failing action or statement
+	// within the compiled binary
+	Offset  uint64
+	Message string
+
+	// located is true once the ActionID, BundleKey and Offset have been set
+	// This prevents outer bundles from overwriting the location of an error
+	// that occurred within a nested bundle (e.g. triggers evaluated by RASetZone)
+	located bool
+	// handled is true once the ErrorPolicy has been applied to the error
+	// Outer bundles pass a handled error up rather than applying the policy again
+	handled bool
+}
+
+func (err *RuntimeError) Error() string {
+	if err.Code == RuntimeErrorLogic {
+		return fmt.Sprintf("[RuntimeError %v] logic block %q at byte %v: %s",
+			err.Code, err.BundleKey, err.Offset, err.Message)
+	}
+	return fmt.Sprintf("[RuntimeError %v] action %v in bundle %q at byte %v: %s",
+		err.Code, err.ActionID, err.BundleKey, err.Offset, err.Message)
+}
+
+// newRuntimeError is a helper for RequestAction.Execute implementations
+func newRuntimeError(code RuntimeErrorCode, format string, args ...interface{}) *RuntimeError {
+	return &RuntimeError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// locate sets where the error occurred unless it has already been set
+func (err *RuntimeError) locate(actionID ActionID, bundleKey string, offset uint64) {
+	if err.located {
+		return
+	}
+	err.ActionID = actionID
+	err.BundleKey = bundleKey
+	err.Offset = offset
+	err.located = true
+}
+
+// RuntimeErrorDecision is returned by a RuntimeErrorPolicy
+type RuntimeErrorDecision int
+
+const (
+	// RuntimeErrorAbort stops the ActionBundle and returns the error
+	RuntimeErrorAbort RuntimeErrorDecision = iota
+	// RuntimeErrorSkip ignores the failing action and continues with the next
+	RuntimeErrorSkip
+	// RuntimeErrorApologize stops the ActionBundle and appends RuntimeErrorApology
+	// to the OutputSSML. The error is considered handled
+	RuntimeErrorApologize
+)
+
+// RuntimeErrorPolicy decides how an ActionBundle proceeds when one of its actions fails
+type RuntimeErrorPolicy func(*AIRequest, *RuntimeError) RuntimeErrorDecision
+
+// DefaultRuntimeErrorPolicy is used when the AIRequest has no ErrorPolicy
+var DefaultRuntimeErrorPolicy RuntimeErrorPolicy = func(*AIRequest, *RuntimeError) RuntimeErrorDecision {
+	return RuntimeErrorAbort
+}
+
+// RuntimeErrorApology is spoken when a RuntimeErrorPolicy yields RuntimeErrorApologize
+var RuntimeErrorApology = "Sorry, something went wrong. Please try again."
+
+// handleRuntimeError applies the ErrorPolicy of the AIRequest to err
+// Returns whether the ActionBundle should continue
+// and the error which should be passed up, if any
+func (message *AIRequest) handleRuntimeError(err *RuntimeError) (bool, error) {
+	err.handled = true
+	policy := message.ErrorPolicy
+	if policy == nil {
+		policy = DefaultRuntimeErrorPolicy
+	}
+
+	switch policy(message, err) {
+	case RuntimeErrorSkip:
+		log.Println("[WARNING] Skipping failed action", err)
+		return true, nil
+	case RuntimeErrorApologize:
+		message.OutputSSML = message.OutputSSML.Paragraph(RuntimeErrorApology)
+		return false, nil
+	default:
+		return false, err
+	}
+}
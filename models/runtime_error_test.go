@@ -0,0 +1,160 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// legacyBundleActions mirrors Lakshmi's headerless bundling of compiled actions
+func legacyBundleActions(actions ...RequestAction) []byte {
+	bundle := []byte{}
+	for _, action := range actions {
+		compiled := action.Compile()
+		header := make([]byte, 12)
+		binary.LittleEndian.PutUint64(header, uint64(action.GetRAID()))
+		binary.LittleEndian.PutUint32(header[8:], uint32(len(compiled)))
+		bundle = append(bundle, header...)
+		bundle = append(bundle, compiled...)
+	}
+	return bundle
+}
+
+func TestRuntimeErrorPolicy(t *testing.T) {
+	first := &RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "Hello"}
+	failing := &RASetVariable{Target: "missing", Operation: SVONot}
+	last := &RAPlaySound{SoundType: RAPlaySoundTypeText, Val: " world"}
+	bundle := CompileActionBundle(first, failing, last)
+	failingOffset := uint64(ActionBundleHeaderSize + 12 + len(first.Compile()))
+
+	tests := []struct {
+		decision RuntimeErrorDecision
+		output   string
+		err      bool
+	}{
+		{RuntimeErrorAbort, "<speak>Hello</speak>", true},
+		{RuntimeErrorSkip, "<speak>Hello world</speak>", false},
+		{RuntimeErrorApologize, "<speak>Hello" + RuntimeErrorApology + "</speak>", false},
+	}
+
+	for _, test := range tests {
+		var received *RuntimeError
+		decision := test.decision
+		state := AIRequest{
+			OutputSSML: ssml.NewBuilder(),
+			ErrorPolicy: func(_ *AIRequest, err *RuntimeError) RuntimeErrorDecision {
+				received = err
+				return decision
+			},
+		}
+
+		err := ActionBundleEval(&state, bundle)
+		if (err != nil) != test.err {
+			t.Errorf("Decision %v: unexpected error %v", test.decision, err)
+		}
+		if received == nil {
+			t.Fatalf("Decision %v: the policy was not called", test.decision)
+		}
+		if received.Code != RuntimeErrorMissingVariable ||
+			received.ActionID != RAIDSetARVariable ||
+			received.Offset != failingOffset {
+			t.Errorf("Decision %v: unexpected RuntimeError %+v", test.decision, received)
+		}
+		if state.OutputSSML.String() != test.output {
+			t.Errorf("Decision %v: unexpected output %v", test.decision, state.OutputSSML.String())
+		}
+	}
+}
+
+func TestRuntimeErrorDefaultPolicy(t *testing.T) {
+	state := AIRequest{
+		State: MutableAIRequestState{
+			ARVariables: map[string]*ARVariable{
+				"gold": {T: "int", Val: int64(10)},
+				"name": {T: "string", Val: "Arjuna"},
+			},
+		},
+		OutputSSML: ssml.NewBuilder(),
+	}
+
+	tests := []struct {
+		action RequestAction
+		code   RuntimeErrorCode
+	}{
+		{&RASetVariable{Target: "gold", Operation: SVONot}, RuntimeErrorInvalidType},
+		{&RASetVariable{Target: "gold", Operation: SVODivide, With: ParametizedARVariable{
+			ARVariable: &ARVariable{T: "int", Val: int64(0)},
+		}}, RuntimeErrorInvalidParam},
+		{&RASetVariable{Target: "name", Operation: SVOReplace}, RuntimeErrorInvalidParam},
+		{&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "{{silver}}"}, RuntimeErrorMissingVariable},
+	}
+
+	for _, test := range tests {
+		err := ActionBundleEval(&state, CompileActionBundle(test.action))
+		rerr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Errorf("Expected a RuntimeError, received %v", err)
+			continue
+		}
+		if rerr.Code != test.code || rerr.ActionID != test.action.GetRAID() {
+			t.Errorf("Unexpected RuntimeError %v", rerr)
+		}
+	}
+}
+
+func TestRuntimeErrorPolicyNestedBundle(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	pubID := "1"
+	missing := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	malformed := uuid.FromStringOrNil("6ba7b811-9dad-11d1-80b4-00c04fd430c8")
+	missingKey := KeynavCompiledTriggerActionBundle(pubID, missing.String(), uint64(TriggerEnterZone), 0)
+	malformedKey := KeynavCompiledTriggerActionBundle(pubID, malformed.String(), uint64(TriggerEnterZone), 0)
+	store.Set(malformedKey, append(append([]byte{}, ActionBundleMagic...), 1, 2))
+	for zoneID, key := range map[uuid.UUID]string{missing: missingKey, malformed: malformedKey} {
+		store.HSet(KeynavCompiledTriggersWithinZone(pubID, zoneID.String()),
+			fmt.Sprintf("%v", TriggerEnterZone), mustCompile(t, LBlock{AlwaysExec: key}))
+	}
+
+	tests := []struct {
+		zone uuid.UUID
+		key  string
+		code RuntimeErrorCode
+	}{
+		{missing, missingKey, RuntimeErrorStore},
+		{malformed, malformedKey, RuntimeErrorMalformed},
+	}
+	for _, test := range tests {
+		setZone := RASetZone(test.zone)
+		bundle := CompileActionBundle(
+			&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "Hello"},
+			&setZone,
+			&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: " world"},
+		)
+
+		received := []*RuntimeError{}
+		state := AIRequest{
+			State:      MutableAIRequestState{PubID: pubID},
+			OutputSSML: ssml.NewBuilder(),
+			ErrorPolicy: func(_ *AIRequest, err *RuntimeError) RuntimeErrorDecision {
+				received = append(received, err)
+				return RuntimeErrorSkip
+			},
+		}
+		if err := ActionBundleEval(&state, bundle); err != nil {
+			t.Errorf("%v: expected the nested error to be skipped, received %v", test.key, err)
+		}
+		if len(received) != 1 || received[0].Code != test.code || received[0].BundleKey != test.key {
+			t.Errorf("%v: expected the policy to be applied once, received %+v", test.key, received)
+		}
+		if output := state.OutputSSML.String(); output != "<speak>Hello world</speak>" {
+			t.Errorf("%v: unexpected output %v", test.key, output)
+		}
+	}
+}
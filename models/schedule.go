@@ -0,0 +1,141 @@
+package models
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	utilities "github.com/talkative-ai/core"
+)
+
+// timeNow is the wall clock of the runtime. Tests may replace it
+var timeNow = time.Now
+
+// Schedule is an ActionBundle waiting to be evaluated at the start of a later turn
+type Schedule struct {
+	// Name identifies the schedule. Scheduling the same Name again replaces it
+	Name      string
+	BundleKey string
+	// DueTurn is the turn at which the schedule fires, or 0
+	DueTurn uint64 `json:",omitempty"`
+	// DueAt is the time after which the schedule fires, or the zero time
+	DueAt time.Time `json:",omitempty"`
+}
+
+// due returns true if the schedule fires at the turn and time
+func (s Schedule) due(turn uint64, now time.Time) bool {
+	if s.DueTurn != 0 && turn >= s.DueTurn {
+		return true
+	}
+	return !s.DueAt.IsZero() && !now.Before(s.DueAt)
+}
+
+// beginTurn advances the turn counter and fires every due Schedule in the order they were scheduled
+// A fired Schedule is removed before its ActionBundle is evaluated, so the bundle may schedule itself again
+func beginTurn(message *AIRequest) error {
+	now := timeNow().UTC()
+	message.State.Turn++
+
+	for {
+		index := -1
+		for i, s := range message.State.Schedules {
+			if s.due(message.State.Turn, now) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			break
+		}
+		s := message.State.Schedules[index]
+		schedules := make([]Schedule, 0, len(message.State.Schedules)-1)
+		schedules = append(schedules, message.State.Schedules[:index]...)
+		message.State.Schedules = append(schedules, message.State.Schedules[index+1:]...)
+		if err := ActionBundleEvalKey(message, s.BundleKey); err != nil {
+			return err
+		}
+	}
+
+	message.State.LastInteraction = now
+	return nil
+}
+
+//////////////////
+// RASchedule //
+//////////////////
+
+// RASchedule schedules an ActionBundle to be evaluated Turns turns or Minutes minutes ahead
+// Exactly one of Turns and Minutes is expected
+type RASchedule struct {
+	Name      string
+	BundleKey string
+	Turns     uint32 `json:",omitempty"`
+	Minutes   uint32 `json:",omitempty"`
+}
+
+// GetRAID returns the ActionID of the current RequestAction
+func (ara *RASchedule) GetRAID() ActionID {
+	return RAIDSchedule
+}
+
+// Compile is used by Lakshmi
+// Returns the compiled []byte slice of the runtime action
+// To be stored in Redis
+//
+// The layout is as follows:
+// [2 bytes: Name length][Name][2 bytes: BundleKey length][BundleKey][4 bytes: Turns][4 bytes: Minutes]
+func (ara RASchedule) Compile() []byte {
+	compiled := appendCompiledString([]byte{}, ara.Name)
+	compiled = appendCompiledString(compiled, ara.BundleKey)
+	due := make([]byte, 8)
+	binary.LittleEndian.PutUint32(due, ara.Turns)
+	binary.LittleEndian.PutUint32(due[4:], ara.Minutes)
+	return append(compiled, due...)
+}
+
+// CreateFrom is used for evaluating the actions in Brahman and followed by Execute
+// This could be put in a single "Execute" but this is less monolothic
+func (ara *RASchedule) CreateFrom(bytes []byte) error {
+	r := utilities.NewByteReader(bytes)
+	var err error
+	if ara.Name, err = r.LengthPrefixedString(); err != nil {
+		return fmt.Errorf("Error reading schedule name: %s", err.Error())
+	}
+	if ara.BundleKey, err = r.LengthPrefixedString(); err != nil {
+		return fmt.Errorf("Error reading schedule bundle key: %s", err.Error())
+	}
+	if ara.Turns, err = r.Uint32(); err != nil {
+		return fmt.Errorf("Error reading schedule turns: %s", err.Error())
+	}
+	if ara.Minutes, err = r.Uint32(); err != nil {
+		return fmt.Errorf("Error reading schedule minutes: %s", err.Error())
+	}
+	return nil
+}
+
+// Execute will mutate the AIRequest in some way
+// Whether it's the state itself or the OutputSSML
+func (ara *RASchedule) Execute(message *AIRequest) error {
+	if ara.BundleKey == "" {
+		return newRuntimeError(RuntimeErrorInvalidParam, "Missing bundle key on schedule %q", ara.Name)
+	}
+	if (ara.Turns == 0) == (ara.Minutes == 0) {
+		return newRuntimeError(RuntimeErrorInvalidParam, "Expected either turns or minutes on schedule %q", ara.Name)
+	}
+
+	s := Schedule{Name: ara.Name, BundleKey: ara.BundleKey}
+	if ara.Turns > 0 {
+		s.DueTurn = message.State.Turn + uint64(ara.Turns)
+	} else {
+		s.DueAt = timeNow().UTC().Add(time.Duration(ara.Minutes) * time.Minute)
+	}
+
+	schedules := make([]Schedule, 0, len(message.State.Schedules)+1)
+	for _, existing := range message.State.Schedules {
+		if ara.Name == "" || existing.Name != ara.Name {
+			schedules = append(schedules, existing)
+		}
+	}
+	message.State.Schedules = append(schedules, s)
+	return nil
+}
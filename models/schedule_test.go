@@ -0,0 +1,129 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+func TestRAScheduleCompile(t *testing.T) {
+	schedule := RASchedule{Name: "shop", BundleKey: "c:v2:1:e:4:close", Turns: 10}
+	decoded := RASchedule{}
+	if err := decoded.CreateFrom(schedule.Compile()); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != schedule {
+		t.Errorf("Expected %+v, received %+v", schedule, decoded)
+	}
+
+	compiled := schedule.Compile()
+	for i := 0; i < len(compiled); i++ {
+		if err := new(RASchedule).CreateFrom(compiled[:i]); err == nil {
+			t.Errorf("Expected an error decoding %v of %v bytes", i, len(compiled))
+		}
+	}
+}
+
+func TestRAScheduleInvalid(t *testing.T) {
+	for _, schedule := range []RASchedule{
+		{Name: "no key", Turns: 1},
+		{Name: "neither", BundleKey: "key"},
+		{Name: "both", BundleKey: "key", Turns: 1, Minutes: 1},
+	} {
+		message := AIRequest{}
+		err := schedule.Execute(&message)
+		if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorInvalidParam {
+			t.Errorf("%v: expected a RuntimeErrorInvalidParam, received %v", schedule.Name, err)
+		}
+		if len(message.State.Schedules) != 0 {
+			t.Errorf("%v: expected nothing to be scheduled", schedule.Name)
+		}
+	}
+}
+
+func TestRunTurnSchedules(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	clock := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	defer func(original func() time.Time) { timeNow = original }(timeNow)
+	timeNow = func() time.Time { return clock }
+
+	pubID := "1"
+	zoneID := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	actorID := "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+
+	prompt := KeynavCompiledDialogNodeActionBundle(pubID, "prompt", 1)
+	closing := KeynavCompiledDialogNodeActionBundle(pubID, "closing", 1)
+	store.Set(prompt, CompileActionBundle(
+		&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "[still there?]"},
+		// The prompt repeats every 2 turns until it is replaced
+		&RASchedule{Name: "prompt", BundleKey: prompt, Turns: 2},
+	))
+	store.Set(closing, CompileActionBundle(&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "[shop closed]"}))
+
+	storeDialogNode(t, store, pubID, "greet", "[hello]")
+	greetBundle := KeynavCompiledDialogNodeActionBundle(pubID, "greet", 0)
+	store.Set(greetBundle, CompileActionBundle(
+		&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "[hello]"},
+		&RASchedule{Name: "prompt", BundleKey: prompt, Turns: 3},
+		&RASchedule{Name: "closing", BundleKey: closing, Minutes: 10},
+	))
+	store.HSet(KeynavCompiledDialogRootWithinActor(pubID, actorID), "hello", []byte("greet"))
+	storeDialogNode(t, store, pubID, "shrug", "[shrug]")
+	store.Set(KeynavCompiledDialogRootUnknownWithinActor(pubID, actorID), []byte("shrug"))
+
+	state := MutableAIRequestState{
+		PubID:      pubID,
+		Zone:       zoneID,
+		ZoneActors: map[uuid.UUID][]string{zoneID: {actorID}},
+	}
+
+	tests := []struct {
+		input   string
+		advance time.Duration
+		ssml    string
+	}{
+		{"hello", 0, "[hello]"},
+		{"what", time.Minute, "[shrug]"},
+		{"what", time.Minute, "[shrug]"},
+		// 3 turns after the greeting
+		{"what", time.Minute, "[still there?][shrug]"},
+		{"what", time.Minute, "[shrug]"},
+		{"what", time.Minute, "[still there?][shrug]"},
+		// Greeting again replaces the pending prompt and closing
+		{"hello", time.Minute, "[hello]"},
+		{"what", time.Minute, "[shrug]"},
+		{"what", 10 * time.Minute, "[shop closed][shrug]"},
+		{"what", time.Minute, "[still there?][shrug]"},
+	}
+
+	for i, test := range tests {
+		clock = clock.Add(test.advance)
+		message := AIRequest{State: state, OutputSSML: ssml.NewBuilder()}
+		result, err := RunTurn(&message, test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.SSML != "<speak>"+test.ssml+"</speak>" {
+			t.Errorf("Turn %v: expected %v, received %v", i+1, test.ssml, result.SSML)
+		}
+		if result.State.Turn != uint64(i+1) {
+			t.Errorf("Turn %v: expected the turn counter to be %v, received %v", i+1, i+1, result.State.Turn)
+		}
+		if !result.State.LastInteraction.Equal(clock) {
+			t.Errorf("Turn %v: expected the last interaction at %v, received %v", i+1, clock, result.State.LastInteraction)
+		}
+		state = result.State
+	}
+
+	expected := []Schedule{{Name: "prompt", BundleKey: prompt, DueTurn: 12}}
+	if !reflect.DeepEqual(state.Schedules, expected) {
+		t.Errorf("Expected pending schedules %+v, received %+v", expected, state.Schedules)
+	}
+}
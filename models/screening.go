@@ -0,0 +1,219 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/talkative-ai/core/common"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// ScreenerReviewer is the Reviewer of a ProjectReview drafted by a Screener
+const ScreenerReviewer = "prescreen"
+
+// screeningProblemNames are the names by which screening rules refer to a ReviewMajorProblem
+var screeningProblemNames = map[string]ReviewMajorProblem{
+	"sexually_explicit":             ReviewMajorProblemSexuallyExplicit,
+	"child_endangerment":            ReviewMajorProblemChildEndangerment,
+	"violence_dangerous_activities": ReviewMajorProblemViolenceDangerousActivities,
+	"bullying_and_harassment":       ReviewMajorProblemBullyingAndHarassment,
+	"hate_speech":                   ReviewMajorProblemHateSpeech,
+	"sensitive_event":               ReviewMajorProblemSensitiveEvent,
+	"gambling":                      ReviewMajorProblemGambling,
+	"illegal_activities":            ReviewMajorProblemIllegalActivities,
+	"recreational_drugs":            ReviewMajorProblemRecreationalDrugs,
+	"health":                        ReviewMajorProblemHealth,
+	"language":                      ReviewMajorProblemLanguage,
+	"mature_content":                ReviewMajorProblemMatureContent,
+}
+
+// ScreeningRule flags text which contains any of the Words or matches the Pattern
+type ScreeningRule struct {
+	Problem ReviewMajorProblem
+	// Words are matched as whole words or phrases, ignoring case
+	Words []string `json:",omitempty"`
+	// Pattern is a regular expression, matched ignoring case
+	Pattern string `json:",omitempty"`
+}
+
+// UnmarshalJSON accepts the Problem either as a ReviewMajorProblem or by name, e.g. "gambling"
+func (rule *ScreeningRule) UnmarshalJSON(data []byte) error {
+	type plain ScreeningRule
+	raw := struct {
+		*plain
+		Problem json.RawMessage
+	}{plain: (*plain)(rule)}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if len(raw.Problem) == 0 {
+		return fmt.Errorf("Missing screening rule problem")
+	}
+
+	var name string
+	if err := json.Unmarshal(raw.Problem, &name); err != nil {
+		return json.Unmarshal(raw.Problem, &rule.Problem)
+	}
+	problem, ok := screeningProblemNames[name]
+	if !ok {
+		return fmt.Errorf("Unsupported screening rule problem %q", name)
+	}
+	rule.Problem = problem
+	return nil
+}
+
+// DefaultScreeningRules is a small built-in rule list
+// Deployments are expected to maintain their own list, see LoadScreeningRules
+var DefaultScreeningRules = []ScreeningRule{
+	{Problem: ReviewMajorProblemLanguage, Words: []string{"damn", "crap", "bastard", "bloody hell"}},
+	{Problem: ReviewMajorProblemGambling, Words: []string{"casino", "poker", "roulette", "jackpot", "slot machine", "wager"}},
+	{Problem: ReviewMajorProblemGambling, Pattern: `\bbet(s|ting)?\b`},
+	{Problem: ReviewMajorProblemRecreationalDrugs, Words: []string{"cocaine", "heroin", "marijuana", "weed", "meth", "ecstasy"}},
+	{Problem: ReviewMajorProblemRecreationalDrugs, Pattern: `\b(get|getting|got) (high|stoned)\b`},
+	{Problem: ReviewMajorProblemViolenceDangerousActivities, Words: []string{"murder", "stab", "behead"}},
+}
+
+// LoadScreeningRules reads a JSON array of ScreeningRules, e.g.
+// [{"Problem": "gambling", "Words": ["casino", "slot machine"]}, {"Problem": "language", "Pattern": "\\bdarn(ed)?\\b"}]
+func LoadScreeningRules(r io.Reader) ([]ScreeningRule, error) {
+	rules := []ScreeningRule{}
+	if err := json.NewDecoder(r).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("Error reading screening rules: %s", err.Error())
+	}
+	return rules, nil
+}
+
+type compiledScreeningRule struct {
+	problem ReviewMajorProblem
+	pattern *regexp.Regexp
+}
+
+// Screener flags likely problems within the text of a project
+// It is a pre-screen for the human reviewer rather than a decision
+type Screener struct {
+	rules []compiledScreeningRule
+}
+
+// NewScreener compiles the rules into a Screener
+func NewScreener(rules []ScreeningRule) (*Screener, error) {
+	s := &Screener{}
+	for i, rule := range rules {
+		if rule.Problem < ReviewMajorProblemSexuallyExplicit || rule.Problem > ReviewMajorProblemMatureContent {
+			return nil, fmt.Errorf("Screening rule %v: unsupported problem %v", i, rule.Problem)
+		}
+		if len(rule.Words) == 0 && rule.Pattern == "" {
+			return nil, fmt.Errorf("Screening rule %v: expected words or a pattern", i)
+		}
+
+		if len(rule.Words) > 0 {
+			words := []string{}
+			for j, word := range rule.Words {
+				if strings.TrimSpace(word) == "" {
+					return nil, fmt.Errorf("Screening rule %v: blank word %v", i, j)
+				}
+				words = append(words, strings.Join(strings.Fields(regexp.QuoteMeta(word)), `\s+`))
+			}
+			s.rules = append(s.rules, compiledScreeningRule{
+				problem: rule.Problem,
+				pattern: regexp.MustCompile(`(?i)\b(?:` + strings.Join(words, "|") + `)\b`),
+			})
+		}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(`(?i)` + rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Screening rule %v: %s", i, err.Error())
+			}
+			s.rules = append(s.rules, compiledScreeningRule{problem: rule.Problem, pattern: pattern})
+		}
+	}
+	return s, nil
+}
+
+// ScreenText returns the sorted problems flagged within the text
+func (s *Screener) ScreenText(text string) []ReviewMajorProblem {
+	flagged := map[ReviewMajorProblem]bool{}
+	for _, rule := range s.rules {
+		if !flagged[rule.problem] && rule.pattern.MatchString(text) {
+			flagged[rule.problem] = true
+		}
+	}
+	problems := []ReviewMajorProblem{}
+	for problem := range flagged {
+		problems = append(problems, problem)
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i] < problems[j] })
+	return problems
+}
+
+// ScreenProject walks the title and every spoken text of the project and drafts a ProjectReview
+// Each suspect line is added to Dialogues as [dialog node ID, text], or [zone ID, text] for a zone trigger
+// The draft is ProjectReviewResultPending, leaving the decision to a human reviewer
+func (s *Screener) ScreenProject(project VersionedProject) ProjectReview {
+	review := ProjectReview{
+		ProjectID:     project.ProjectID,
+		Version:       project.Version,
+		Reviewer:      ScreenerReviewer,
+		Result:        ProjectReviewResultPending,
+		MajorProblems: ReviewMajorProblemArray{},
+		MinorProblems: ReviewMinorProblemArray{},
+		Dialogues:     common.StringArray2DJSON{},
+	}
+	flagged := map[ReviewMajorProblem]bool{}
+	for _, problem := range s.ScreenText(project.Title) {
+		review.BadTitle = true
+		flagged[problem] = true
+	}
+
+	seen := map[[2]string]bool{}
+	screen := func(id uuid.UUID, block RawLBlock) bool {
+		suspect := false
+		for _, set := range dialogActionSets(block) {
+			for _, sound := range actionSetSounds(set) {
+				text := soundText(sound)
+				problems := s.ScreenText(text)
+				if len(problems) == 0 {
+					continue
+				}
+				suspect = true
+				for _, problem := range problems {
+					flagged[problem] = true
+				}
+				line := [2]string{id.String(), text}
+				if !seen[line] {
+					seen[line] = true
+					review.Dialogues = append(review.Dialogues, line[:])
+				}
+			}
+		}
+		return suspect
+	}
+
+	// Dialog nodes are repeated once for each of their relations
+	screened := map[uuid.UUID]bool{}
+	dialogs := false
+	for _, item := range project.ProjectData {
+		if screened[item.DialogID] {
+			continue
+		}
+		screened[item.DialogID] = true
+		dialogs = screen(item.DialogID, item.RawLBlock) || dialogs
+	}
+	triggers := false
+	for _, item := range project.TriggerData {
+		triggers = screen(item.ZoneID, item.RawLBlock) || triggers
+	}
+	if triggers && !dialogs {
+		review.ProblemWith = ReviewProblemWithZoneIntroductionTrigger
+	}
+
+	for problem := range flagged {
+		review.MajorProblems = append(review.MajorProblems, problem)
+	}
+	sort.Slice(review.MajorProblems, func(i, j int) bool { return review.MajorProblems[i] < review.MajorProblems[j] })
+
+	return review
+}
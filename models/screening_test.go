@@ -0,0 +1,147 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/talkative-ai/core/common"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+func screeningID(n int) uuid.UUID {
+	return validateID(90 + n)
+}
+
+func screeningBlock(texts ...interface{}) RawLBlock {
+	block := RawLBlock{}
+	for _, text := range texts {
+		block.AlwaysExec.PlaySounds = append(block.AlwaysExec.PlaySounds, RAPlaySound{SoundType: RAPlaySoundTypeText, Val: text})
+	}
+	return block
+}
+
+func TestLoadScreeningRules(t *testing.T) {
+	rules, err := LoadScreeningRules(strings.NewReader(`[
+		{"Problem": "gambling", "Words": ["casino", "slot  machine"]},
+		{"Problem": 10, "Pattern": "\\bdarn(ed)?\\b"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ScreeningRule{
+		{Problem: ReviewMajorProblemGambling, Words: []string{"casino", "slot  machine"}},
+		{Problem: ReviewMajorProblemLanguage, Pattern: `\bdarn(ed)?\b`},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("Expected %+v, received %+v", expected, rules)
+	}
+
+	screener, err := NewScreener(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string][]ReviewMajorProblem{
+		"Welcome to the CASINO":                {ReviewMajorProblemGambling},
+		"Darned slot\nmachine, it is a casino": {ReviewMajorProblemGambling, ReviewMajorProblemLanguage},
+		"The casinos are closed":               {},
+		"Darning socks in the {{casino_name}}": {},
+	}
+	for text, problems := range tests {
+		if received := screener.ScreenText(text); !reflect.DeepEqual(received, problems) {
+			t.Errorf("%q: expected %v, received %v", text, problems, received)
+		}
+	}
+
+	invalid := []string{
+		`[{"Problem": "profanity", "Words": ["darn"]}]`,
+		`[{"Words": ["darn"]}]`,
+		`{"Problem": "language"}`,
+	}
+	for _, config := range invalid {
+		if _, err := LoadScreeningRules(strings.NewReader(config)); err == nil {
+			t.Errorf("Expected an error loading %v", config)
+		}
+	}
+	for _, rule := range []ScreeningRule{
+		{Problem: ReviewMajorProblemLanguage},
+		{Problem: ReviewMajorProblemLanguage, Pattern: "("},
+		{Problem: ReviewMajorProblemMatureContent + 1, Words: []string{"darn"}},
+		{Problem: ReviewMajorProblemLanguage, Words: []string{"darn", ""}},
+		{Problem: ReviewMajorProblemLanguage, Words: []string{" \t"}},
+	} {
+		if _, err := NewScreener([]ScreeningRule{rule}); err == nil {
+			t.Errorf("Expected an error compiling %+v", rule)
+		}
+	}
+}
+
+func TestScreenProject(t *testing.T) {
+	screener, err := NewScreener(DefaultScreeningRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	choice := screeningBlock("Hello")
+	choice.Statements = &RawLStatementUnified{{
+		{Exec: ActionSet{ChooseSounds: []RAChooseSound{{Variants: []RAPlaySound{
+			{SoundType: RAPlaySoundTypeText, Val: "Fancy a game of poker?"},
+			{SoundType: RAPlaySoundTypeAudio, Val: "https://example.com/poker.mp3"},
+		}}}}},
+	}}
+
+	project := VersionedProject{
+		ProjectID: screeningID(0),
+		Version:   3,
+		Title:     "The Riverboat",
+		ProjectData: ProjectItemArray{
+			{DialogID: screeningID(1), RawLBlock: screeningBlock("Welcome aboard", SSMLProsody{Text: "Place your bets!"})},
+			// Repeated for each relation of the dialog node
+			{DialogID: screeningID(1), RawLBlock: screeningBlock("Welcome aboard", SSMLProsody{Text: "Place your bets!"})},
+			{DialogID: screeningID(2), RawLBlock: choice},
+			{DialogID: screeningID(3), RawLBlock: screeningBlock("Damn, I lost my weed")},
+		},
+		TriggerData: ProjectTriggerItemArray{
+			{ZoneID: screeningID(4), TriggerType: TriggerInitializeZone, RawLBlock: screeningBlock("You hear a casino band")},
+		},
+	}
+
+	review := screener.ScreenProject(project)
+	expected := ProjectReview{
+		ProjectID: project.ProjectID,
+		Version:   project.Version,
+		Reviewer:  ScreenerReviewer,
+		Result:    ProjectReviewResultPending,
+		MajorProblems: ReviewMajorProblemArray{
+			ReviewMajorProblemGambling,
+			ReviewMajorProblemRecreationalDrugs,
+			ReviewMajorProblemLanguage,
+		},
+		MinorProblems: ReviewMinorProblemArray{},
+		ProblemWith:   ReviewProblemWithDialog,
+		Dialogues: common.StringArray2DJSON{
+			{screeningID(1).String(), "Place your bets!"},
+			{screeningID(2).String(), "Fancy a game of poker?"},
+			{screeningID(3).String(), "Damn, I lost my weed"},
+			{screeningID(4).String(), "You hear a casino band"},
+		},
+	}
+	if !reflect.DeepEqual(review, expected) {
+		t.Fatalf("Expected %+v, received %+v", expected, review)
+	}
+
+	// Only the zone trigger and the title are flagged
+	project.ProjectData = project.ProjectData[:0]
+	project.Title = "Casino Night"
+	review = screener.ScreenProject(project)
+	if !review.BadTitle || review.ProblemWith != ReviewProblemWithZoneIntroductionTrigger || len(review.Dialogues) != 1 {
+		t.Errorf("Unexpected review %+v", review)
+	}
+
+	project.Title = "The Library"
+	project.TriggerData = nil
+	review = screener.ScreenProject(project)
+	if review.Result != ProjectReviewResultPending || review.BadTitle || len(review.MajorProblems) != 0 || len(review.Dialogues) != 0 {
+		t.Errorf("Expected nothing to be flagged, received %+v", review)
+	}
+}
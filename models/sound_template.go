@@ -0,0 +1,483 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SoundTemplate is a parsed RAPlaySound text
+// Text may contain the following tags, which are rendered against the ARVariables:
+//
+// {{gold}}                      The value of a variable
+// {{name|stranger}}             The value of a variable, or a default if it does not exist
+// {{items[0]}}                  An element of an array variable
+// {{len items}}                 The length of an array or string variable
+// {{plural gold "coin" "coins"}} The singular or plural form with respect to a number or array length
+// {{#if gold > 10}}…{{else}}…{{/if}} Conditional text. The else branch is optional
+// \{{                          A literal {{
+//
+// Conditions compare a variable with another variable or a literal
+// using ==, !=, <, >, <= or >=. Literals are numbers, "quoted strings", true or false.
+// A condition with only a variable yields true if the variable is set and not empty, zero or false
+type SoundTemplate struct {
+	nodes []templateNode
+}
+
+// templateNode is a single node of the SoundTemplate AST
+type templateNode interface {
+	render(vars map[string]*ARVariable, buf *bytes.Buffer) error
+}
+
+// templateText is literal text
+type templateText string
+
+// templateRef refers to a variable, or an element of an array variable if index >= 0
+type templateRef struct {
+	name  string
+	index int
+}
+
+// templateVar renders the value of a variable
+type templateVar struct {
+	ref        templateRef
+	def        string
+	hasDefault bool
+}
+
+// templateLen renders the length of an array or string variable
+type templateLen struct {
+	ref templateRef
+}
+
+// templatePlural renders singular if the count is exactly 1, otherwise plural
+type templatePlural struct {
+	ref              templateRef
+	singular, plural string
+}
+
+// templateIf renders then if the condition yields true, otherwise els
+type templateIf struct {
+	cond      templateCond
+	then, els []templateNode
+}
+
+// templateCond compares left to right with op
+// If op is 0, the condition yields the truthiness of left
+type templateCond struct {
+	left, right templateOperand
+	op          OperatorInt
+}
+
+// templateOperand is either a variable or a literal value
+type templateOperand struct {
+	ref     *templateRef
+	literal interface{}
+}
+
+var templateRefRegex = regexp.MustCompile(`^(\w+)(?:\[(\d+)\])?$`)
+
+var templateOperators = map[string]OperatorInt{
+	"==": OpIntEQ,
+	"!=": OpIntNE,
+	"<":  OpIntLT,
+	">":  OpIntGT,
+	"<=": OpIntLE,
+	">=": OpIntGE,
+}
+
+// compiledTemplateMarker precedes the text of an RAPlaySoundTypeText compiled since templates existed
+// It never begins UTF-8 text, so text compiled before is told apart, see legacySoundTemplate
+const compiledTemplateMarker byte = 0xFF
+
+// legacySoundTemplate is the template of an RAPlaySoundTypeText compiled before templates existed
+// Such text was spoken as is, including any {{, so it is never parsed
+func legacySoundTemplate(text string) *SoundTemplate {
+	return &SoundTemplate{nodes: []templateNode{templateText(text)}}
+}
+
+// ParseSoundTemplate parses RAPlaySound text into a SoundTemplate
+// Useful for validating text within the workbench and Lakshmi
+func ParseSoundTemplate(text string) (*SoundTemplate, error) {
+	// stack contains the nodes of each open {{#if}}
+	type frame struct {
+		node   *templateIf
+		inElse bool
+	}
+	root := []templateNode{}
+	stack := []*frame{}
+
+	appendNode := func(n templateNode) {
+		if len(stack) == 0 {
+			root = append(root, n)
+			return
+		}
+		top := stack[len(stack)-1]
+		if top.inElse {
+			top.node.els = append(top.node.els, n)
+		} else {
+			top.node.then = append(top.node.then, n)
+		}
+	}
+
+	for len(text) > 0 {
+		start := strings.Index(text, "{{")
+		if start < 0 {
+			appendNode(templateText(text))
+			break
+		}
+		if start > 0 && text[start-1] == '\\' {
+			appendNode(templateText(text[:start-1] + "{{"))
+			text = text[start+2:]
+			continue
+		}
+		end := strings.Index(text[start:], "}}")
+		if end < 0 {
+			appendNode(templateText(text))
+			break
+		}
+		if start > 0 {
+			appendNode(templateText(text[:start]))
+		}
+		tag := strings.TrimSpace(text[start+2 : start+end])
+		text = text[start+end+2:]
+
+		switch {
+		case strings.HasPrefix(tag, "#if "):
+			cond, err := parseTemplateCond(strings.TrimSpace(tag[4:]))
+			if err != nil {
+				return nil, err
+			}
+			node := &templateIf{cond: cond}
+			appendNode(node)
+			stack = append(stack, &frame{node: node})
+		case tag == "else":
+			if len(stack) == 0 || stack[len(stack)-1].inElse {
+				return nil, fmt.Errorf("Unexpected {{else}}")
+			}
+			stack[len(stack)-1].inElse = true
+		case tag == "/if":
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("Unexpected {{/if}}")
+			}
+			stack = stack[:len(stack)-1]
+		case strings.HasPrefix(tag, "len "):
+			ref, err := parseTemplateRef(strings.TrimSpace(tag[4:]))
+			if err != nil {
+				return nil, err
+			}
+			appendNode(templateLen{ref: ref})
+		case strings.HasPrefix(tag, "plural "):
+			args, err := splitTemplateArgs(tag[7:])
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 3 {
+				return nil, fmt.Errorf("Expected {{plural variable singular plural}}, received {{%s}}", tag)
+			}
+			ref, err := parseTemplateRef(args[0])
+			if err != nil {
+				return nil, err
+			}
+			appendNode(templatePlural{ref: ref, singular: unquoteTemplateArg(args[1]), plural: unquoteTemplateArg(args[2])})
+		default:
+			node := templateVar{}
+			if i := strings.Index(tag, "|"); i >= 0 {
+				node.def = strings.TrimSpace(tag[i+1:])
+				node.hasDefault = true
+				tag = strings.TrimSpace(tag[:i])
+			}
+			ref, err := parseTemplateRef(tag)
+			if err != nil {
+				return nil, err
+			}
+			node.ref = ref
+			appendNode(node)
+		}
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("Missing {{/if}}")
+	}
+
+	return &SoundTemplate{nodes: root}, nil
+}
+
+// Render renders the template against the variables
+// Returns a RuntimeError if a variable without a default does not exist
+func (tmpl *SoundTemplate) Render(vars map[string]*ARVariable) (string, error) {
+	buf := bytes.Buffer{}
+	if err := renderTemplateNodes(tmpl.nodes, vars, &buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Variables returns the names of the variables the template reads, in the order they first appear
+// Variables with a default are omitted, as the template renders without them
+func (tmpl *SoundTemplate) Variables() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	add := func(ref *templateRef) {
+		if ref != nil && !seen[ref.name] {
+			seen[ref.name] = true
+			names = append(names, ref.name)
+		}
+	}
+
+	var walk func(nodes []templateNode)
+	walk = func(nodes []templateNode) {
+		for _, n := range nodes {
+			switch v := n.(type) {
+			case templateVar:
+				if !v.hasDefault {
+					add(&v.ref)
+				}
+			case templateLen:
+				add(&v.ref)
+			case templatePlural:
+				add(&v.ref)
+			case *templateIf:
+				add(v.cond.left.ref)
+				add(v.cond.right.ref)
+				walk(v.then)
+				walk(v.els)
+			}
+		}
+	}
+	walk(tmpl.nodes)
+
+	return names
+}
+
+func renderTemplateNodes(nodes []templateNode, vars map[string]*ARVariable, buf *bytes.Buffer) error {
+	for _, n := range nodes {
+		if err := n.render(vars, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n templateText) render(vars map[string]*ARVariable, buf *bytes.Buffer) error {
+	buf.WriteString(string(n))
+	return nil
+}
+
+func (n templateVar) render(vars map[string]*ARVariable, buf *bytes.Buffer) error {
+	val, ok := n.ref.lookup(vars)
+	if !ok {
+		if !n.hasDefault {
+			return newRuntimeError(RuntimeErrorMissingVariable, "Variable %q does not exist", n.ref.String())
+		}
+		buf.WriteString(n.def)
+		return nil
+	}
+	fmt.Fprintf(buf, "%v", val)
+	return nil
+}
+
+func (n templateLen) render(vars map[string]*ARVariable, buf *bytes.Buffer) error {
+	val, ok := n.ref.lookup(vars)
+	if !ok {
+		return newRuntimeError(RuntimeErrorMissingVariable, "Variable %q does not exist", n.ref.String())
+	}
+	length, ok := templateLength(val)
+	if !ok {
+		return newRuntimeError(RuntimeErrorInvalidType, "Invalid type %T for {{len %s}}", val, n.ref.String())
+	}
+	buf.WriteString(strconv.Itoa(length))
+	return nil
+}
+
+func (n templatePlural) render(vars map[string]*ARVariable, buf *bytes.Buffer) error {
+	val, ok := n.ref.lookup(vars)
+	if !ok {
+		return newRuntimeError(RuntimeErrorMissingVariable, "Variable %q does not exist", n.ref.String())
+	}
+	count, ok := toFloat64(val)
+	if !ok {
+		length, isArray := templateLength(val)
+		if _, isString := val.(string); !isArray || isString {
+			return newRuntimeError(RuntimeErrorInvalidType, "Invalid type %T for {{plural %s}}", val, n.ref.String())
+		}
+		count = float64(length)
+	}
+	if count == 1 {
+		buf.WriteString(n.singular)
+	} else {
+		buf.WriteString(n.plural)
+	}
+	return nil
+}
+
+func (n *templateIf) render(vars map[string]*ARVariable, buf *bytes.Buffer) error {
+	if n.cond.evaluate(vars) {
+		return renderTemplateNodes(n.then, vars, buf)
+	}
+	return renderTemplateNodes(n.els, vars, buf)
+}
+
+func (c templateCond) evaluate(vars map[string]*ARVariable) bool {
+	left, ok := c.left.value(vars)
+	if c.op == 0 {
+		return ok && templateTruthy(left)
+	}
+	right, rok := c.right.value(vars)
+	if !ok || !rok {
+		return false
+	}
+	return compareOperator(c.op, left, right)
+}
+
+func (o templateOperand) value(vars map[string]*ARVariable) (interface{}, bool) {
+	if o.ref != nil {
+		return o.ref.lookup(vars)
+	}
+	return o.literal, true
+}
+
+// lookup returns the value of the variable or array element
+func (ref templateRef) lookup(vars map[string]*ARVariable) (interface{}, bool) {
+	arv, ok := vars[ref.name]
+	if !ok || arv == nil {
+		return nil, false
+	}
+	if ref.index < 0 {
+		return arv.Val, true
+	}
+	arr, ok := arv.Val.([]ARVariable)
+	if !ok || ref.index >= len(arr) {
+		return nil, false
+	}
+	return arr[ref.index].Val, true
+}
+
+func (ref templateRef) String() string {
+	if ref.index < 0 {
+		return ref.name
+	}
+	return fmt.Sprintf("%s[%d]", ref.name, ref.index)
+}
+
+func parseTemplateRef(s string) (templateRef, error) {
+	match := templateRefRegex.FindStringSubmatch(s)
+	if match == nil {
+		return templateRef{}, fmt.Errorf("Invalid variable %q", s)
+	}
+	ref := templateRef{name: match[1], index: -1}
+	if match[2] != "" {
+		ref.index, _ = strconv.Atoi(match[2])
+	}
+	return ref, nil
+}
+
+func parseTemplateCond(s string) (templateCond, error) {
+	args, err := splitTemplateArgs(s)
+	if err != nil {
+		return templateCond{}, err
+	}
+
+	cond := templateCond{}
+	switch len(args) {
+	case 1:
+		cond.left, err = parseTemplateOperand(args[0])
+		return cond, err
+	case 3:
+		op, ok := templateOperators[args[1]]
+		if !ok {
+			return cond, fmt.Errorf("Invalid operator %q", args[1])
+		}
+		cond.op = op
+		if cond.left, err = parseTemplateOperand(args[0]); err != nil {
+			return cond, err
+		}
+		cond.right, err = parseTemplateOperand(args[2])
+		return cond, err
+	default:
+		return cond, fmt.Errorf("Invalid condition %q", s)
+	}
+}
+
+func parseTemplateOperand(s string) (templateOperand, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		return templateOperand{literal: unquoteTemplateArg(s)}, nil
+	case s == "true" || s == "false":
+		return templateOperand{literal: s == "true"}, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return templateOperand{literal: n}, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return templateOperand{literal: f}, nil
+	}
+	ref, err := parseTemplateRef(s)
+	if err != nil {
+		return templateOperand{}, err
+	}
+	return templateOperand{ref: &ref}, nil
+}
+
+// splitTemplateArgs splits a tag's arguments by whitespace
+// Double quoted arguments may contain whitespace
+func splitTemplateArgs(s string) ([]string, error) {
+	args := []string{}
+	s = strings.TrimSpace(s)
+	for len(s) > 0 {
+		if s[0] == '"' {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("Unterminated string in %q", s)
+			}
+			args = append(args, s[:end+2])
+			s = strings.TrimSpace(s[end+2:])
+			continue
+		}
+		end := strings.IndexAny(s, " \t")
+		if end < 0 {
+			args = append(args, s)
+			break
+		}
+		args = append(args, s[:end])
+		s = strings.TrimSpace(s[end:])
+	}
+	return args, nil
+}
+
+func unquoteTemplateArg(s string) string {
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// templateLength returns the length of an array or string
+func templateLength(val interface{}) (int, bool) {
+	switch v := val.(type) {
+	case []ARVariable:
+		return len(v), true
+	case string:
+		return len(v), true
+	}
+	return 0, false
+}
+
+// templateTruthy yields false for false, zero, empty strings and empty arrays
+func templateTruthy(val interface{}) bool {
+	if n, ok := toFloat64(val); ok {
+		return n != 0
+	}
+	switch v := val.(type) {
+	case bool:
+		return v
+	case nil:
+		return false
+	}
+	if length, ok := templateLength(val); ok {
+		return length > 0
+	}
+	return true
+}
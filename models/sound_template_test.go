@@ -0,0 +1,145 @@
+package models
+
+import (
+	"testing"
+
+	ssml "github.com/talkative-ai/go-ssml"
+)
+
+func TestSoundTemplateRender(t *testing.T) {
+	vars := map[string]*ARVariable{
+		"gold":  {T: "int", Val: int64(12)},
+		"one":   {T: "int", Val: int64(1)},
+		"name":  {T: "string", Val: "Arjuna"},
+		"brave": {T: "bool", Val: true},
+		"items": {T: "array", Val: []ARVariable{
+			{T: "string", Val: "sword"},
+			{T: "string", Val: "shield"},
+		}},
+	}
+
+	tests := []struct {
+		template string
+		output   string
+	}{
+		{"Hello world", "Hello world"},
+		{"You have {{gold}} gold", "You have 12 gold"},
+		{"Hello {{ name }}", "Hello Arjuna"},
+		{"Hello {{title|stranger}}", "Hello stranger"},
+		{"Hello {{name|stranger}}", "Hello Arjuna"},
+		{"You hold a {{items[1]}}", "You hold a shield"},
+		{"{{items[5]|nothing}}", "nothing"},
+		{"You carry {{len items}} {{plural items \"item\" \"items\"}}", "You carry 2 items"},
+		{"{{one}} {{plural one coin coins}}", "1 coin"},
+		{"{{#if gold > 10}}Rich{{/if}}", "Rich"},
+		{"{{#if gold > 100}}Rich{{else}}Poor{{/if}}", "Poor"},
+		{"{{#if name == \"Arjuna\"}}Welcome back{{/if}}", "Welcome back"},
+		{"{{#if brave}}Onward{{/if}}{{#if cowardly}}Retreat{{/if}}", "Onward"},
+		{"{{#if gold >= one}}{{#if brave}}Nested{{else}}No{{/if}}{{/if}}", "Nested"},
+		{"Unclosed {{ braces", "Unclosed {{ braces"},
+		{`Escaped \{{gold}} and {{gold}}`, "Escaped {{gold}} and 12"},
+	}
+
+	for _, test := range tests {
+		tmpl, err := ParseSoundTemplate(test.template)
+		if err != nil {
+			t.Errorf("%q: %v", test.template, err)
+			continue
+		}
+		output, err := tmpl.Render(vars)
+		if err != nil {
+			t.Errorf("%q: %v", test.template, err)
+			continue
+		}
+		if output != test.output {
+			t.Errorf("%q: expected %q, received %q", test.template, test.output, output)
+		}
+	}
+}
+
+func TestSoundTemplateErrors(t *testing.T) {
+	invalid := []string{
+		"{{#if gold > 10}}Unterminated",
+		"{{/if}}",
+		"{{else}}",
+		"{{#if gold ~ 10}}{{/if}}",
+		"{{plural gold coin}}",
+		"{{not a variable}}",
+	}
+	for _, template := range invalid {
+		if _, err := ParseSoundTemplate(template); err == nil {
+			t.Errorf("%q: expected a parse error", template)
+		}
+	}
+
+	tmpl, err := ParseSoundTemplate("{{silver}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.Render(map[string]*ARVariable{})
+	if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorMissingVariable {
+		t.Errorf("Expected a RuntimeErrorMissingVariable, received %v", err)
+	}
+}
+
+// clearSoundTemplates clears the templates parsed by CreateFrom, for comparison with the sounds before Compile
+func clearSoundTemplates(sounds []RAPlaySound) {
+	for i := range sounds {
+		sounds[i].template = nil
+	}
+}
+
+func TestPlaySoundTemplate(t *testing.T) {
+	vars := map[string]*ARVariable{"gold": {T: "int", Val: int64(12)}}
+	execute := func(compiled []byte) (string, error) {
+		decoded := RAPlaySound{}
+		if err := decoded.CreateFrom(compiled); err != nil {
+			return "", err
+		}
+		state := AIRequest{State: MutableAIRequestState{ARVariables: vars}, OutputSSML: ssml.NewBuilder()}
+		err := decoded.Execute(&state)
+		return state.OutputSSML.String(), err
+	}
+
+	sound := RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "You have {{gold}} gold"}
+	if output, err := execute(sound.Compile()); err != nil || output != ssml.NewBuilder().Paragraph("You have 12 gold").String() {
+		t.Errorf("Unexpected output %v %v", output, err)
+	}
+
+	// Lakshmi rejects invalid text, and Brahman rejects it if compiled regardless
+	invalid := RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "Say {{the magic word}}"}
+	if err := invalid.Validate(); err == nil {
+		t.Error("Expected Lakshmi to reject the text")
+	}
+	if err := (ActionSet{ChooseSounds: []RAChooseSound{{Variants: []RAPlaySound{invalid}}}}).Validate(); err == nil {
+		t.Error("Expected Lakshmi to reject the text of a variant")
+	}
+	if _, err := execute(invalid.Compile()); err == nil {
+		t.Error("Expected an error decoding invalid text")
+	}
+	prosody := RAPlaySound{SoundType: RAPlaySoundTypeProsody, Val: SSMLProsody{Text: "{{#if gold}}", Rate: "slow"}}
+	if _, err := execute(prosody.Compile()); err == nil {
+		t.Error("Expected an error decoding invalid prosody text")
+	}
+	if err := invalid.Execute(&AIRequest{OutputSSML: ssml.NewBuilder()}); err == nil {
+		t.Error("Expected an error executing invalid text which was not decoded")
+	}
+}
+
+func TestPlaySoundLegacyText(t *testing.T) {
+	// Compiled before templates existed, and spoken as is
+	for _, text := range []string{"Say {{the magic word}} and {{else}}", "You have {{gold}} gold", ""} {
+		compiled := append([]byte{byte(RAPlaySoundTypeText)}, text...)
+		decoded := RAPlaySound{}
+		if err := decoded.CreateFrom(compiled); err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		state := AIRequest{OutputSSML: ssml.NewBuilder()}
+		if err := decoded.Execute(&state); err != nil {
+			t.Fatalf("%q: %v", text, err)
+		}
+		if expected := ssml.NewBuilder().Paragraph(text).String(); state.OutputSSML.String() != expected {
+			t.Errorf("Expected %v, received %v", expected, state.OutputSSML.String())
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	utilities "github.com/talkative-ai/core"
+	ssml "github.com/talkative-ai/go-ssml"
+)
+
+// SSMLBreak is the Val of an RAPlaySoundTypeBreak
+// A pause with either a Strength or a Time in milliseconds
+type SSMLBreak struct {
+	Strength     string `json:",omitempty"`
+	Milliseconds uint32 `json:",omitempty"`
+}
+
+// SSMLProsody is the Val of an RAPlaySoundTypeProsody
+// Rate, Pitch and Volume are optional e.g. "slow", "+2st", "loud"
+type SSMLProsody struct {
+	Text   string
+	Rate   string `json:",omitempty"`
+	Pitch  string `json:",omitempty"`
+	Volume string `json:",omitempty"`
+}
+
+// SSMLEmphasis is the Val of an RAPlaySoundTypeEmphasis
+type SSMLEmphasis struct {
+	Text  string
+	Level string
+}
+
+// SSMLSayAs is the Val of an RAPlaySoundTypeSayAs
+// InterpretAs describes how the Text is spoken e.g. "cardinal", "date", "characters"
+// Format is optional e.g. "mdy" for dates
+type SSMLSayAs struct {
+	Text        string
+	InterpretAs string
+	Format      string `json:",omitempty"`
+}
+
+// SSMLVoice is the Val of an RAPlaySoundTypeVoice
+// Lakshmi uses the Voice of the actor a dialog belongs to
+type SSMLVoice struct {
+	Text string
+	Name string
+}
+
+var ssmlBreakStrengths = map[string]bool{
+	"none": true, "x-weak": true, "weak": true, "medium": true, "strong": true, "x-strong": true,
+}
+
+var ssmlEmphasisLevels = map[string]bool{
+	"strong": true, "moderate": true, "reduced": true, "none": true,
+}
+
+var ssmlSayAsInterpretations = map[string]bool{
+	"cardinal": true, "number": true, "ordinal": true, "digits": true, "fraction": true,
+	"unit": true, "date": true, "time": true, "telephone": true, "address": true,
+	"characters": true, "spell-out": true, "interjection": true, "expletive": true,
+}
+
+// compileSSML compiles the Val of a structured RAPlaySound
+// Each string field is length prefixed in the order of the struct fields
+// A break is compiled as [strength][4 byte milliseconds]
+func compileSSML(val interface{}) []byte {
+	compiled := []byte{}
+	switch v := val.(type) {
+	case SSMLBreak:
+		compiled = appendCompiledString(compiled, v.Strength)
+		msBytes := make([]byte, 4)
+		binary.LittleEndian.PutUint32(msBytes, v.Milliseconds)
+		compiled = append(compiled, msBytes...)
+	case SSMLProsody:
+		for _, s := range []string{v.Text, v.Rate, v.Pitch, v.Volume} {
+			compiled = appendCompiledString(compiled, s)
+		}
+	case SSMLEmphasis:
+		compiled = appendCompiledString(compiled, v.Text)
+		compiled = appendCompiledString(compiled, v.Level)
+	case SSMLSayAs:
+		for _, s := range []string{v.Text, v.InterpretAs, v.Format} {
+			compiled = appendCompiledString(compiled, s)
+		}
+	case SSMLVoice:
+		compiled = appendCompiledString(compiled, v.Text)
+		compiled = appendCompiledString(compiled, v.Name)
+	}
+	return compiled
+}
+
+// readCompiledSSML reads the Val of a structured RAPlaySound compiled by compileSSML
+func readCompiledSSML(soundType RAPlaySoundType, compiled []byte) (interface{}, error) {
+	r := utilities.NewByteReader(compiled)
+	readStrings := func(n int) ([]string, error) {
+		strs := make([]string, n)
+		for i := range strs {
+			var err error
+			if strs[i], err = r.LengthPrefixedString(); err != nil {
+				return nil, err
+			}
+		}
+		return strs, nil
+	}
+
+	switch soundType {
+	case RAPlaySoundTypeBreak:
+		strength, err := r.LengthPrefixedString()
+		if err != nil {
+			return nil, err
+		}
+		ms, err := r.Uint32()
+		if err != nil {
+			return nil, err
+		}
+		return SSMLBreak{Strength: strength, Milliseconds: ms}, nil
+	case RAPlaySoundTypeProsody:
+		strs, err := readStrings(4)
+		if err != nil {
+			return nil, err
+		}
+		return SSMLProsody{Text: strs[0], Rate: strs[1], Pitch: strs[2], Volume: strs[3]}, nil
+	case RAPlaySoundTypeEmphasis:
+		strs, err := readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		return SSMLEmphasis{Text: strs[0], Level: strs[1]}, nil
+	case RAPlaySoundTypeSayAs:
+		strs, err := readStrings(3)
+		if err != nil {
+			return nil, err
+		}
+		return SSMLSayAs{Text: strs[0], InterpretAs: strs[1], Format: strs[2]}, nil
+	case RAPlaySoundTypeVoice:
+		strs, err := readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		return SSMLVoice{Text: strs[0], Name: strs[1]}, nil
+	}
+	return nil, fmt.Errorf("Unknown RAPlaySoundType %v", soundType)
+}
+
+// unmarshalSSML decodes the JSON Val of a structured RAPlaySound
+func unmarshalSSML(soundType RAPlaySoundType, data []byte) (interface{}, error) {
+	var err error
+	switch soundType {
+	case RAPlaySoundTypeBreak:
+		v := SSMLBreak{}
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case RAPlaySoundTypeProsody:
+		v := SSMLProsody{}
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case RAPlaySoundTypeEmphasis:
+		v := SSMLEmphasis{}
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case RAPlaySoundTypeSayAs:
+		v := SSMLSayAs{}
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case RAPlaySoundTypeVoice:
+		v := SSMLVoice{}
+		err = json.Unmarshal(data, &v)
+		return v, err
+	}
+	var v interface{}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+// appendSSML appends the SSML element of a structured RAPlaySound to the builder
+// The Text of the element is tmpl rendered against the ARVariables
+// The go-ssml builder escapes the text and attributes of each element
+func appendSSML(b ssml.Builder, val interface{}, tmpl *SoundTemplate, vars map[string]*ARVariable) (ssml.Builder, error) {
+	render := func(string) (string, error) {
+		return tmpl.Render(vars)
+	}
+
+	switch v := val.(type) {
+	case SSMLBreak:
+		if v.Strength != "" && !ssmlBreakStrengths[v.Strength] {
+			return b, newRuntimeError(RuntimeErrorInvalidParam, "Invalid break strength %q", v.Strength)
+		}
+		time := ""
+		if v.Milliseconds > 0 {
+			time = fmt.Sprintf("%vms", v.Milliseconds)
+		}
+		return b.Break(v.Strength, time), nil
+	case SSMLProsody:
+		text, err := render(v.Text)
+		if err != nil {
+			return b, err
+		}
+		return b.Prosody(text, v.Rate, v.Pitch, v.Volume), nil
+	case SSMLEmphasis:
+		if !ssmlEmphasisLevels[v.Level] {
+			return b, newRuntimeError(RuntimeErrorInvalidParam, "Invalid emphasis level %q", v.Level)
+		}
+		text, err := render(v.Text)
+		if err != nil {
+			return b, err
+		}
+		return b.Emphasis(text, v.Level), nil
+	case SSMLSayAs:
+		if !ssmlSayAsInterpretations[v.InterpretAs] {
+			return b, newRuntimeError(RuntimeErrorInvalidParam, "Invalid say-as interpretation %q", v.InterpretAs)
+		}
+		text, err := render(v.Text)
+		if err != nil {
+			return b, err
+		}
+		return b.SayAs(text, v.InterpretAs, v.Format), nil
+	case SSMLVoice:
+		if v.Name == "" {
+			return b, newRuntimeError(RuntimeErrorInvalidParam, "Missing voice name")
+		}
+		text, err := render(v.Text)
+		if err != nil {
+			return b, err
+		}
+		return b.Voice(text, v.Name), nil
+	}
+
+	return b, newRuntimeError(RuntimeErrorInvalidType, "Invalid type %T on RAPlaySound Execute", val)
+}
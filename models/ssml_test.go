@@ -0,0 +1,122 @@
+package models
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	ssml "github.com/talkative-ai/go-ssml"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func TestPlaySoundSSMLGolden(t *testing.T) {
+	vars := map[string]*ARVariable{
+		"name": {T: "string", Val: "Arjuna"},
+		"gold": {T: "int", Val: int64(42)},
+	}
+
+	tests := []struct {
+		name   string
+		sounds []RAPlaySound
+	}{
+		{"break", []RAPlaySound{
+			{SoundType: RAPlaySoundTypeText, Val: "Wait for it"},
+			{SoundType: RAPlaySoundTypeBreak, Val: SSMLBreak{Milliseconds: 750}},
+			{SoundType: RAPlaySoundTypeText, Val: "Now"},
+			{SoundType: RAPlaySoundTypeBreak, Val: SSMLBreak{Strength: "x-strong"}},
+		}},
+		{"prosody", []RAPlaySound{
+			{SoundType: RAPlaySoundTypeProsody, Val: SSMLProsody{Text: "Slowly now, {{name}}", Rate: "slow", Pitch: "-2st"}},
+			{SoundType: RAPlaySoundTypeProsody, Val: SSMLProsody{Text: "RUN!", Volume: "x-loud"}},
+		}},
+		{"emphasis", []RAPlaySound{
+			{SoundType: RAPlaySoundTypeEmphasis, Val: SSMLEmphasis{Text: "Never", Level: "strong"}},
+			{SoundType: RAPlaySoundTypeText, Val: "touch the idol."},
+		}},
+		{"say_as", []RAPlaySound{
+			{SoundType: RAPlaySoundTypeSayAs, Val: SSMLSayAs{Text: "{{gold}}", InterpretAs: "cardinal"}},
+			{SoundType: RAPlaySoundTypeSayAs, Val: SSMLSayAs{Text: "10/18/2026", InterpretAs: "date", Format: "mdy"}},
+			{SoundType: RAPlaySoundTypeSayAs, Val: SSMLSayAs{Text: "R&D", InterpretAs: "characters"}},
+		}},
+		{"voice", []RAPlaySound{
+			{SoundType: RAPlaySoundTypeVoice, Val: SSMLVoice{Text: "Welcome, {{name}}.", Name: "Brian"}},
+			{SoundType: RAPlaySoundTypeVoice, Val: SSMLVoice{Text: "Ignore him.", Name: "Amy"}},
+		}},
+	}
+
+	for _, test := range tests {
+		message := AIRequest{State: MutableAIRequestState{ARVariables: vars}, OutputSSML: ssml.NewBuilder()}
+		for _, sound := range test.sounds {
+			decoded := RAPlaySound{}
+			if err := decoded.CreateFrom(sound.Compile()); err != nil {
+				t.Fatalf("%v: %v", test.name, err)
+			}
+			roundTrip := []RAPlaySound{decoded}
+			clearSoundTemplates(roundTrip)
+			if !reflect.DeepEqual(roundTrip[0], sound) {
+				t.Errorf("%v: expected %+v to round-trip, received %+v", test.name, sound, roundTrip[0])
+			}
+			if err := decoded.Execute(&message); err != nil {
+				t.Fatalf("%v: %v", test.name, err)
+			}
+		}
+
+		output := message.OutputSSML.String() + "\n"
+		golden := filepath.Join("testdata", "ssml", test.name+".golden")
+		if *updateGolden {
+			if err := ioutil.WriteFile(golden, []byte(output), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != string(expected) {
+			t.Errorf("%v: expected %v, received %v", test.name, string(expected), output)
+		}
+	}
+}
+
+func TestPlaySoundSSMLInvalid(t *testing.T) {
+	invalid := []RAPlaySound{
+		{SoundType: RAPlaySoundTypeBreak, Val: SSMLBreak{Strength: "enormous"}},
+		{SoundType: RAPlaySoundTypeEmphasis, Val: SSMLEmphasis{Text: "Hi", Level: "shouting"}},
+		{SoundType: RAPlaySoundTypeSayAs, Val: SSMLSayAs{Text: "Hi", InterpretAs: "interpretive-dance"}},
+		{SoundType: RAPlaySoundTypeVoice, Val: SSMLVoice{Text: "Hi"}},
+	}
+	for _, sound := range invalid {
+		message := AIRequest{OutputSSML: ssml.NewBuilder()}
+		err := sound.Execute(&message)
+		if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorInvalidParam {
+			t.Errorf("%+v: expected a RuntimeErrorInvalidParam, received %v", sound, err)
+		}
+	}
+
+	truncated := RAPlaySound{SoundType: RAPlaySoundTypeProsody, Val: SSMLProsody{Text: "Hello"}}.Compile()
+	if err := new(RAPlaySound).CreateFrom(truncated[:4]); err == nil {
+		t.Error("Expected an error decoding a truncated RAPlaySound")
+	}
+}
+
+func TestPlaySoundUnmarshalJSON(t *testing.T) {
+	sound := RAPlaySound{}
+	err := json.Unmarshal([]byte(`{"SoundType":6,"Val":{"Text":"Hello","Name":"Brian"}}`), &sound)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (SSMLVoice{Text: "Hello", Name: "Brian"}); sound.Val != expected {
+		t.Errorf("Expected %+v, received %+v", expected, sound.Val)
+	}
+
+	if err := json.Unmarshal([]byte(`{"SoundType":0,"Val":"Hello"}`), &sound); err != nil {
+		t.Fatal(err)
+	}
+	if sound.Val != "Hello" {
+		t.Errorf("Expected the text Hello, received %v", sound.Val)
+	}
+}
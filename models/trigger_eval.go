@@ -0,0 +1,98 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/talkative-ai/core/redis"
+)
+
+// MaxVariableUpdateDepth is the maximum number of nested TriggerVariableUpdate evaluations
+// Actions within a variable update trigger may update variables themselves,
+// which cascades into another evaluation of the trigger
+const MaxVariableUpdateDepth = 8
+
+// MaxZoneChangeDepth is the maximum number of nested RASetZone executions
+// Actions within a zone trigger may change the zone themselves,
+// which fires the triggers of another zone, e.g. an enter trigger of A moving to B and back
+const MaxZoneChangeDepth = 8
+
+// variableUpdateCascade tracks the TriggerVariableUpdate evaluations in progress
+type variableUpdateCascade struct {
+	depth int
+	// firing contains the statement blocks currently executing
+	// A statement block is never re-entered by its own actions
+	firing map[string]bool
+}
+
+// fireVariableUpdateTrigger evaluates the TriggerVariableUpdate of the current zone
+// after a variable has changed. previous contains the variables before the change
+//
+// AlwaysExec is executed on every change. Each statement block is edge-triggered:
+// its selected LStatement only executes if it differs from the LStatement
+// which would have been selected before the change.
+// e.g. "if gold > 100" fires when gold goes above 100, but not while it stays above 100
+func fireVariableUpdateTrigger(message *AIRequest, previous map[string]*ARVariable) error {
+	zoneID := message.State.Zone.String()
+	key := KeynavCompiledTriggersWithinZone(message.State.PubID, zoneID)
+	res, err := redis.Runtime.HGet(key, fmt.Sprintf("%v", TriggerVariableUpdate))
+	if err != nil {
+		return newRuntimeError(RuntimeErrorStore, "Error fetching zone triggers: %s", err.Error())
+	}
+
+	// There is no variable update trigger
+	if res == "" {
+		return nil
+	}
+
+	cascade := &message.variableUpdates
+	if cascade.depth >= MaxVariableUpdateDepth {
+		return newRuntimeError(RuntimeErrorCascadeLimit,
+			"Variable update triggers exceeded the maximum depth of %v", MaxVariableUpdateDepth)
+	}
+	cascade.depth++
+	defer func() { cascade.depth-- }()
+
+	block := LBlock{}
+	if err := block.CreateFrom([]byte(res)); err != nil {
+		rerr := newRuntimeError(RuntimeErrorLogic, "%s", err.Error())
+		rerr.BundleKey = key
+		rerr.located = true
+		return rerr
+	}
+
+	if block.AlwaysExec != "" {
+		if err := ActionBundleEvalKey(message, block.AlwaysExec); err != nil {
+			return err
+		}
+	}
+
+	if block.Statements == nil {
+		return nil
+	}
+
+	before := message.State
+	before.ARVariables = previous
+	for i, stmts := range *block.Statements {
+		firingKey := fmt.Sprintf("%v:%v", zoneID, i)
+		if cascade.firing[firingKey] {
+			continue
+		}
+
+		selected := selectStatement(stmts, &message.State)
+		if selected < 0 || selected == selectStatement(stmts, &before) {
+			continue
+		}
+
+		if cascade.firing == nil {
+			cascade.firing = map[string]bool{}
+		}
+		cascade.firing[firingKey] = true
+		err := ActionBundleEvalKey(message, stmts[selected].Exec)
+		delete(cascade.firing, firingKey)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
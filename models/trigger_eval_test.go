@@ -0,0 +1,100 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+func TestVariableUpdateTrigger(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	pubID := "1"
+	zoneID := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	fanfareKey := KeynavCompiledTriggerActionBundle(pubID, zoneID.String(), uint64(TriggerVariableUpdate), 1)
+	store.Set(fanfareKey, CompileActionBundle(&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: "Fanfare!"}))
+
+	// When gold (variable 1) goes above 100, play a fanfare
+	statements := [][]LStatement{{
+		{Exec: fanfareKey, Operators: &OrGroup{AndGroup{OpStrGT: VarValMap{1: int64(100)}}}},
+	}}
+	store.HSet(KeynavCompiledTriggersWithinZone(pubID, zoneID.String()),
+		fmt.Sprintf("%v", TriggerVariableUpdate), mustCompile(t, LBlock{Statements: &statements}))
+
+	state := MutableAIRequestState{
+		PubID:       pubID,
+		Zone:        zoneID,
+		ARVariables: map[string]*ARVariable{"1": {T: "int", Val: int64(50)}},
+	}
+
+	tests := []struct {
+		gold    int64
+		fanfare bool
+	}{
+		{90, false},
+		{150, true},
+		{160, false},
+		{10, false},
+		{200, true},
+	}
+
+	for _, test := range tests {
+		message := AIRequest{State: state, OutputSSML: ssml.NewBuilder()}
+		setGold := RASetVariable{Target: "1", Operation: SVOSet, With: ParametizedARVariable{
+			ARVariable: &ARVariable{T: "int", Val: test.gold},
+		}}
+		if err := setGold.Execute(&message); err != nil {
+			t.Fatal(err)
+		}
+		if fanfare := message.OutputSSML.String() == "<speak>Fanfare!</speak>"; fanfare != test.fanfare {
+			t.Errorf("Gold %v: expected fanfare %v, received output %v",
+				test.gold, test.fanfare, message.OutputSSML.String())
+		}
+		state = message.State
+	}
+}
+
+func TestVariableUpdateTriggerCascadeLimit(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	pubID := "1"
+	zoneID := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+
+	// Every change to a variable increments the tick counter, which is itself a change
+	tickKey := KeynavCompiledTriggerActionBundle(pubID, zoneID.String(), uint64(TriggerVariableUpdate), 0)
+	store.Set(tickKey, CompileActionBundle(&RASetVariable{Target: "ticks", Operation: SVOAdd, With: ParametizedARVariable{
+		ARVariable: &ARVariable{T: "int", Val: int64(1)},
+	}}))
+	store.HSet(KeynavCompiledTriggersWithinZone(pubID, zoneID.String()),
+		fmt.Sprintf("%v", TriggerVariableUpdate), mustCompile(t, LBlock{AlwaysExec: tickKey}))
+
+	message := AIRequest{
+		State: MutableAIRequestState{
+			PubID:       pubID,
+			Zone:        zoneID,
+			ARVariables: map[string]*ARVariable{"ticks": {T: "int", Val: int64(0)}},
+		},
+		OutputSSML: ssml.NewBuilder(),
+	}
+
+	tick := RASetVariable{Target: "ticks", Operation: SVOAdd, With: ParametizedARVariable{
+		ARVariable: &ARVariable{T: "int", Val: int64(1)},
+	}}
+	err := tick.Execute(&message)
+	if rerr, ok := err.(*RuntimeError); !ok || rerr.Code != RuntimeErrorCascadeLimit {
+		t.Fatalf("Expected a RuntimeErrorCascadeLimit, received %v", err)
+	}
+	if ticks := message.State.ARVariables["ticks"].Val; ticks != int64(MaxVariableUpdateDepth+1) {
+		t.Errorf("Expected %v ticks, received %v", MaxVariableUpdateDepth+1, ticks)
+	}
+	if message.variableUpdates.depth != 0 {
+		t.Errorf("Expected the cascade depth to unwind, received %v", message.variableUpdates.depth)
+	}
+}
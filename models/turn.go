@@ -0,0 +1,188 @@
+package models
+
+import (
+	"strings"
+
+	"github.com/talkative-ai/core/redis"
+)
+
+// TurnResult is the outcome of a single conversational turn
+type TurnResult struct {
+	// DialogID is the ID of the dialog node which handled the input
+	// nil if no dialog node matched and there was no unknown handler
+	DialogID *string
+	// Unknown is true if the input was handled by an unknown handler
+	Unknown bool
+	// ItemID is the ID of the item asked about if the input was
+	// a possessional question answered from the inventory
+	ItemID *string
+	// SSML is the output of the turn
+	SSML string
+	// State is the runtime state after the turn
+	State MutableAIRequestState
+}
+
+// PrepareTurnInput normalizes a raw utterance for matching against compiled entry inputs
+// Lakshmi is expected to compile DialogNode EntryInputs with the same preparation
+func PrepareTurnInput(input string) string {
+	return strings.ToLower(strings.TrimSpace(DialogInput(input).Prepared()))
+}
+
+// RunTurn runs one full conversational turn of a published project
+//
+// The input is matched in the following order:
+// 1. The children of the CurrentDialog
+// 2. The root dialogs of every actor within the current zone
+// 3. A possessional question about an item of the project, answered from the inventory
+// 4. The unknown handler of the CurrentDialog
+// 5. The root unknown handler of every actor within the current zone
+//
+// The logic block of the matched dialog node is evaluated against the AIRequest,
+// which is mutated in place. If the matched dialog node has no children
+// and no unknown handler, the conversation ends and CurrentDialog is reset.
+// An unknown handler without children instead keeps the conversation where it was
+//
+// Before the input is matched, the turn counter is advanced and every due Schedule is evaluated
+func RunTurn(message *AIRequest, input string) (TurnResult, error) {
+	if err := beginTurn(message); err != nil {
+		return TurnResult{State: message.State}, err
+	}
+
+	prepared := PrepareTurnInput(input)
+	dialogID, err := matchDialogInput(message.State, prepared)
+	if err != nil {
+		return TurnResult{State: message.State}, err
+	}
+
+	unknown := false
+	if dialogID == "" {
+		itemID, err := answerPossessional(message, prepared)
+		if err != nil {
+			return TurnResult{State: message.State}, err
+		}
+		if itemID != "" {
+			result := TurnResult{ItemID: &itemID, SSML: message.OutputSSML.String()}
+			message.State.PreviousResponse = result.SSML
+			result.State = message.State
+			return result, nil
+		}
+
+		dialogID, err = matchUnknownHandler(message.State)
+		if err != nil {
+			return TurnResult{State: message.State}, err
+		}
+		unknown = dialogID != ""
+	}
+
+	if dialogID != "" {
+		var fallback *string
+		if unknown {
+			fallback = message.State.CurrentDialog
+		}
+		if err := runDialogNode(message, dialogID, fallback); err != nil {
+			return TurnResult{State: message.State}, err
+		}
+	}
+
+	result := TurnResult{
+		Unknown: unknown,
+		SSML:    message.OutputSSML.String(),
+	}
+	if dialogID != "" {
+		result.DialogID = &dialogID
+		message.State.PreviousResponse = result.SSML
+	}
+	result.State = message.State
+
+	return result, nil
+}
+
+// matchDialogInput returns the ID of the dialog node whose entry input is the prepared input
+// Returns an empty dialogID if there is no match
+func matchDialogInput(state MutableAIRequestState, input string) (dialogID string, err error) {
+	actors := state.ZoneActors[state.Zone]
+
+	inputKeys := []string{}
+	if state.CurrentDialog != nil {
+		inputKeys = append(inputKeys, KeynavCompiledDialogNode(state.PubID, *state.CurrentDialog))
+	}
+	for _, actorID := range actors {
+		inputKeys = append(inputKeys, KeynavCompiledDialogRootWithinActor(state.PubID, actorID))
+	}
+
+	for _, key := range inputKeys {
+		id, err := redis.Runtime.HGet(key, input)
+		if err != nil {
+			return "", newRuntimeError(RuntimeErrorStore, "Error fetching dialog inputs: %s", err.Error())
+		}
+		if id != "" {
+			return id, nil
+		}
+	}
+
+	return "", nil
+}
+
+// matchUnknownHandler returns the ID of the dialog node which handles unmatched input
+// Returns an empty dialogID if there is no unknown handler
+func matchUnknownHandler(state MutableAIRequestState) (dialogID string, err error) {
+	actors := state.ZoneActors[state.Zone]
+
+	unknownKeys := []string{}
+	if state.CurrentDialog != nil {
+		unknownKeys = append(unknownKeys, KeynavCompiledDialogNodeUnknown(state.PubID, *state.CurrentDialog))
+	}
+	for _, actorID := range actors {
+		unknownKeys = append(unknownKeys, KeynavCompiledDialogRootUnknownWithinActor(state.PubID, actorID))
+	}
+
+	for _, key := range unknownKeys {
+		id, err := redis.Runtime.Get(key)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return "", newRuntimeError(RuntimeErrorStore, "Error fetching unknown handler: %s", err.Error())
+		}
+		return string(id), nil
+	}
+
+	return "", nil
+}
+
+// runDialogNode makes the dialog node the CurrentDialog and evaluates its logic block
+// If the dialog node ends the conversation, the CurrentDialog is set to fallback
+func runDialogNode(message *AIRequest, dialogID string, fallback *string) error {
+	key := KeynavCompiledEntity(message.State.PubID, AEIDDialogNode, dialogID)
+	compiled, err := redis.Runtime.Get(key)
+	if err != nil {
+		return newRuntimeError(RuntimeErrorNotFound, "Error fetching dialog %v: %s", dialogID, err.Error())
+	}
+
+	message.State.CurrentDialog = &dialogID
+	if err := evalLogicBlock(message, key, compiled); err != nil {
+		return err
+	}
+
+	// The logic block may have started another dialog or changed the zone
+	if message.State.CurrentDialog == nil || *message.State.CurrentDialog != dialogID {
+		return nil
+	}
+
+	children, err := redis.Runtime.HGetAll(KeynavCompiledDialogNode(message.State.PubID, dialogID))
+	if err != nil {
+		return newRuntimeError(RuntimeErrorStore, "Error fetching dialog children: %s", err.Error())
+	}
+	if len(children) > 0 {
+		return nil
+	}
+	_, err = redis.Runtime.Get(KeynavCompiledDialogNodeUnknown(message.State.PubID, dialogID))
+	if err == redis.Nil {
+		message.State.CurrentDialog = fallback
+		return nil
+	}
+	if err != nil {
+		return newRuntimeError(RuntimeErrorStore, "Error fetching unknown handler: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// storeDialogNode compiles a dialog node which speaks text into the store
+func storeDialogNode(t testing.TB, store *redis.MemoryStore, pubID, dialogID, text string) {
+	bundleKey := KeynavCompiledDialogNodeActionBundle(pubID, dialogID, 0)
+	store.Set(bundleKey, CompileActionBundle(&RAPlaySound{SoundType: RAPlaySoundTypeText, Val: text}))
+	store.Set(KeynavCompiledEntity(pubID, AEIDDialogNode, dialogID), mustCompile(t, LBlock{AlwaysExec: bundleKey}))
+}
+
+func TestRunTurn(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	pubID := "1"
+	zoneID := uuid.FromStringOrNil("6ba7b810-9dad-11d1-80b4-00c04fd430c8")
+	actorID := "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+
+	storeDialogNode(t, store, pubID, "greet", "Hello traveller. Buy something?")
+	storeDialogNode(t, store, pubID, "buy", "Here is your sword.")
+	storeDialogNode(t, store, pubID, "confused", "Buy something or leave.")
+	storeDialogNode(t, store, pubID, "shrug", "The shopkeeper shrugs.")
+
+	store.HSet(KeynavCompiledDialogRootWithinActor(pubID, actorID), "hello", []byte("greet"))
+	store.Set(KeynavCompiledDialogRootUnknownWithinActor(pubID, actorID), []byte("shrug"))
+	store.HSet(KeynavCompiledDialogNode(pubID, "greet"), "yes please", []byte("buy"))
+	store.Set(KeynavCompiledDialogNodeUnknown(pubID, "greet"), []byte("confused"))
+
+	state := MutableAIRequestState{
+		PubID:      pubID,
+		Zone:       zoneID,
+		ZoneActors: map[uuid.UUID][]string{zoneID: {actorID}},
+	}
+
+	tests := []struct {
+		input         string
+		dialogID      string
+		unknown       bool
+		ssml          string
+		currentDialog string
+	}{
+		{"Hello!", "greet", false, "<speak>Hello traveller. Buy something?</speak>", "greet"},
+		{"What?", "confused", true, "<speak>Buy something or leave.</speak>", "greet"},
+		{"Yes, please", "buy", false, "<speak>Here is your sword.</speak>", ""},
+		{"Dance", "shrug", true, "<speak>The shopkeeper shrugs.</speak>", ""},
+	}
+
+	for _, test := range tests {
+		message := AIRequest{State: state, OutputSSML: ssml.NewBuilder()}
+		result, err := RunTurn(&message, test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.DialogID == nil || *result.DialogID != test.dialogID || result.Unknown != test.unknown {
+			t.Errorf("%q: expected dialog %v (unknown %v), received %v (unknown %v)",
+				test.input, test.dialogID, test.unknown, result.DialogID, result.Unknown)
+		}
+		if result.SSML != test.ssml {
+			t.Errorf("%q: unexpected SSML %v", test.input, result.SSML)
+		}
+		current := ""
+		if result.State.CurrentDialog != nil {
+			current = *result.State.CurrentDialog
+		}
+		if current != test.currentDialog {
+			t.Errorf("%q: expected CurrentDialog %q, received %q", test.input, test.currentDialog, current)
+		}
+		state = result.State
+	}
+
+	// Without any actors in the zone nothing can match
+	state.ZoneActors = map[uuid.UUID][]string{}
+	message := AIRequest{State: state, OutputSSML: ssml.NewBuilder()}
+	result, err := RunTurn(&message, "Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.DialogID != nil {
+		t.Errorf("Expected no match, received %v", *result.DialogID)
+	}
+}
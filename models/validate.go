@@ -0,0 +1,619 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+// DiagnosticCode identifies a kind of problem found by Validate
+type DiagnosticCode string
+
+const (
+	// DiagnosticDialogHangingOpen a dialog node without children which does not exit the conversation
+	DiagnosticDialogHangingOpen DiagnosticCode = "dialog_hanging_open"
+	// DiagnosticDialogCycle dialog nodes which only lead to each other and never exit the conversation
+	DiagnosticDialogCycle DiagnosticCode = "dialog_cycle"
+	// DiagnosticDuplicateEntryInput sibling dialog nodes which share an entry input
+	DiagnosticDuplicateEntryInput DiagnosticCode = "duplicate_entry_input"
+	// DiagnosticZoneEntryHangingOpen a zone without actors whose triggers do not move on upon entering it
+	DiagnosticZoneEntryHangingOpen DiagnosticCode = "zone_entry_hanging_open"
+	// DiagnosticZoneUnreachable a zone which no path leads to from the start zone
+	DiagnosticZoneUnreachable DiagnosticCode = "zone_unreachable"
+	// DiagnosticMissingZone the start zone or an RASetZone refers to a zone which does not exist
+	DiagnosticMissingZone DiagnosticCode = "missing_zone"
+	// DiagnosticUndeclaredVariable a variable which is read but not declared by the project
+	DiagnosticUndeclaredVariable DiagnosticCode = "undeclared_variable"
+	// DiagnosticInvalidSoundTemplate a sound whose text is not a valid SoundTemplate
+	DiagnosticInvalidSoundTemplate DiagnosticCode = "invalid_sound_template"
+	// DiagnosticInvalidCondition a statement whose condition is not valid, see Condition.Validate
+	DiagnosticInvalidCondition DiagnosticCode = "invalid_condition"
+)
+
+// Diagnostic is a single problem found by Validate
+type Diagnostic struct {
+	Code    DiagnosticCode
+	Message string
+	// EntityType and EntityID identify the entity with the problem
+	// Problems with a trigger are reported against its zone
+	EntityType AEID
+	EntityID   uuid.UUID
+	// Related are the IDs of other entities involved in the problem
+	// e.g. the missing zone, the sibling with the same entry input, or every node of a cycle
+	Related []uuid.UUID `json:",omitempty"`
+}
+
+// MinorProblem returns the ReviewMinorProblem a reviewer would flag for the Diagnostic, if any
+func (d Diagnostic) MinorProblem() (ReviewMinorProblem, bool) {
+	switch d.Code {
+	case DiagnosticDialogHangingOpen, DiagnosticDialogCycle:
+		return ReviewMinorProblemConversationHangingOpen, true
+	case DiagnosticZoneEntryHangingOpen:
+		return ReviewMinorProblemZoneEntryHangingOpen, true
+	}
+	return 0, false
+}
+
+// projectValidator holds the indexes of a project shared by each of the checks of Validate
+type projectValidator struct {
+	project     Project
+	diagnostics []Diagnostic
+
+	zones    map[uuid.UUID]*Zone
+	actors   map[uuid.UUID]*Actor
+	nodes    map[uuid.UUID]*DialogNode
+	children map[uuid.UUID][]uuid.UUID
+	declared map[string]bool
+	// legacyNames resolves the legacy numeric variables of conditions, see LegacyVariableNames
+	legacyNames map[string]string
+}
+
+// Validate statically checks the graph of a project before it is published
+// The project is expected to be fully loaded with its zones, triggers, actors, dialogs and variables
+// Diagnostics are sorted by code, then by entity
+func Validate(project Project) []Diagnostic {
+	v := &projectValidator{
+		project:     project,
+		diagnostics: []Diagnostic{},
+		zones:       map[uuid.UUID]*Zone{},
+		actors:      map[uuid.UUID]*Actor{},
+		nodes:       map[uuid.UUID]*DialogNode{},
+		children:    map[uuid.UUID][]uuid.UUID{},
+		declared:    map[string]bool{},
+		legacyNames: LegacyVariableNames(project.Variables),
+	}
+
+	for i := range project.Zones {
+		v.zones[project.Zones[i].ID] = &project.Zones[i]
+	}
+	for i := range project.Variables {
+		v.declared[project.Variables[i].Name] = true
+	}
+	for i := range project.Actors {
+		actor := &project.Actors[i]
+		v.actors[actor.ID] = actor
+		for j := range actor.Dialogs {
+			v.nodes[actor.Dialogs[j].ID] = &actor.Dialogs[j]
+		}
+	}
+	for i := range project.Actors {
+		for _, relation := range project.Actors[i].DialogRelations {
+			v.addChild(relation.ParentNodeID.UUID, relation.ChildNodeID.UUID)
+		}
+		for _, node := range project.Actors[i].Dialogs {
+			if node.ChildNodes == nil {
+				continue
+			}
+			for _, child := range *node.ChildNodes {
+				v.addChild(node.ID, child.ID)
+			}
+		}
+	}
+
+	v.validateDialogs()
+	v.validateDialogCycles()
+	v.validateZones()
+	v.validateVariables()
+	v.validateSounds()
+	v.validateConditions()
+
+	sort.SliceStable(v.diagnostics, func(i, j int) bool {
+		a, b := v.diagnostics[i], v.diagnostics[j]
+		if a.Code != b.Code {
+			return a.Code < b.Code
+		}
+		if a.EntityID != b.EntityID {
+			return a.EntityID.String() < b.EntityID.String()
+		}
+		return a.Message < b.Message
+	})
+	return v.diagnostics
+}
+
+func (v *projectValidator) report(code DiagnosticCode, entityType AEID, entityID uuid.UUID, related []uuid.UUID, format string, args ...interface{}) {
+	v.diagnostics = append(v.diagnostics, Diagnostic{
+		Code:       code,
+		Message:    fmt.Sprintf(format, args...),
+		EntityType: entityType,
+		EntityID:   entityID,
+		Related:    related,
+	})
+}
+
+// addChild records the relation if both dialog nodes exist and it is not yet recorded
+func (v *projectValidator) addChild(parent, child uuid.UUID) {
+	if v.nodes[parent] == nil || v.nodes[child] == nil {
+		return
+	}
+	for _, existing := range v.children[parent] {
+		if existing == child {
+			return
+		}
+	}
+	v.children[parent] = append(v.children[parent], child)
+}
+
+// dialogActionSets returns the AlwaysExec and every Exec ActionSet of the block
+func dialogActionSets(block RawLBlock) []ActionSet {
+	sets := []ActionSet{block.AlwaysExec}
+	if block.Statements == nil {
+		return sets
+	}
+	for _, stmts := range *block.Statements {
+		for _, stmt := range stmts {
+			sets = append(sets, stmt.Exec)
+		}
+	}
+	return sets
+}
+
+// exits yields true if the dialog node ends or moves on from the conversation
+// i.e. it changes the zone, resets the app, starts a conversation with an actor,
+// or it is entered by the user saying farewell
+func (v *projectValidator) exits(node *DialogNode) bool {
+	for _, input := range node.EntryInput {
+		if input == DialogInputFarewell {
+			return true
+		}
+	}
+	for _, set := range dialogActionSets(node.RawLBlock) {
+		if movesOn(set) {
+			return true
+		}
+	}
+	return false
+}
+
+// movesOn yields true if the ActionSet changes the zone, resets the app, or starts a conversation with an actor
+func movesOn(set ActionSet) bool {
+	return uuid.UUID(set.SetZone) != uuid.Nil || bool(set.ResetApp) || set.InitializeActorDialog != uuid.Nil
+}
+
+// validateDialogs reports dialog nodes hanging open and duplicate entry inputs among siblings
+func (v *projectValidator) validateDialogs() {
+	for _, actor := range v.project.Actors {
+		roots := []uuid.UUID{}
+		for i := range actor.Dialogs {
+			node := &actor.Dialogs[i]
+			if node.IsRoot {
+				roots = append(roots, node.ID)
+			}
+			if len(v.children[node.ID]) == 0 && !node.UnknownHandler && !v.exits(node) {
+				v.report(DiagnosticDialogHangingOpen, AEIDDialogNode, node.ID, nil,
+					"Dialog of %v has no replies and does not exit the conversation", actor.Title)
+			}
+			v.validateSiblings(v.children[node.ID])
+		}
+		v.validateSiblings(roots)
+	}
+}
+
+// validateSiblings reports dialog nodes with an entry input of an earlier sibling
+func (v *projectValidator) validateSiblings(siblings []uuid.UUID) {
+	inputs := map[string]uuid.UUID{}
+	for _, id := range siblings {
+		node := v.nodes[id]
+		if node.UnknownHandler {
+			continue
+		}
+		reported := map[string]bool{}
+		for _, input := range node.EntryInput {
+			prepared := PrepareTurnInput(string(input))
+			if prepared == "" {
+				continue
+			}
+			first, ok := inputs[prepared]
+			if !ok {
+				inputs[prepared] = id
+				continue
+			}
+			if first != id && !reported[prepared] {
+				reported[prepared] = true
+				v.report(DiagnosticDuplicateEntryInput, AEIDDialogNode, id, []uuid.UUID{first},
+					"Entry input %q is shared with a sibling dialog", string(input))
+			}
+		}
+	}
+}
+
+// validateDialogCycles reports each strongly connected set of dialog nodes
+// which has no relation to a node outside of it and none of which exit the conversation
+func (v *projectValidator) validateDialogCycles() {
+	ids := []uuid.UUID{}
+	for id := range v.nodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	// Tarjan's strongly connected components
+	index := map[uuid.UUID]int{}
+	low := map[uuid.UUID]int{}
+	onStack := map[uuid.UUID]bool{}
+	stack := []uuid.UUID{}
+	components := [][]uuid.UUID{}
+
+	var connect func(id uuid.UUID)
+	connect = func(id uuid.UUID) {
+		index[id] = len(index)
+		low[id] = index[id]
+		stack = append(stack, id)
+		onStack[id] = true
+
+		for _, child := range v.children[id] {
+			if _, visited := index[child]; !visited {
+				connect(child)
+				if low[child] < low[id] {
+					low[id] = low[child]
+				}
+			} else if onStack[child] && index[child] < low[id] {
+				low[id] = index[child]
+			}
+		}
+
+		if low[id] != index[id] {
+			return
+		}
+		component := []uuid.UUID{}
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == id {
+				break
+			}
+		}
+		components = append(components, component)
+	}
+	for _, id := range ids {
+		if _, visited := index[id]; !visited {
+			connect(id)
+		}
+	}
+
+	for _, component := range components {
+		members := map[uuid.UUID]bool{}
+		for _, id := range component {
+			members[id] = true
+		}
+		cyclic := len(component) > 1
+		closed := true
+		for _, id := range component {
+			if v.exits(v.nodes[id]) {
+				closed = false
+			}
+			for _, child := range v.children[id] {
+				if child == id {
+					cyclic = true
+				}
+				if !members[child] {
+					closed = false
+				}
+			}
+		}
+		if !cyclic || !closed {
+			continue
+		}
+
+		sort.Slice(component, func(i, j int) bool { return component[i].String() < component[j].String() })
+		v.report(DiagnosticDialogCycle, AEIDDialogNode, component[0], component,
+			"%v dialogs only lead to each other and never exit the conversation", len(component))
+	}
+}
+
+// zoneActionSets returns every ActionSet evaluated within the zone
+// i.e. those of its triggers, and of the dialogs of the actors within it or initialized from it
+// Dialogs are attributed to the first zone they are found in
+func (v *projectValidator) zoneActionSets(zoneID uuid.UUID) []ActionSet {
+	sets := []ActionSet{}
+	if zone := v.zones[zoneID]; zone != nil {
+		for _, trigger := range zone.Triggers {
+			sets = append(sets, dialogActionSets(trigger.RawLBlock)...)
+		}
+	}
+
+	actors := []uuid.UUID{}
+	for _, za := range v.project.ZoneActors {
+		if za.ZoneID.UUID == zoneID {
+			actors = append(actors, za.ActorID.UUID)
+		}
+	}
+	for _, set := range sets {
+		if set.InitializeActorDialog != uuid.Nil {
+			actors = append(actors, set.InitializeActorDialog)
+		}
+	}
+
+	seen := map[uuid.UUID]bool{}
+	for len(actors) > 0 {
+		id := actors[0]
+		actors = actors[1:]
+		actor := v.actors[id]
+		if seen[id] || actor == nil {
+			continue
+		}
+		seen[id] = true
+		for _, node := range actor.Dialogs {
+			for _, set := range dialogActionSets(node.RawLBlock) {
+				sets = append(sets, set)
+				if set.InitializeActorDialog != uuid.Nil {
+					actors = append(actors, set.InitializeActorDialog)
+				}
+			}
+		}
+	}
+
+	return sets
+}
+
+// validateZones reports missing zones, zones entered hanging open, and zones unreachable from the start zone
+func (v *projectValidator) validateZones() {
+	// Zones where the user has no one to talk to once the triggers upon entering have run
+	withActors := map[uuid.UUID]bool{}
+	for _, za := range v.project.ZoneActors {
+		if v.actors[za.ActorID.UUID] != nil {
+			withActors[za.ZoneID.UUID] = true
+		}
+	}
+	for _, zone := range v.project.Zones {
+		if withActors[zone.ID] {
+			continue
+		}
+		hangingOpen := true
+		for _, triggerType := range []TriggerType{TriggerInitializeZone, TriggerEnterZone} {
+			trigger, ok := zone.Triggers[triggerType]
+			if !ok {
+				continue
+			}
+			for _, set := range dialogActionSets(trigger.RawLBlock) {
+				if movesOn(set) {
+					hangingOpen = false
+				}
+			}
+		}
+		if hangingOpen {
+			v.report(DiagnosticZoneEntryHangingOpen, AEIDZone, zone.ID, nil,
+				"%v has no actors and entering it does not move on from the zone", zone.Title)
+		}
+	}
+
+	// RASetZone pointing at zones which do not exist
+	for _, zone := range v.project.Zones {
+		for triggerType, trigger := range zone.Triggers {
+			for _, set := range dialogActionSets(trigger.RawLBlock) {
+				if target := uuid.UUID(set.SetZone); target != uuid.Nil && v.zones[target] == nil {
+					v.report(DiagnosticMissingZone, AEIDZone, zone.ID, []uuid.UUID{target},
+						"Trigger %v of %v moves to a zone which does not exist", triggerType, zone.Title)
+				}
+			}
+		}
+	}
+	for _, actor := range v.project.Actors {
+		for _, node := range actor.Dialogs {
+			for _, set := range dialogActionSets(node.RawLBlock) {
+				if target := uuid.UUID(set.SetZone); target != uuid.Nil && v.zones[target] == nil {
+					v.report(DiagnosticMissingZone, AEIDDialogNode, node.ID, []uuid.UUID{target},
+						"Dialog of %v moves to a zone which does not exist", actor.Title)
+				}
+			}
+		}
+	}
+
+	if !v.project.StartZoneID.Valid {
+		v.report(DiagnosticMissingZone, AEIDProject, v.project.ID, nil, "The project has no start zone")
+		return
+	}
+	start := v.project.StartZoneID.UUID
+	if v.zones[start] == nil {
+		v.report(DiagnosticMissingZone, AEIDProject, v.project.ID, []uuid.UUID{start}, "The start zone does not exist")
+		return
+	}
+
+	reached := map[uuid.UUID]bool{start: true}
+	queue := []uuid.UUID{start}
+	for len(queue) > 0 {
+		zoneID := queue[0]
+		queue = queue[1:]
+		for _, set := range v.zoneActionSets(zoneID) {
+			target := uuid.UUID(set.SetZone)
+			if v.zones[target] != nil && !reached[target] {
+				reached[target] = true
+				queue = append(queue, target)
+			}
+		}
+	}
+	for _, zone := range v.project.Zones {
+		if !reached[zone.ID] {
+			v.report(DiagnosticZoneUnreachable, AEIDZone, zone.ID, nil,
+				"%v cannot be reached from the start zone", zone.Title)
+		}
+	}
+}
+
+// conditionVariables appends the names of the variables read by the Condition
+func conditionVariables(names []string, c *Condition) []string {
+	if c == nil {
+		return names
+	}
+	if c.Type != ConditionCompare {
+		for i := range c.Children {
+			names = conditionVariables(names, &c.Children[i])
+		}
+		return names
+	}
+	if c.Var != "" && !stateOperators[c.Operator] {
+		names = append(names, c.Var)
+	}
+	if c.OtherVar != "" {
+		names = append(names, c.OtherVar)
+	}
+	return names
+}
+
+// soundText returns the text spoken by the sound, or an empty string for audio
+func soundText(sound RAPlaySound) string {
+	if sound.SoundType == RAPlaySoundTypeAudio {
+		return ""
+	}
+	switch v := sound.Val.(type) {
+	case string:
+		return v
+	case SSMLProsody:
+		return v.Text
+	case SSMLEmphasis:
+		return v.Text
+	case SSMLSayAs:
+		return v.Text
+	case SSMLVoice:
+		return v.Text
+	}
+	return ""
+}
+
+// soundVariables appends the names of the variables read by the text of the sound
+func soundVariables(names []string, sound RAPlaySound) []string {
+	text := soundText(sound)
+	if text == "" {
+		return names
+	}
+	tmpl, err := ParseSoundTemplate(text)
+	if err != nil {
+		return names
+	}
+	return append(names, tmpl.Variables()...)
+}
+
+// actionSetSounds returns every sound of the ActionSet, including each variant of a choice
+func actionSetSounds(set ActionSet) []RAPlaySound {
+	sounds := append([]RAPlaySound{}, set.PlaySounds...)
+	for _, choice := range set.ChooseSounds {
+		sounds = append(sounds, choice.Variants...)
+	}
+	return sounds
+}
+
+// actionSetVariables appends the names of the variables read by the actions of the ActionSet
+func actionSetVariables(names []string, set ActionSet) []string {
+	for _, sound := range actionSetSounds(set) {
+		names = soundVariables(names, sound)
+	}
+	for _, sv := range set.SetGlobalVariables {
+		if sv.Operation != SVOSet && sv.Operation != SVORandomInt {
+			names = append(names, sv.Target)
+		}
+		if sv.With.Key != nil {
+			names = append(names, *sv.With.Key)
+		}
+	}
+	return names
+}
+
+// blockVariables returns the names of the variables read by the conditions and actions of the block
+func blockVariables(block RawLBlock) []string {
+	names := actionSetVariables([]string{}, block.AlwaysExec)
+	if block.Statements == nil {
+		return names
+	}
+	for _, stmts := range *block.Statements {
+		for _, stmt := range stmts {
+			condition := stmt.Condition
+			if condition == nil {
+				condition = stmt.Operators.Condition()
+			}
+			names = conditionVariables(names, condition)
+			names = actionSetVariables(names, stmt.Exec)
+		}
+	}
+	return names
+}
+
+// eachBlock calls fn with the RawLBlock of every trigger and dialog of the project
+// where describes the trigger or dialog within a Diagnostic message
+func (v *projectValidator) eachBlock(fn func(entityType AEID, entityID uuid.UUID, where string, block RawLBlock)) {
+	for _, zone := range v.project.Zones {
+		triggerTypes := []int{}
+		for triggerType := range zone.Triggers {
+			triggerTypes = append(triggerTypes, int(triggerType))
+		}
+		sort.Ints(triggerTypes)
+		for _, triggerType := range triggerTypes {
+			fn(AEIDZone, zone.ID, fmt.Sprintf("Trigger %v of %v", triggerType, zone.Title),
+				zone.Triggers[TriggerType(triggerType)].RawLBlock)
+		}
+	}
+	for _, actor := range v.project.Actors {
+		for _, node := range actor.Dialogs {
+			fn(AEIDDialogNode, node.ID, fmt.Sprintf("Dialog of %v", actor.Title), node.RawLBlock)
+		}
+	}
+}
+
+// validateVariables reports each variable read by a dialog or trigger which the project does not declare
+func (v *projectValidator) validateVariables() {
+	v.eachBlock(func(entityType AEID, entityID uuid.UUID, where string, block RawLBlock) {
+		reported := map[string]bool{}
+		for _, name := range blockVariables(block) {
+			if legacyName, ok := v.legacyNames[name]; ok {
+				name = legacyName
+			}
+			if name == "" || v.declared[name] || reported[name] {
+				continue
+			}
+			reported[name] = true
+			v.report(DiagnosticUndeclaredVariable, entityType, entityID, nil,
+				"%v reads the undeclared variable %q", where, name)
+		}
+	})
+}
+
+// validateSounds reports each dialog or trigger with a sound which Lakshmi would reject
+func (v *projectValidator) validateSounds() {
+	v.eachBlock(func(entityType AEID, entityID uuid.UUID, where string, block RawLBlock) {
+		for _, set := range dialogActionSets(block) {
+			if err := set.Validate(); err != nil {
+				v.report(DiagnosticInvalidSoundTemplate, entityType, entityID, nil, "%v: %s", where, err.Error())
+			}
+		}
+	})
+}
+
+// validateConditions reports each dialog or trigger with a condition which Lakshmi would reject
+func (v *projectValidator) validateConditions() {
+	v.eachBlock(func(entityType AEID, entityID uuid.UUID, where string, block RawLBlock) {
+		if block.Statements == nil {
+			return
+		}
+		for _, stmts := range *block.Statements {
+			for _, stmt := range stmts {
+				condition := stmt.Condition
+				if condition == nil {
+					condition = stmt.Operators.Condition()
+				}
+				if err := condition.Validate(); err != nil {
+					v.report(DiagnosticInvalidCondition, entityType, entityID, nil, "%v: %s", where, err.Error())
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package models
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	uuid "github.com/talkative-ai/go.uuid"
+)
+
+func validateID(n int) uuid.UUID {
+	return uuid.FromStringOrNil(fmt.Sprintf("6ba7b9%02d-9dad-11d1-80b4-00c04fd430c8", n))
+}
+
+func validateNode(n int, root bool, inputs ...DialogInput) DialogNode {
+	return DialogNode{Model: Model{ID: validateID(n)}, IsRoot: root, EntryInput: inputs}
+}
+
+func validateRelation(parent, child int) DialogRelation {
+	return DialogRelation{ParentNodeID: UUIDCreateID{UUID: validateID(parent)}, ChildNodeID: UUIDCreateID{UUID: validateID(child)}}
+}
+
+// testValidateProject is a project with one of each problem found by Validate
+// Zones are 1 to 3, and zone 9 does not exist. The actor is 10 and its dialogs are 11 to 19
+func testValidateProject() Project {
+	hello := validateNode(11, true, "hello")
+	toShop := validateNode(12, false, "go to the shop")
+	toShop.AlwaysExec.SetZone = RASetZone(validateID(2))
+	// Hangs open, and has the same input as 12
+	toShopAgain := validateNode(13, false, "Go to the shop!")
+	// 14 and 15 lead to each other
+	riddle := validateNode(14, true, "riddle")
+	riddle.AlwaysExec.PlaySounds = []RAPlaySound{{SoundType: RAPlaySoundTypeText, Val: "I know {{riddles}} riddles and {{name|no name}}"}}
+	again := validateNode(15, false, "again")
+	farewell := validateNode(16, true, DialogInputFarewell)
+	helloAgain := validateNode(17, true, "HELLO")
+	helloAgain.AlwaysExec.ResetApp = RAResetApp(true)
+	unknown := validateNode(18, true)
+	unknown.UnknownHandler = true
+	portal := validateNode(19, true, "portal")
+	portal.AlwaysExec.SetZone = RASetZone(validateID(9))
+
+	trigger := Trigger{TriggerType: TriggerEnterZone}
+	trigger.Statements = &RawLStatementUnified{{
+		{Condition: &Condition{Type: ConditionAnd, Children: []Condition{
+			{Type: ConditionCompare, Operator: OpStrGT, Var: "gold", OtherVar: "debt"},
+			{Type: ConditionCompare, Operator: OpStrHasItem, Value: testItemKey.String()},
+		}}},
+		{Operators: &OrGroup{AndGroup{OpStrEQ: VarValMap{7: true}}}},
+	}}
+
+	return Project{
+		Model:       Model{ID: validateID(0)},
+		StartZoneID: uuid.NullUUID{UUID: validateID(1), Valid: true},
+		Zones: []Zone{
+			{Model: Model{ID: validateID(1)}, Title: "Start"},
+			{Model: Model{ID: validateID(2)}, Title: "Shop", Triggers: map[TriggerType]Trigger{TriggerEnterZone: trigger}},
+			{Model: Model{ID: validateID(3)}, Title: "Cave"},
+		},
+		Actors: []Actor{{
+			Model:   Model{ID: validateID(10)},
+			Title:   "Guide",
+			Dialogs: []DialogNode{hello, toShop, toShopAgain, riddle, again, farewell, helloAgain, unknown, portal},
+			DialogRelations: []DialogRelation{
+				validateRelation(11, 12),
+				validateRelation(11, 13),
+				validateRelation(14, 15),
+				validateRelation(15, 14),
+			},
+		}},
+		ZoneActors: []ZoneActor{{ZoneID: UUIDCreateID{UUID: validateID(1)}, ActorID: UUIDCreateID{UUID: validateID(10)}}},
+		Variables:  []ProjectVariable{{Name: "gold", Initial: ARVariable{T: "int", Val: int64(0)}}},
+	}
+}
+
+func TestValidate(t *testing.T) {
+	type found struct {
+		Code     DiagnosticCode
+		EntityID uuid.UUID
+		Related  []uuid.UUID
+	}
+	expected := []found{
+		{DiagnosticDialogCycle, validateID(14), []uuid.UUID{validateID(14), validateID(15)}},
+		{DiagnosticDialogHangingOpen, validateID(13), nil},
+		{DiagnosticDuplicateEntryInput, validateID(13), []uuid.UUID{validateID(12)}},
+		{DiagnosticDuplicateEntryInput, validateID(17), []uuid.UUID{validateID(11)}},
+		{DiagnosticMissingZone, validateID(19), []uuid.UUID{validateID(9)}},
+		{DiagnosticUndeclaredVariable, validateID(2), nil},
+		{DiagnosticUndeclaredVariable, validateID(2), nil},
+		{DiagnosticUndeclaredVariable, validateID(14), nil},
+		{DiagnosticZoneEntryHangingOpen, validateID(2), nil},
+		{DiagnosticZoneEntryHangingOpen, validateID(3), nil},
+		{DiagnosticZoneUnreachable, validateID(3), nil},
+	}
+
+	diagnostics := Validate(testValidateProject())
+	received := []found{}
+	for _, d := range diagnostics {
+		received = append(received, found{d.Code, d.EntityID, d.Related})
+	}
+	if !reflect.DeepEqual(received, expected) {
+		t.Fatalf("Expected %+v, received %+v", expected, diagnostics)
+	}
+
+	messages := []string{
+		`Trigger 1 of Shop reads the undeclared variable "7"`,
+		`Trigger 1 of Shop reads the undeclared variable "debt"`,
+		`Dialog of Guide reads the undeclared variable "riddles"`,
+	}
+	for i, message := range messages {
+		if diagnostics[5+i].Message != message {
+			t.Errorf("Expected %q, received %q", message, diagnostics[5+i].Message)
+		}
+	}
+
+	if problem, ok := diagnostics[1].MinorProblem(); !ok || problem != ReviewMinorProblemConversationHangingOpen {
+		t.Errorf("Expected a hanging open conversation, received %v %v", problem, ok)
+	}
+	if _, ok := diagnostics[2].MinorProblem(); ok {
+		t.Error("Expected no ReviewMinorProblem for a duplicate entry input")
+	}
+	if problem, ok := diagnostics[8].MinorProblem(); !ok || problem != ReviewMinorProblemZoneEntryHangingOpen {
+		t.Errorf("Expected a hanging open zone entry, received %v %v", problem, ok)
+	}
+}
+
+func TestValidateStartZone(t *testing.T) {
+	project := testValidateProject()
+	project.StartZoneID = uuid.NullUUID{}
+	missing := 0
+	for _, d := range Validate(project) {
+		if d.Code == DiagnosticZoneUnreachable {
+			t.Errorf("Unexpected %+v without a start zone", d)
+		}
+		if d.Code == DiagnosticMissingZone && d.EntityType == AEIDProject && d.EntityID == project.ID {
+			missing++
+		}
+	}
+	if missing != 1 {
+		t.Errorf("Expected the missing start zone to be reported against the project once, received %v", missing)
+	}
+
+	// Exits fix every dialog and zone entry problem
+	project = testValidateProject()
+	shop := project.Zones[1].Triggers[TriggerEnterZone]
+	shop.AlwaysExec.InitializeActorDialog = validateID(10)
+	project.Zones[1].Triggers[TriggerEnterZone] = shop
+	actor := &project.Actors[0]
+	actor.Dialogs[2].AlwaysExec.ResetApp = RAResetApp(true)
+	actor.Dialogs[2].EntryInput = DialogInputArray{"go elsewhere"}
+	actor.Dialogs[4].AlwaysExec.InitializeActorDialog = validateID(10)
+	actor.Dialogs[6].EntryInput = DialogInputArray{"hi"}
+	project.Variables = append(project.Variables,
+		ProjectVariable{Name: "debt"}, ProjectVariable{Name: "7"}, ProjectVariable{Name: "riddles"})
+	project.Zones = project.Zones[:2]
+	actor.Dialogs = actor.Dialogs[:8]
+
+	if diagnostics := Validate(project); len(diagnostics) != 0 {
+		t.Errorf("Expected no diagnostics, received %+v", diagnostics)
+	}
+}
+
+func TestValidateSoundTemplates(t *testing.T) {
+	project := testValidateProject()
+	actor := &project.Actors[0]
+	actor.Dialogs[0].AlwaysExec.PlaySounds = []RAPlaySound{
+		{SoundType: RAPlaySoundTypeText, Val: `Escaped \{{gold}}`},
+		{SoundType: RAPlaySoundTypeText, Val: "{{#if gold > 1}}Rich"},
+	}
+
+	invalid := []Diagnostic{}
+	for _, d := range Validate(project) {
+		if d.Code == DiagnosticInvalidSoundTemplate {
+			invalid = append(invalid, d)
+		}
+	}
+	if len(invalid) != 1 || invalid[0].EntityID != validateID(11) {
+		t.Fatalf("Expected an invalid sound template of %v, received %+v", validateID(11), invalid)
+	}
+}
+
+func TestValidateConditions(t *testing.T) {
+	project := testValidateProject()
+	statements := *project.Zones[1].Triggers[TriggerEnterZone].Statements
+	statements[0] = append(statements[0], RawLStatement{Condition: &Condition{Type: ConditionNot}})
+
+	invalid := []Diagnostic{}
+	for _, d := range Validate(project) {
+		if d.Code == DiagnosticInvalidCondition {
+			invalid = append(invalid, d)
+		}
+	}
+	if len(invalid) != 1 || invalid[0].EntityID != validateID(2) {
+		t.Fatalf("Expected an invalid condition of %v, received %+v", validateID(2), invalid)
+	}
+}
+
+func TestValidateLegacyVariables(t *testing.T) {
+	statements := RawLStatementUnified{}
+	if err := statements.Scan([]byte(`[[{"Operators": [{"gt": {"1": 100}, "eq": {"2": "sword"}}], "Exec": {}}]]`)); err != nil {
+		t.Fatal(err)
+	}
+	project := testValidateProject()
+	project.Variables = []ProjectVariable{{Name: "gold", LegacyID: 1}}
+	project.Zones = []Zone{{Model: Model{ID: validateID(1)}, Title: "Start", Triggers: map[TriggerType]Trigger{
+		TriggerEnterZone: {TriggerType: TriggerEnterZone, RawLBlock: RawLBlock{Statements: &statements}},
+	}}}
+	project.Actors = nil
+
+	diagnostics := []Diagnostic{}
+	for _, d := range Validate(project) {
+		if d.Code == DiagnosticUndeclaredVariable {
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	if len(diagnostics) != 1 || diagnostics[0].Message != `Trigger 1 of Start reads the undeclared variable "2"` {
+		t.Fatalf("Expected only the legacy variable 2 to be undeclared, received %+v", diagnostics)
+	}
+
+	// Lakshmi renames the legacy variables, so that they are evaluated against the variables by name
+	condition := statements[0][0].Condition
+	condition.RenameVariables(LegacyVariableNames(project.Variables))
+	state := MutableAIRequestState{ARVariables: map[string]*ARVariable{
+		"gold": {T: "int", Val: int64(150)},
+		"2":    {T: "string", Val: "sword"},
+	}}
+	if !condition.Evaluate(&state) {
+		t.Errorf("Expected the renamed condition %+v to yield true", condition)
+	}
+}
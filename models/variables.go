@@ -0,0 +1,451 @@
+package models
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	utilities "github.com/talkative-ai/core"
+	"github.com/talkative-ai/core/redis"
+)
+
+// ARVariable types
+// Every ARVariable holds its value as the Go type noted
+const (
+	// ARVariableTypeInt int64
+	ARVariableTypeInt = "int"
+	// ARVariableTypeFloat float64
+	ARVariableTypeFloat = "float"
+	// ARVariableTypeBool bool
+	ARVariableTypeBool = "bool"
+	// ARVariableTypeString string
+	ARVariableTypeString = "string"
+	// ARVariableTypeList []ARVariable
+	ARVariableTypeList = "array"
+	// ARVariableTypeMap map[string]ARVariable
+	ARVariableTypeMap = "map"
+)
+
+// ARVariable is a typed runtime variable of an AIRequest
+// T is one of the ARVariableType constants
+type ARVariable struct {
+	T   string
+	Val interface{}
+}
+
+// NewARVariable returns an ARVariable of type T holding val converted to the Go type of T
+func NewARVariable(T string, val interface{}) (ARVariable, error) {
+	v, err := normalizeARValue(T, val)
+	if err != nil {
+		return ARVariable{}, err
+	}
+	return ARVariable{T: T, Val: v}, nil
+}
+
+// zeroARValue returns the zero value of type T
+func zeroARValue(T string) (interface{}, error) {
+	switch T {
+	case ARVariableTypeInt:
+		return int64(0), nil
+	case ARVariableTypeFloat:
+		return float64(0), nil
+	case ARVariableTypeBool:
+		return false, nil
+	case ARVariableTypeString:
+		return "", nil
+	case ARVariableTypeList:
+		return []ARVariable{}, nil
+	case ARVariableTypeMap:
+		return map[string]ARVariable{}, nil
+	}
+	return nil, fmt.Errorf("Unknown ARVariable type %q", T)
+}
+
+// normalizeARValue converts val to the Go type of T
+// Numbers decoded from JSON, binaries or older states are accepted if they fit the type
+func normalizeARValue(T string, val interface{}) (interface{}, error) {
+	if val == nil {
+		return zeroARValue(T)
+	}
+	if n, ok := val.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			val = i
+		} else if f, err := n.Float64(); err == nil {
+			val = f
+		}
+	}
+
+	mismatch := fmt.Errorf("Invalid value %#v for ARVariable type %q", val, T)
+	switch T {
+	case ARVariableTypeInt:
+		if n, ok := toInt64(val); ok {
+			return n, nil
+		}
+	case ARVariableTypeFloat:
+		if n, ok := toFloat64(val); ok {
+			return n, nil
+		}
+	case ARVariableTypeBool:
+		if b, ok := val.(bool); ok {
+			return b, nil
+		}
+	case ARVariableTypeString:
+		if s, ok := val.(string); ok {
+			return s, nil
+		}
+	case ARVariableTypeList:
+		if arr, ok := val.([]ARVariable); ok {
+			normalized := make([]ARVariable, len(arr))
+			for i, v := range arr {
+				n, err := NewARVariable(v.T, v.Val)
+				if err != nil {
+					return nil, err
+				}
+				normalized[i] = n
+			}
+			return normalized, nil
+		}
+	case ARVariableTypeMap:
+		if m, ok := val.(map[string]ARVariable); ok {
+			normalized := make(map[string]ARVariable, len(m))
+			for k, v := range m {
+				n, err := NewARVariable(v.T, v.Val)
+				if err != nil {
+					return nil, err
+				}
+				normalized[k] = n
+			}
+			return normalized, nil
+		}
+	default:
+		return nil, fmt.Errorf("Unknown ARVariable type %q", T)
+	}
+	return nil, mismatch
+}
+
+// Get returns the value of the ARVariable as the Go type of its type
+// If the value does not match the type, the value is returned as is
+// so that the caller may report the mismatch
+func (arv *ARVariable) Get() interface{} {
+	v, err := normalizeARValue(arv.T, arv.Val)
+	if err != nil {
+		return arv.Val
+	}
+	return v
+}
+
+// MarshalJSON encodes the ARVariable as {"T": type, "Val": value}
+// Lists and maps encode their elements as ARVariables, and map keys are sorted
+func (arv ARVariable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		T   string
+		Val interface{}
+	}{arv.T, arv.Get()})
+}
+
+// UnmarshalJSON decodes an ARVariable encoded by MarshalJSON
+// The value is converted to the Go type of its type, so an int stays an int64
+// A missing or null value decodes as the zero value of the type
+func (arv *ARVariable) UnmarshalJSON(data []byte) error {
+	raw := struct {
+		T   string
+		Val json.RawMessage
+	}{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	var val interface{}
+	if len(raw.Val) > 0 && string(raw.Val) != "null" {
+		switch raw.T {
+		case ARVariableTypeList:
+			arr := []ARVariable{}
+			if err := json.Unmarshal(raw.Val, &arr); err != nil {
+				return err
+			}
+			val = arr
+		case ARVariableTypeMap:
+			m := map[string]ARVariable{}
+			if err := json.Unmarshal(raw.Val, &m); err != nil {
+				return err
+			}
+			val = m
+		default:
+			decoder := json.NewDecoder(bytes.NewReader(raw.Val))
+			decoder.UseNumber()
+			if err := decoder.Decode(&val); err != nil {
+				return err
+			}
+		}
+	}
+
+	v, err := NewARVariable(raw.T, val)
+	if err != nil {
+		return err
+	}
+	*arv = v
+	return nil
+}
+
+func (arv *ARVariable) Scan(src interface{}) error {
+	return json.Unmarshal(src.([]byte), arv)
+}
+
+func (arv ARVariable) Value() (driver.Value, error) {
+	return json.Marshal(arv)
+}
+
+// Compile returns the compiled []byte slice of the ARVariable
+//
+// The layout is as follows:
+// [2 bytes: T length][T] followed by the compiled value
+// Floats are always compiled as floats, even if integral
+// Lists are compiled as [2 bytes: number of elements] followed by each compiled ARVariable
+// Maps are compiled as [2 bytes: number of entries] followed by each
+// [2 bytes: key length][key][compiled ARVariable], sorted by key
+// The ARVariable is expected to pass checkCompiled
+func (arv ARVariable) Compile() []byte {
+	compiled := appendCompiledString([]byte{}, arv.T)
+	val := arv.Get()
+	lenBytes := make([]byte, 2)
+
+	switch v := val.(type) {
+	case float64:
+		valBytes := make([]byte, 8)
+		binary.LittleEndian.PutUint64(valBytes, math.Float64bits(v))
+		compiled = append(compiled, compiledValueFloat)
+		return append(compiled, valBytes...)
+	case []ARVariable:
+		binary.LittleEndian.PutUint16(lenBytes, uint16(len(v)))
+		compiled = append(compiled, lenBytes...)
+		for _, el := range v {
+			compiled = append(compiled, el.Compile()...)
+		}
+		return compiled
+	case map[string]ARVariable:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		binary.LittleEndian.PutUint16(lenBytes, uint16(len(keys)))
+		compiled = append(compiled, lenBytes...)
+		for _, k := range keys {
+			compiled = appendCompiledString(compiled, k)
+			compiled = append(compiled, v[k].Compile()...)
+		}
+		return compiled
+	}
+
+	return appendCompiledValue(compiled, val)
+}
+
+// checkCompiled returns an error if the ARVariable does not fit the layout of ARVariable.Compile
+func (arv ARVariable) checkCompiled() error {
+	if err := checkCompiledString(arv.T, "Variable type"); err != nil {
+		return err
+	}
+
+	switch v := arv.Get().(type) {
+	case float64:
+		return nil
+	case []ARVariable:
+		if err := checkCompiledCount(len(v), math.MaxUint16, "elements within a list"); err != nil {
+			return err
+		}
+		for _, el := range v {
+			if err := el.checkCompiled(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]ARVariable:
+		if err := checkCompiledCount(len(v), math.MaxUint16, "entries within a map"); err != nil {
+			return err
+		}
+		for k, el := range v {
+			if err := checkCompiledString(k, "Map key"); err != nil {
+				return err
+			}
+			if err := el.checkCompiled(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return checkCompiledValue(arv.Get())
+}
+
+// readCompiledARVariable reads an ARVariable compiled by ARVariable.Compile
+func readCompiledARVariable(r *utilities.ByteReader) (*ARVariable, error) {
+	t, err := r.LengthPrefixedString()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading ARVariable type: %s", err.Error())
+	}
+
+	var val interface{}
+	switch t {
+	case ARVariableTypeList, ARVariableTypeMap:
+		n, err := r.Uint16()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading ARVariable %v length: %s", t, err.Error())
+		}
+		// Every element is at least 3 bytes, so a longer list cannot fit
+		if uint64(n)*3 > r.Remaining() {
+			return nil, fmt.Errorf("Error reading ARVariable %v: %s", t, utilities.ErrTruncated.Error())
+		}
+		if t == ARVariableTypeList {
+			arr := make([]ARVariable, n)
+			for i := range arr {
+				v, err := readCompiledARVariable(r)
+				if err != nil {
+					return nil, err
+				}
+				arr[i] = *v
+			}
+			val = arr
+			break
+		}
+		m := make(map[string]ARVariable, n)
+		for i := 0; i < int(n); i++ {
+			k, err := r.LengthPrefixedString()
+			if err != nil {
+				return nil, fmt.Errorf("Error reading ARVariable map key: %s", err.Error())
+			}
+			v, err := readCompiledARVariable(r)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = *v
+		}
+		val = m
+	default:
+		val, err = readCompiledValue(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	arv, err := NewARVariable(t, val)
+	if err != nil {
+		return nil, err
+	}
+	return &arv, nil
+}
+
+// ProjectVariableArray is the JSON friendly list of a project's variables
+type ProjectVariableArray []ProjectVariable
+
+func (a *ProjectVariableArray) Scan(src interface{}) error {
+	return json.Unmarshal(src.([]byte), &a)
+}
+
+func (a ProjectVariableArray) Value() (driver.Value, error) {
+	return json.Marshal(a)
+}
+
+// Validate returns an error if the variable has no name or an invalid initial value
+// or if the initial value is too large to be compiled
+func (variable ProjectVariable) Validate() error {
+	if variable.Name == "" {
+		return fmt.Errorf("Missing variable name")
+	}
+	if err := checkCompiledString(variable.Name, "Variable name"); err != nil {
+		return err
+	}
+	if _, err := normalizeARValue(variable.Initial.T, variable.Initial.Val); err != nil {
+		return fmt.Errorf("Invalid variable %q: %s", variable.Name, err.Error())
+	}
+	if err := variable.Initial.checkCompiled(); err != nil {
+		return fmt.Errorf("Invalid variable %q: %s", variable.Name, err.Error())
+	}
+	return nil
+}
+
+// LegacyVariableNames maps the LegacyID of each variable, as a condition refers to it, to the variable name
+// Used by Lakshmi with Condition.RenameVariables, as the runtime state is keyed by name
+func LegacyVariableNames(variables []ProjectVariable) map[string]string {
+	names := map[string]string{}
+	for _, variable := range variables {
+		if variable.LegacyID != 0 {
+			names[strconv.Itoa(variable.LegacyID)] = variable.Name
+		}
+	}
+	return names
+}
+
+// CompileProjectVariables is used by Lakshmi to compile the variable declarations
+// into the "variables" field of the static project metadata
+//
+// The layout is as follows:
+// [2 bytes: number of variables] followed by each
+// [2 bytes: name length][name][compiled initial ARVariable], sorted by name
+func CompileProjectVariables(variables []ProjectVariable) ([]byte, error) {
+	sorted := append([]ProjectVariable{}, variables...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	if err := checkCompiledCount(len(sorted), math.MaxUint16, "variables"); err != nil {
+		return nil, err
+	}
+
+	compiled := make([]byte, 2)
+	binary.LittleEndian.PutUint16(compiled, uint16(len(sorted)))
+	for i, variable := range sorted {
+		if err := variable.Validate(); err != nil {
+			return nil, err
+		}
+		if i > 0 && sorted[i-1].Name == variable.Name {
+			return nil, fmt.Errorf("Duplicate variable %q", variable.Name)
+		}
+		compiled = appendCompiledString(compiled, variable.Name)
+		compiled = append(compiled, variable.Initial.Compile()...)
+	}
+	return compiled, nil
+}
+
+// readCompiledProjectVariables reads the variables compiled by CompileProjectVariables
+// Returns a new map of ARVariables keyed by name, set to their initial values
+func readCompiledProjectVariables(compiled []byte) (map[string]*ARVariable, error) {
+	r := utilities.NewByteReader(compiled)
+	n, err := r.Uint16()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading variable count: %s", err.Error())
+	}
+	vars := map[string]*ARVariable{}
+	for i := 0; i < int(n); i++ {
+		name, err := r.LengthPrefixedString()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading variable name: %s", err.Error())
+		}
+		vars[name], err = readCompiledARVariable(r)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading variable %q: %s", name, err.Error())
+		}
+	}
+	return vars, nil
+}
+
+// seedProjectVariables replaces the ARVariables of the request
+// with the initial values of the published project's variables
+func seedProjectVariables(message *AIRequest) error {
+	compiled, err := redis.Runtime.HGet(KeynavProjectMetadataStatic(message.State.PubID), "variables")
+	if err != nil {
+		return newRuntimeError(RuntimeErrorStore, "Error fetching project variables: %s", err.Error())
+	}
+	if compiled == "" {
+		// Projects published before variables were declared
+		message.State.ARVariables = map[string]*ARVariable{}
+		return nil
+	}
+	vars, err := readCompiledProjectVariables([]byte(compiled))
+	if err != nil {
+		return newRuntimeError(RuntimeErrorMalformed, "%s", err.Error())
+	}
+	message.State.ARVariables = vars
+	return nil
+}
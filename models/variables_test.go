@@ -0,0 +1,230 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	utilities "github.com/talkative-ai/core"
+	"github.com/talkative-ai/core/redis"
+	ssml "github.com/talkative-ai/go-ssml"
+)
+
+func TestARVariableJSON(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected ARVariable
+	}{
+		{`{"T":"int","Val":42}`, ARVariable{T: "int", Val: int64(42)}},
+		{`{"T":"int","Val":null}`, ARVariable{T: "int", Val: int64(0)}},
+		{`{"T":"float","Val":1.5}`, ARVariable{T: "float", Val: 1.5}},
+		{`{"T":"float","Val":2}`, ARVariable{T: "float", Val: float64(2)}},
+		{`{"T":"bool","Val":true}`, ARVariable{T: "bool", Val: true}},
+		{`{"T":"string","Val":"sword"}`, ARVariable{T: "string", Val: "sword"}},
+		{`{"T":"array","Val":[{"T":"int","Val":1},{"T":"string","Val":"bow"}]}`,
+			ARVariable{T: "array", Val: []ARVariable{{T: "int", Val: int64(1)}, {T: "string", Val: "bow"}}}},
+		{`{"T":"map","Val":{"gold":{"T":"int","Val":3},"name":{"T":"string","Val":"Ada"}}}`,
+			ARVariable{T: "map", Val: map[string]ARVariable{
+				"gold": {T: "int", Val: int64(3)},
+				"name": {T: "string", Val: "Ada"},
+			}}},
+	}
+
+	for _, test := range tests {
+		arv := ARVariable{}
+		if err := json.Unmarshal([]byte(test.json), &arv); err != nil {
+			t.Fatalf("%v: %v", test.json, err)
+		}
+		if !reflect.DeepEqual(arv, test.expected) {
+			t.Errorf("%v: expected %#v, received %#v", test.json, test.expected, arv)
+		}
+
+		// Encoding is stable across a round trip
+		encoded, err := json.Marshal(arv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reloaded := ARVariable{}
+		if err := json.Unmarshal(encoded, &reloaded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(reloaded, test.expected) {
+			t.Errorf("%s: expected %#v after reload, received %#v", encoded, test.expected, reloaded)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"T":"int","Val":1.5}`,
+		`{"T":"bool","Val":"yes"}`,
+		`{"T":"string","Val":7}`,
+		`{"T":"array","Val":[{"T":"int","Val":"one"}]}`,
+		`{"T":"unknown","Val":1}`,
+	} {
+		if err := json.Unmarshal([]byte(invalid), &ARVariable{}); err == nil {
+			t.Errorf("%v: expected an error", invalid)
+		}
+	}
+}
+
+func TestARVariableStateReload(t *testing.T) {
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = redis.NewMemoryStore()
+
+	state := MutableAIRequestState{ARVariables: map[string]*ARVariable{
+		"gold":  {T: "int", Val: 10},
+		"ratio": {T: "float", Val: 0.5},
+	}}
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := MutableAIRequestState{}
+	if err := json.Unmarshal(encoded, &reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := reloaded.ARVariables["gold"].Val.(int64); !ok || v != 10 {
+		t.Errorf("Expected int64 10, received %#v", reloaded.ARVariables["gold"].Val)
+	}
+
+	// Arithmetic keeps working after the state is reloaded
+	message := AIRequest{State: reloaded, OutputSSML: ssml.NewBuilder()}
+	add := RASetVariable{Target: "gold", Operation: SVOAdd}
+	add.With.ARVariable = &ARVariable{T: "int", Val: int64(5)}
+	if err := add.Execute(&message); err != nil {
+		t.Fatal(err)
+	}
+	if v := message.State.ARVariables["gold"].Get(); v != int64(15) {
+		t.Errorf("Expected 15, received %#v", v)
+	}
+
+	multiply := RASetVariable{Target: "ratio", Operation: SVOMultiply}
+	multiply.With.ARVariable = &ARVariable{T: "int", Val: int64(3)}
+	if err := multiply.Execute(&message); err != nil {
+		t.Fatal(err)
+	}
+	if v := message.State.ARVariables["ratio"].Get(); v != 1.5 {
+		t.Errorf("Expected 1.5, received %#v", v)
+	}
+}
+
+func TestARVariableCompile(t *testing.T) {
+	for _, arv := range []ARVariable{
+		{T: "int", Val: int64(-7)},
+		{T: "float", Val: float64(3)},
+		{T: "float", Val: 0.25},
+		{T: "bool", Val: true},
+		{T: "string", Val: "lantern"},
+		{T: "array", Val: []ARVariable{{T: "int", Val: int64(1)}, {T: "float", Val: float64(2)}}},
+		{T: "map", Val: map[string]ARVariable{
+			"b": {T: "bool", Val: false},
+			"a": {T: "array", Val: []ARVariable{}},
+		}},
+	} {
+		compiled := arv.Compile()
+		// Map entries are sorted, so compiling twice is identical
+		if string(compiled) != string(arv.Compile()) {
+			t.Errorf("%#v: unstable compiled form", arv)
+		}
+		decoded, err := readCompiledARVariable(utilities.NewByteReader(compiled))
+		if err != nil {
+			t.Fatalf("%#v: %v", arv, err)
+		}
+		if !reflect.DeepEqual(*decoded, arv) {
+			t.Errorf("Expected %#v, received %#v", arv, *decoded)
+		}
+	}
+}
+
+func TestRAResetAppSeedsVariables(t *testing.T) {
+	store := redis.NewMemoryStore()
+	defer func(original redis.Store) { redis.Runtime = original }(redis.Runtime)
+	redis.Runtime = store
+
+	pubID := "1"
+	compiled, err := CompileProjectVariables([]ProjectVariable{
+		{Name: "gold", Initial: ARVariable{T: "int", Val: int64(10)}},
+		{Name: "inventory", Initial: ARVariable{T: "array", Val: []ARVariable{{T: "string", Val: "map"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.HSet(KeynavProjectMetadataStatic(pubID), "variables", compiled)
+
+	message := AIRequest{
+		State: MutableAIRequestState{PubID: pubID, ARVariables: map[string]*ARVariable{
+			"gold":  {T: "int", Val: int64(99)},
+			"stale": {T: "bool", Val: true},
+		}},
+		OutputSSML: ssml.NewBuilder(),
+	}
+	reset := RAResetApp(true)
+	if err := reset.Execute(&message); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]*ARVariable{
+		"gold":      {T: "int", Val: int64(10)},
+		"inventory": {T: "array", Val: []ARVariable{{T: "string", Val: "map"}}},
+	}
+	if !reflect.DeepEqual(message.State.ARVariables, expected) {
+		t.Errorf("Expected %v, received %v", expected, message.State.ARVariables)
+	}
+
+	// Each reset seeds fresh copies of the initial values
+	message.State.ARVariables["gold"].Val = int64(0)
+	if err := reset.Execute(&message); err != nil {
+		t.Fatal(err)
+	}
+	if v := message.State.ARVariables["gold"].Get(); v != int64(10) {
+		t.Errorf("Expected 10 after a second reset, received %#v", v)
+	}
+}
+
+func TestCompileProjectVariablesInvalid(t *testing.T) {
+	longList := make([]ARVariable, math.MaxUint16+1)
+	for i := range longList {
+		longList[i] = ARVariable{T: "int", Val: int64(i)}
+	}
+	longMap := map[string]ARVariable{}
+	for i := 0; i <= math.MaxUint16; i++ {
+		longMap[strconv.Itoa(i)] = ARVariable{T: "int", Val: int64(i)}
+	}
+	tooMany := make([]ProjectVariable, math.MaxUint16+1)
+	for i := range tooMany {
+		tooMany[i] = ProjectVariable{Name: strconv.Itoa(i), Initial: ARVariable{T: "int"}}
+	}
+
+	for _, variables := range [][]ProjectVariable{
+		{{Name: "", Initial: ARVariable{T: "int", Val: int64(1)}}},
+		{{Name: "gold", Initial: ARVariable{T: "int", Val: "ten"}}},
+		{{Name: "gold", Initial: ARVariable{T: "int"}}, {Name: "gold", Initial: ARVariable{T: "int"}}},
+		{{Name: "list", Initial: ARVariable{T: ARVariableTypeList, Val: longList}}},
+		{{Name: "nested", Initial: ARVariable{T: ARVariableTypeList, Val: []ARVariable{{T: ARVariableTypeList, Val: longList}}}}},
+		{{Name: "map", Initial: ARVariable{T: ARVariableTypeMap, Val: longMap}}},
+		{{Name: "text", Initial: ARVariable{T: "string", Val: strings.Repeat("a", math.MaxUint16+1)}}},
+		tooMany,
+	} {
+		if _, err := CompileProjectVariables(variables); err == nil {
+			t.Errorf("Expected an error compiling %v", variables)
+		}
+	}
+}
+
+func TestRASetVariableValidate(t *testing.T) {
+	list := make([]ARVariable, math.MaxUint16+1)
+	for i := range list {
+		list[i] = ARVariable{T: "int", Val: int64(i)}
+	}
+	tooLong := RASetVariable{Target: "inventory", With: ParametizedARVariable{ARVariable: &ARVariable{T: ARVariableTypeList, Val: list}}}
+	if err := (ActionSet{SetGlobalVariables: []RASetVariable{tooLong}}).Validate(); err == nil {
+		t.Error("Expected an error validating a list with too many elements")
+	}
+
+	valid := RASetVariable{Target: "inventory", With: ParametizedARVariable{ARVariable: &ARVariable{T: ARVariableTypeList, Val: list[:math.MaxUint16]}}}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Unexpected error validating a list at the maximum length: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package redis
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis"
+)
+
+// MemoryStore is an in-memory Store for testing the runtime without Redis
+// Set members are returned in the order they were added
+type MemoryStore struct {
+	mutex   sync.RWMutex
+	strings map[string][]byte
+	hashes  map[string]map[string]string
+	sets    map[string][]string
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		strings: map[string][]byte{},
+		hashes:  map[string]map[string]string{},
+		sets:    map[string][]string{},
+	}
+}
+
+// Set sets the value of key
+func (m *MemoryStore) Set(key string, value []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.strings[key] = value
+}
+
+// HSet sets the value of the field within the hash at key
+func (m *MemoryStore) HSet(key, field string, value []byte) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.hashes[key] == nil {
+		m.hashes[key] = map[string]string{}
+	}
+	m.hashes[key][field] = string(value)
+}
+
+// SAdd adds the members to the set at key
+func (m *MemoryStore) SAdd(key string, members ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, member := range members {
+		exists := false
+		for _, existing := range m.sets[key] {
+			if existing == member {
+				exists = true
+				break
+			}
+		}
+		if !exists {
+			m.sets[key] = append(m.sets[key], member)
+		}
+	}
+}
+
+func (m *MemoryStore) Get(key string) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	val, ok := m.strings[key]
+	if !ok {
+		return nil, redis.Nil
+	}
+	return val, nil
+}
+
+func (m *MemoryStore) HGet(key, field string) (string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.hashes[key][field], nil
+}
+
+func (m *MemoryStore) HGetAll(key string) (map[string]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	hash := map[string]string{}
+	for field, val := range m.hashes[key] {
+		hash[field] = val
+	}
+	return hash, nil
+}
+
+func (m *MemoryStore) SMembers(key string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return append([]string{}, m.sets[key]...), nil
+}
+
+// Keys returns every key beginning with prefix, sorted
+func (m *MemoryStore) Keys(prefix string) ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	keys := []string{}
+	for key := range m.strings {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.hashes {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.sets {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *MemoryStore) Type(key string) (string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if _, ok := m.strings[key]; ok {
+		return "string", nil
+	}
+	if _, ok := m.hashes[key]; ok {
+		return "hash", nil
+	}
+	if _, ok := m.sets[key]; ok {
+		return "set", nil
+	}
+	return "none", nil
+}
+
+func (m *MemoryStore) Write(key string, value []byte) error {
+	m.Set(key, value)
+	return nil
+}
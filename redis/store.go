@@ -0,0 +1,89 @@
+package redis
+
+import (
+	"github.com/go-redis/redis"
+)
+
+// Nil is returned by Store.Get when the key does not exist
+var Nil = redis.Nil
+
+// Store is the subset of Redis commands used by the Brahman runtime
+// This allows the runtime to be evaluated against an in-memory stand-in
+type Store interface {
+	// Get returns the value of key, or redis.Nil if the key does not exist
+	Get(key string) ([]byte, error)
+	// HGet returns the value of the field within the hash at key,
+	// or an empty string if either does not exist
+	HGet(key, field string) (string, error)
+	// HGetAll returns every field and value within the hash at key
+	HGetAll(key string) (map[string]string, error)
+	// SMembers returns every member of the set at key
+	SMembers(key string) ([]string, error)
+}
+
+// ScanStore is a Store which can also enumerate keys
+// Used by utilities that inspect compiled data
+type ScanStore interface {
+	Store
+	// Keys returns every key beginning with prefix
+	Keys(prefix string) ([]string, error)
+	// Type returns the Redis type of key e.g. "string", "hash", "set" or "none"
+	Type(key string) (string, error)
+}
+
+// MigrationStore is a ScanStore which can also rewrite keys
+// Used by utilities that migrate compiled data in place
+type MigrationStore interface {
+	ScanStore
+	// Write sets the value of key, keeping no expiry
+	Write(key string, value []byte) error
+}
+
+// NewClientStore returns a MigrationStore backed by the Redis Instance
+func NewClientStore() MigrationStore {
+	return clientStore{}
+}
+
+// Runtime is the Store used by the Brahman runtime
+// Defaults to the Redis Instance. Tests may replace it with a MemoryStore
+var Runtime Store = clientStore{}
+
+// clientStore implements Store with the Redis Instance
+type clientStore struct{}
+
+func (clientStore) Get(key string) ([]byte, error) {
+	return Instance.Get(key).Bytes()
+}
+
+func (clientStore) HGet(key, field string) (string, error) {
+	val, err := Instance.HGet(key, field).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return val, err
+}
+
+func (clientStore) HGetAll(key string) (map[string]string, error) {
+	return Instance.HGetAll(key).Result()
+}
+
+func (clientStore) SMembers(key string) ([]string, error) {
+	return Instance.SMembers(key).Result()
+}
+
+func (clientStore) Keys(prefix string) ([]string, error) {
+	keys := []string{}
+	iter := Instance.Scan(0, prefix+"*", 1000).Iterator()
+	for iter.Next() {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
+func (clientStore) Type(key string) (string, error) {
+	return Instance.Type(key).Result()
+}
+
+func (clientStore) Write(key string, value []byte) error {
+	return Instance.Set(key, value, 0).Err()
+}
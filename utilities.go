@@ -1,7 +1,6 @@
 package utilities
 
 import (
-	"bytes"
 	"crypto/rand"
 	"encoding/base64"
 	"fmt"
@@ -22,7 +21,7 @@ func ParseJTWClaims(tokenString string) (map[string]interface{}, error) {
 	})
 
 	if err != nil {
-		return nil, fmt.Errorf("JWT_INVALID", err)
+		return nil, fmt.Errorf("JWT_INVALID: %s", err.Error())
 	}
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
@@ -45,33 +44,3 @@ func GenerateRandomString(s int) (string, error) {
 	b, err := GenerateRandomBytes(s)
 	return base64.URLEncoding.EncodeToString(b), err
 }
-
-type ByteReader struct {
-	Reader   *bytes.Reader
-	Position uint64
-}
-
-func (br *ByteReader) ReadNBytes(n uint64) ([]byte, error) {
-	bslice := []byte{}
-
-	for i := uint64(0); i < n; i++ {
-		b, err := br.Reader.ReadByte()
-		if err != nil {
-			return []byte{}, err
-		}
-		bslice = append(bslice, b)
-	}
-
-	br.Position += n
-
-	return bslice, nil
-}
-
-func (br *ByteReader) ReadByte() (byte, error) {
-	br.Position++
-	return br.Reader.ReadByte()
-}
-
-func (br *ByteReader) Finished() bool {
-	return br.Reader.Len() <= 0
-}